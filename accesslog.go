@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultAccessLogSkipPaths are left out of the access log unless ACCESS_LOG_SKIP_PATHS is set,
+// so liveness probes and metric scrapes don't drown out real traffic
+const defaultAccessLogSkipPaths = "/ping,/metrics"
+
+// accessLogMiddleware logs one structured line per request with its method, path, status, latency,
+// client IP and request ID. Paths listed in ACCESS_LOG_SKIP_PATHS (comma-separated; empty logs everything) are skipped.
+func accessLogMiddleware() fiber.Handler {
+	pathList, ok := os.LookupEnv("ACCESS_LOG_SKIP_PATHS")
+	if !ok {
+		pathList = defaultAccessLogSkipPaths
+	}
+	skipPaths := make(map[string]bool)
+	for _, path := range strings.Split(pathList, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			skipPaths[path] = true
+		}
+	}
+	slog.Info("Access log enabled", "skip_paths", pathList)
+
+	return func(c *fiber.Ctx) error {
+		if skipPaths[c.Path()] {
+			return c.Next()
+		}
+
+		started := time.Now()
+		err := c.Next()
+
+		// Errors are turned into responses by Fiber's error handler after middleware returns
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				status = fiberErr.Code
+			}
+		}
+
+		// Log the route pattern for parameterized routes so emails in the path stay out of the log
+		path := c.Path()
+		if route := c.Route(); route != nil && strings.Contains(route.Path, ":") {
+			path = route.Path
+		}
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(context.Background(), level, "HTTP request",
+			"method", c.Method(),
+			"path", path,
+			"status", status,
+			"latency_ms", float64(time.Since(started).Microseconds())/1000,
+			"ip", clientIP(c),
+			"request_id", requestID(c),
+		)
+		return err
+	}
+}
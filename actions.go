@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// linkActionHandler applies one customer link action. from and to are only used by region moves.
+// A zero Status in the returned outcome means 200.
+type linkActionHandler func(ctx context.Context, email, from, to string) linkActionOutcome
+
+// applyActionFunc makes an action's Customer.io change for a customer and records the result.
+// details carries action-specific input, e.g. {"from":"BBUS","to":"BBUK"} for region moves.
+type applyActionFunc func(ctx context.Context, email, details string) error
+
+// actionDefinition describes one action: the name used in links and code, the name it is recorded
+// under in the database, and for link actions how it is confirmed, performed and reported.
+type actionDefinition struct {
+	name     string // Request action name (action= link parameter, bulk and webhook actions)
+	dbAction string // Name stored in email_processing_records and summarized on /results; "" when never recorded
+
+	link           linkActionHandler                           // Performs the action for ?action= links; nil when links can't trigger it
+	apply          applyActionFunc                             // The Customer.io change behind link, rerun by the retry queue
+	confirmPrompt  func(locale, email, from, to string) string // Question shown before a link action is applied
+	successMessage string                                      // Shown after the link action succeeds; %s is the email
+	repeatable     bool                                        // Consecutive requests are distinct, so the idempotency window doesn't apply
+}
+
+// actionDefinitions lists every action, in the order the results summary shows them. Adding an action is
+// one entry here. It is filled in by init because the link handlers record their results via
+// dbActionName, which reads this table, and a package-level initializer would be a cycle.
+var actionDefinitions []actionDefinition
+
+func init() {
+	actionDefinitions = []actionDefinition{
+		{
+			name:           "pause",
+			dbAction:       "PAUSE",
+			link:           linkPause,
+			apply:          applyPause,
+			confirmPrompt:  func(locale, email, _, _ string) string { return translate(locale, "Pause emails for %s?", email) },
+			successMessage: "Customer (%s) has been paused.",
+		},
+		{
+			name:     "international",
+			dbAction: "BBAU",
+			link:     linkInternational,
+			apply:    applyInternational,
+			confirmPrompt: func(locale, email, _, _ string) string {
+				return translate(locale, "Move %s to the Australian/International list?", email)
+			},
+			successMessage: "Customer (%s) moved to Australian/International list.",
+		},
+		{
+			name:     "unsubscribe",
+			dbAction: "UNSUBSCRIBE",
+			link:     linkUnsubscribe,
+			apply:    applyUnsubscribe,
+			confirmPrompt: func(locale, email, _, _ string) string {
+				return translate(locale, "Are you sure you want to unsubscribe %s?", email)
+			},
+			successMessage: "Customer (%s) has been unsubscribed.",
+		},
+		{name: "subscription_update", dbAction: "SUBSCRIPTION_UPDATE"},
+		{name: "unsubscribe_all", dbAction: "UNSUBSCRIBE_ALL", apply: applyUnsubscribeAll},
+		{
+			name:           "resubscribe",
+			dbAction:       "RESUBSCRIBE",
+			link:           linkResubscribe,
+			apply:          applyResubscribe,
+			confirmPrompt:  func(locale, email, _, _ string) string { return translate(locale, "Resubscribe %s to emails?", email) },
+			successMessage: "Customer (%s) has been resubscribed.",
+		},
+		{
+			name:     "region",
+			dbAction: "REGION_MOVE",
+			link:     linkRegion,
+			apply:    applyRegion,
+			confirmPrompt: func(locale, email, from, to string) string {
+				return translate(locale, "Move %s from %s to %s?", email, strings.ToUpper(from), strings.ToUpper(to))
+			},
+			repeatable: true,
+		},
+		{name: "cio_unsubscribed", dbAction: "CIO_UNSUBSCRIBED"},
+		{name: "cio_spam_reported", dbAction: "CIO_SPAM_REPORTED"},
+		{name: "cio_bounced", dbAction: "CIO_BOUNCED"},
+		{
+			name:           "unpause",
+			dbAction:       "UNPAUSE",
+			link:           linkUnpause,
+			apply:          applyUnpause,
+			confirmPrompt:  func(locale, email, _, _ string) string { return translate(locale, "Resume emails for %s?", email) },
+			successMessage: "Customer (%s) has been unpaused.",
+		},
+	}
+}
+
+// findAction returns the definition of a request action name
+func findAction(name string) (actionDefinition, bool) {
+	for _, definition := range actionDefinitions {
+		if definition.name == name {
+			return definition, true
+		}
+	}
+	return actionDefinition{}, false
+}
+
+// findLinkAction returns the definition of an action that customer links may trigger
+func findLinkAction(name string) (actionDefinition, bool) {
+	definition, ok := findAction(name)
+	if !ok || definition.link == nil {
+		return actionDefinition{}, false
+	}
+	return definition, true
+}
+
+// recordedActions returns the database action names of every recorded action, in summary order
+func recordedActions() []string {
+	var actions []string
+	for _, definition := range actionDefinitions {
+		if definition.dbAction != "" {
+			actions = append(actions, definition.dbAction)
+		}
+	}
+	return actions
+}
+
+// isRecordedAction reports whether dbAction is a database action name from actionDefinitions
+func isRecordedAction(dbAction string) bool {
+	for _, definition := range actionDefinitions {
+		if definition.dbAction != "" && definition.dbAction == dbAction {
+			return true
+		}
+	}
+	return false
+}
+
+// linkPause sets the customer's paused attribute
+func linkPause(ctx context.Context, email, _, _ string) (out linkActionOutcome) {
+	if err := applyPause(ctx, email, ""); err != nil {
+		slog.Error("Failed to update paused attribute", "email", logEmail(email), "action", "pause", "error", err)
+		out.setMessage(actionErrorMessage(err, "Error processing pause request. Check logs."))
+		out.Status, out.Err = actionErrorStatus(err), err
+		return out
+	}
+
+	out.setSuccessMessage("pause", email)
+	out.Success = true
+	slog.Info("Updated paused attribute", "email", logEmail(email), "action", "pause")
+	return out
+}
+
+// applyPause sets the customer's paused attribute and records the result
+func applyPause(ctx context.Context, email, _ string) error {
+	result, err := withExistingCustomer(ctx, email, func() (TrackResult, error) { return updateCustomerPausedAttributeByEmail(ctx, email) })
+	recordActionResult(email, "pause", result, err)
+	return err
+}
+
+// linkInternational moves the customer from the US list to the Australian/International list
+func linkInternational(ctx context.Context, email, _, _ string) (out linkActionOutcome) {
+	if err := applyInternational(ctx, email, ""); err != nil {
+		slog.Error("Failed to update relationship to BBAU", "email", logEmail(email), "action", "international", "error", err)
+		out.setMessage(actionErrorMessage(err, "Error processing international request. Check logs."))
+		out.Status, out.Err = actionErrorStatus(err), err
+		return out
+	}
+
+	out.setSuccessMessage("international", email)
+	out.Success = true
+	slog.Info("Updated relationship to BBAU", "email", logEmail(email), "action", "international")
+	return out
+}
+
+// applyInternational moves the customer from BBUS to BBAU and records the result
+func applyInternational(ctx context.Context, email, _ string) error {
+	result, err := withAnonymousProfileHandling(ctx, email, func() (TrackResult, error) { return moveCustomerRelationship(ctx, email, "BBUS", "BBAU") })
+	recordActionResultWithDetails(email, "international", regionMoveDetails("BBUS", "BBAU"), result, err)
+	return err
+}
+
+// linkRegion moves the customer between two of the REGION_OBJECT_IDS regions
+func linkRegion(ctx context.Context, email, from, to string) (out linkActionOutcome) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if !regionObjectIDs[from] || !regionObjectIDs[to] || from == to {
+		slog.Warn("Rejected region move", "email", logEmail(email), "action", "region", "from", from, "to", to)
+		out.setMessage("Invalid region move requested.")
+		out.Status, out.Err = http.StatusBadRequest, errInvalidRegionMove
+		return out
+	}
+
+	if err := applyRegion(ctx, email, regionMoveDetails(from, to)); err != nil {
+		slog.Error("Failed to move region", "email", logEmail(email), "action", "region", "from", from, "to", to, "error", err)
+		out.setMessage(actionErrorMessage(err, "Error processing region request. Check logs."))
+		out.Status, out.Err = actionErrorStatus(err), err
+		return out
+	}
+
+	out.setMessage("Customer (%s) moved from %s to %s.", email, from, to)
+	out.Success = true
+	slog.Info("Moved region", "email", logEmail(email), "action", "region", "from", from, "to", to)
+	return out
+}
+
+// regionMove is the details recorded for a move between region lists
+type regionMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// relationshipChange is one relationship a region move removes or adds, as reported by POST /international previews
+type relationshipChange struct {
+	ObjectTypeID string `json:"object_type_id"`
+	ObjectID     string `json:"object_id"`
+}
+
+// regionMovePreview returns the relationships moveCustomerRelationship would remove and add for a move,
+// without calling Customer.io
+func regionMovePreview(from, to string) (remove, add []relationshipChange) {
+	objectTypeID := customerIO.objectType("")
+	remove = []relationshipChange{{ObjectTypeID: objectTypeID, ObjectID: strings.ToUpper(from)}}
+	add = []relationshipChange{{ObjectTypeID: objectTypeID, ObjectID: strings.ToUpper(to)}}
+	return remove, add
+}
+
+// regionMoveDetails formats a region move as recorded in details, e.g. {"from":"BBUS","to":"BBUK"}
+func regionMoveDetails(from, to string) string {
+	return recordDetails(map[string]interface{}{"from": strings.ToUpper(from), "to": strings.ToUpper(to)})
+}
+
+// parseRegionMoveDetails reads the regions from region move details. The "FROM->TO" form written
+// before details were JSON is still accepted, as retries queued by older versions carry it.
+func parseRegionMoveDetails(details string) (from, to string, ok bool) {
+	var move regionMove
+	if err := json.Unmarshal([]byte(details), &move); err == nil {
+		return move.From, move.To, move.From != "" && move.To != ""
+	}
+	return strings.Cut(details, "->")
+}
+
+// linkActionDetails returns the details a link action is applied with, the region move for region links
+func linkActionDetails(action, from, to string) string {
+	if action == "region" {
+		return regionMoveDetails(from, to)
+	}
+	return ""
+}
+
+// applyRegion makes the region move described by details (see regionMoveDetails) and records the result
+func applyRegion(ctx context.Context, email, details string) error {
+	from, to, ok := parseRegionMoveDetails(details)
+	if !ok {
+		return errInvalidRegionMove
+	}
+	result, err := withAnonymousProfileHandling(ctx, email, func() (TrackResult, error) { return moveCustomerRelationship(ctx, email, from, to) })
+	recordActionResultWithDetails(email, "region", regionMoveDetails(from, to), result, err)
+	return err
+}
+
+// linkUnsubscribe unsubscribes the customer, or schedules it when UNSUBSCRIBE_GRACE_MINUTES is set.
+// Without a database (DB_OPTIONAL) there is nowhere to keep the schedule, so it unsubscribes straight away.
+func linkUnsubscribe(ctx context.Context, email, _, _ string) (out linkActionOutcome) {
+	if unsubscribeGraceMinutes > 0 && !databaseDisabled {
+		// Defer the unsubscribe so the customer can undo an accidental click
+		token, err := scheduleUnsubscribe(email)
+		if err != nil {
+			slog.Error("Failed to schedule unsubscribe", "email", logEmail(email), "action", "unsubscribe", "error", err)
+			out.setMessage("Error processing unsubscribe request. Check logs.")
+			out.Status, out.Err = http.StatusInternalServerError, err
+			return out
+		}
+
+		out.setMessage("Customer (%s) will be unsubscribed in %d minutes.", email, unsubscribeGraceMinutes)
+		out.Success = true
+		out.CancelURL = "/cancel-unsubscribe?token=" + token
+		slog.Info("Scheduled unsubscribe", "email", logEmail(email), "action", "unsubscribe", "grace_minutes", unsubscribeGraceMinutes)
+		return out
+	}
+
+	if err := applyUnsubscribe(ctx, email, ""); err != nil {
+		slog.Error("Failed to unsubscribe", "email", logEmail(email), "action", "unsubscribe", "error", err)
+		out.setMessage(actionErrorMessage(err, "Error processing unsubscribe request. Check logs."))
+		out.Status, out.Err = actionErrorStatus(err), err
+		return out
+	}
+
+	out.setSuccessMessage("unsubscribe", email)
+	out.Success = true
+	slog.Info("Unsubscribed customer", "email", logEmail(email), "action", "unsubscribe")
+	return out
+}
+
+// applyUnsubscribe unsubscribes the customer straight away and records the result
+func applyUnsubscribe(ctx context.Context, email, _ string) error {
+	result, err := withExistingCustomer(ctx, email, func() (TrackResult, error) { return unsubscribeCustomerByEmail(ctx, email) })
+	recordActionResult(email, "unsubscribe", result, err)
+	return err
+}
+
+// applyUnsubscribeAll removes every brand subscription, marks the customer unsubscribed and records the result
+func applyUnsubscribeAll(ctx context.Context, email, _ string) error {
+	result, err := withExistingCustomer(ctx, email, func() (TrackResult, error) { return unsubscribeAllBrands(ctx, email) })
+	recordActionResult(email, "unsubscribe_all", result, err)
+	return err
+}
+
+// linkResubscribe resubscribes the customer to emails
+func linkResubscribe(ctx context.Context, email, _, _ string) (out linkActionOutcome) {
+	if err := applyResubscribe(ctx, email, ""); err != nil {
+		slog.Error("Failed to resubscribe", "email", logEmail(email), "action", "resubscribe", "error", err)
+		out.setMessage(actionErrorMessage(err, "Error processing resubscribe request. Check logs."))
+		out.Status, out.Err = actionErrorStatus(err), err
+		return out
+	}
+
+	out.setSuccessMessage("resubscribe", email)
+	out.Success = true
+	slog.Info("Resubscribed customer", "email", logEmail(email), "action", "resubscribe")
+	return out
+}
+
+// applyResubscribe resubscribes the customer and records the result
+func applyResubscribe(ctx context.Context, email, _ string) error {
+	result, err := withExistingCustomer(ctx, email, func() (TrackResult, error) { return resubscribeCustomerByEmail(ctx, email) })
+	recordActionResult(email, "resubscribe", result, err)
+	return err
+}
+
+// linkUnpause clears the customer's paused attribute
+func linkUnpause(ctx context.Context, email, _, _ string) (out linkActionOutcome) {
+	if err := applyUnpause(ctx, email, ""); err != nil {
+		slog.Error("Failed to clear paused attribute", "email", logEmail(email), "action", "unpause", "error", err)
+		out.setMessage(actionErrorMessage(err, "Error processing unpause request. Check logs."))
+		out.Status, out.Err = actionErrorStatus(err), err
+		return out
+	}
+
+	out.setSuccessMessage("unpause", email)
+	out.Success = true
+	slog.Info("Cleared paused attribute", "email", logEmail(email), "action", "unpause")
+	return out
+}
+
+// applyUnpause clears the customer's paused attribute and records the result
+func applyUnpause(ctx context.Context, email, _ string) error {
+	result, err := withExistingCustomer(ctx, email, func() (TrackResult, error) { return updateCustomerUnpausedAttributeByEmail(ctx, email) })
+	recordActionResult(email, "unpause", result, err)
+	return err
+}
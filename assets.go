@@ -0,0 +1,39 @@
+package main
+
+import (
+	"embed"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
+)
+
+// assetsFS holds the static assets compiled into the binary so they are served without external CDNs
+//
+//go:embed assets
+var assetsFS embed.FS
+
+var externalAssets = true // Whether templates may load assets (e.g. web fonts) from external CDNs
+
+// configureAssets reads RESULTS_ASSETS_MODE and registers the embedded asset route
+func configureAssets(app *fiber.App) {
+	switch mode := os.Getenv("RESULTS_ASSETS_MODE"); mode {
+	case "", "external":
+		externalAssets = true
+	case "embedded":
+		externalAssets = false
+	default:
+		log.Printf("WARNING: Invalid RESULTS_ASSETS_MODE '%s', using external", mode)
+		externalAssets = true
+	}
+	log.Printf("Results UI assets mode: external CDN assets enabled = %t", externalAssets)
+
+	app.Use("/assets", filesystem.New(filesystem.Config{
+		Root:       http.FS(assetsFS),
+		PathPrefix: "assets",
+		MaxAge:     86400,
+	}))
+	log.Println("GET /assets route registered (embedded filesystem).")
+}
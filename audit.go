@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// auditPageSize is how many recent admin actions GET /results/audit shows
+const auditPageSize = 500
+
+var (
+	auditEmailSearch  = regexp.MustCompile(`[?&]email=[^&\s]*`)             // /results search term, possibly partial
+	auditEmailAddress = regexp.MustCompile(`[^\s/?&=]+(?:@|%40)[^\s/?&=]+`) // Raw or percent-encoded address
+)
+
+// recordAdminAction writes an admin action to the audit trail. Failures are logged rather than
+// returned so a database problem never blocks the admin request itself.
+func recordAdminAction(c *fiber.Ctx, action, details string) {
+	if err := insertAdminAuditRecord(adminUser(c), action, c.IP(), details); err != nil {
+		log.Printf("ERROR: Failed to record admin %s action by %q: %v", action, adminUser(c), err)
+	}
+}
+
+// maskAuditDetails hides the email addresses and /results search terms that audit details such as
+// view URLs may contain, for admins listed in ADMIN_MASKED_USERS
+func maskAuditDetails(details string) string {
+	details = auditEmailSearch.ReplaceAllStringFunc(details, func(param string) string {
+		name, value, _ := strings.Cut(param, "=")
+		if value == "" {
+			return param
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		return name + "=" + maskEmail(value)
+	})
+	return auditEmailAddress.ReplaceAllStringFunc(details, func(address string) string {
+		if unescaped, err := url.QueryUnescape(address); err == nil {
+			address = unescaped
+		}
+		return maskEmail(address)
+	})
+}
+
+// handleAdminAudit shows recent admin actions as HTML, or as JSON for callers sending Accept: application/json
+func handleAdminAudit(c *fiber.Ctx) error {
+	log.Printf("GET /results/audit request received from admin %q (IP: %s)", adminUser(c), c.IP())
+
+	records, err := getAdminAuditRecords(auditPageSize)
+	if err != nil {
+		log.Printf("ERROR: Failed to get admin audit records: %v", err)
+		if wantsJSON(c) {
+			return c.Status(500).JSON(fiber.Map{
+				"success": false,
+				"message": "Failed to retrieve audit records",
+			})
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to retrieve audit records")
+	}
+
+	if adminSeesMaskedEmails(c) {
+		for i := range records {
+			records[i].Details = maskAuditDetails(records[i].Details)
+		}
+	}
+
+	if wantsJSON(c) {
+		if records == nil {
+			records = []AdminAuditRecord{}
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"records": records,
+		})
+	}
+
+	return c.Render("audit", fiber.Map{
+		"Records":        records,
+		"Limit":          auditPageSize,
+		"Timezone":       displayLocation.String(),
+		"ExternalAssets": externalAssets,
+	})
+}
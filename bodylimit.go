@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultMaxRequestBodyKB = 1024      // Fiber BodyLimit for every route (MAX_REQUEST_BODY_KB)
+	publicMaxBodyBytes      = 16 * 1024 // Public POST bodies carry one identifier, a token and a few subscription flags
+	bulkBytesPerEmail       = 512       // Allowance per email in a POST /bulk body, comfortably above an address plus JSON quoting
+)
+
+// maxRequestBodyBytes returns the app-wide body limit. Fiber rejects larger bodies with 413 before
+// any handler runs, so no route can be made to buffer an arbitrarily large request.
+func maxRequestBodyBytes() int {
+	limitKB := positiveIntFromEnv("MAX_REQUEST_BODY_KB", defaultMaxRequestBodyKB)
+	log.Printf("Request bodies limited to %d KB.", limitKB)
+	return limitKB * 1024
+}
+
+// bulkMaxBodyBytes returns the body limit for POST /bulk, scaled to BULK_MAX_EMAILS
+func bulkMaxBodyBytes() int {
+	return bulkMaxEmails*bulkBytesPerEmail + 1024
+}
+
+// limitBody returns a handler rejecting request bodies larger than maxBytes with 413, for routes
+// that need a tighter limit than the app-wide BodyLimit
+func limitBody(maxBytes int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if size := len(c.Body()); size > maxBytes {
+			log.Printf("WARNING: Rejected %d byte body on %s from IP %s (limit %d)", size, c.Path(), clientIP(c), maxBytes)
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, "Request body too large (limit "+strconv.Itoa(maxBytes)+" bytes)")
+		}
+		return c.Next()
+	}
+}
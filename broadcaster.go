@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxStreamClients     = 50               // Maximum number of concurrent /results/stream connections
+	streamClientBuffer   = 32               // Number of events buffered per client before events are dropped
+	streamHeartbeatEvery = 15 * time.Second // Interval between keep-alive comments sent to clients
+)
+
+// RecordEvent represents a newly recorded action pushed to live dashboard clients
+type RecordEvent struct {
+	ID            int64  `json:"id"`
+	FormattedDate string `json:"formatted_date"`
+	Email         string `json:"email"`
+	Action        string `json:"action"`
+	Status        string `json:"status"`
+	StatusCode    int    `json:"status_code"`
+	Details       string `json:"details"`
+}
+
+// recordBroadcaster fans out newly recorded actions to connected admin clients
+type recordBroadcaster struct {
+	mu      sync.Mutex
+	clients map[chan RecordEvent]struct{}
+	closed  bool // Set by closeAll; no new clients are accepted during shutdown
+}
+
+var broadcaster = &recordBroadcaster{
+	clients: make(map[chan RecordEvent]struct{}),
+}
+
+// subscribe registers a new client and returns its event channel.
+// It returns an error if the maximum number of clients is already connected.
+func (b *recordBroadcaster) subscribe() (chan RecordEvent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, fmt.Errorf("server is shutting down")
+	}
+	if len(b.clients) >= maxStreamClients {
+		return nil, fmt.Errorf("too many stream clients connected (max %d)", maxStreamClients)
+	}
+
+	ch := make(chan RecordEvent, streamClientBuffer)
+	b.clients[ch] = struct{}{}
+	return ch, nil
+}
+
+// unsubscribe removes a client and closes its event channel
+func (b *recordBroadcaster) unsubscribe(ch chan RecordEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.clients[ch]; exists {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// closeAll closes every client's event channel so open streams end, and refuses new clients.
+// Called before shutdown, which otherwise waits out the timeout on the long-lived stream connections.
+func (b *recordBroadcaster) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.clients {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// publish sends an event to every connected client without blocking.
+// Clients whose buffers are full miss the event rather than stalling the insert path.
+func (b *recordBroadcaster) publish(event RecordEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.clients {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("WARNING: Stream client buffer full, dropping event for record %d", event.ID)
+		}
+	}
+}
+
+// handleResultsStream streams newly recorded actions to admin clients as Server-Sent Events
+func handleResultsStream(c *fiber.Ctx) error {
+	log.Printf("GET /results/stream request received from IP: %s", c.IP())
+
+	events, err := broadcaster.subscribe()
+	if err != nil {
+		log.Printf("ERROR: Rejecting stream client from IP %s: %v", c.IP(), err)
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Too many live feed clients are connected")
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	clientIP := c.IP()
+	maskEmails := adminSeesMaskedEmails(c)
+	streamID := requestID(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer recoverStreamPanic("results_stream", streamID)
+		defer broadcaster.unsubscribe(events)
+
+		heartbeat := time.NewTicker(streamHeartbeatEvery)
+		defer heartbeat.Stop()
+
+		// Let the client know the stream is open
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			log.Printf("Stream client %s disconnected: %v", clientIP, err)
+			return
+		}
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if maskEmails {
+					event.Email = maskEmail(event.Email)
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Printf("ERROR: Failed to marshal stream event: %v", err)
+					continue
+				}
+				fmt.Fprintf(w, "id: %d\nevent: record\ndata: %s\n\n", event.ID, data)
+			case <-heartbeat.C:
+				// Comment lines keep the connection alive through proxies
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+
+			if err := w.Flush(); err != nil {
+				log.Printf("Stream client %s disconnected: %v", clientIP, err)
+				return
+			}
+		}
+	})
+
+	return nil
+}
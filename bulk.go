@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultBulkConcurrency = 5   // Concurrent Customer.io calls per bulk request
+	defaultBulkMaxEmails   = 500 // Largest batch accepted by POST /bulk
+)
+
+var (
+	bulkConcurrency = defaultBulkConcurrency // Worker pool size for POST /bulk (BULK_CONCURRENCY)
+	bulkMaxEmails   = defaultBulkMaxEmails   // Batch size cap for POST /bulk (BULK_MAX_EMAILS)
+)
+
+// bulkAction is an action POST /bulk can apply, with the details recorded alongside it
+type bulkAction struct {
+	operations func(email string) []BatchOperation // Track API batch operations applying the action to one email
+	details    string
+	upsert     bool // Creating the profile is intended, so REQUIRE_EXISTING_CUSTOMER does not apply
+}
+
+// bulkActions maps the actions accepted by POST /bulk to the batch operations they send, matching
+// what the per-email helpers send for the same action
+var bulkActions = map[string]bulkAction{
+	"pause":         {operations: bulkAttributeUpdate(map[string]interface{}{"paused": true})},
+	"international": {operations: bulkRegionMove("BBUS", "BBAU"), details: regionMoveDetails("BBUS", "BBAU"), upsert: true},
+	"unsubscribe":   {operations: bulkAttributeUpdate(map[string]interface{}{"unsubscribed": true})},
+	"resubscribe":   {operations: bulkAttributeUpdate(map[string]interface{}{"unsubscribed": false})},
+}
+
+// bulkAttributeUpdate returns the operations setting attrs on one customer
+func bulkAttributeUpdate(attrs map[string]interface{}) func(email string) []BatchOperation {
+	return func(email string) []BatchOperation {
+		return []BatchOperation{customerIO.BatchAttributeUpdate(email, attrs)}
+	}
+}
+
+// bulkRegionMove returns the operations moving one customer from one region list to another
+func bulkRegionMove(fromObjectID, toObjectID string) func(email string) []BatchOperation {
+	return func(email string) []BatchOperation {
+		return []BatchOperation{
+			customerIO.BatchRemoveRelationship(email, "", fromObjectID),
+			customerIO.BatchAddRelationship(email, "", toObjectID),
+		}
+	}
+}
+
+// BulkResult is the outcome of a bulk action for a single email
+type BulkResult struct {
+	Email   string `json:"email"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// configureBulk reads the bulk action worker pool size and batch cap from environment variables
+func configureBulk() {
+	bulkConcurrency = positiveIntFromEnv("BULK_CONCURRENCY", defaultBulkConcurrency)
+	bulkMaxEmails = positiveIntFromEnv("BULK_MAX_EMAILS", defaultBulkMaxEmails)
+	log.Printf("Bulk actions configured: %d workers, max %d emails per request", bulkConcurrency, bulkMaxEmails)
+}
+
+// positiveIntFromEnv reads a positive integer from the environment, falling back to a default
+func positiveIntFromEnv(name string, defaultValue int) int {
+	valueStr := os.Getenv(name)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value <= 0 {
+		log.Printf("WARNING: Invalid %s '%s', using default %d", name, valueStr, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// handleBulkAction applies one action to a list of emails with a bounded worker pool
+// and returns the outcome for each email in request order
+func handleBulkAction(c *fiber.Ctx) error {
+	var req struct {
+		Action string   `json:"action"`
+		Emails []string `json:"emails"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		slog.Warn("Failed to parse bulk request body", "ip", c.IP(), "error", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request format",
+		})
+	}
+
+	action, ok := bulkActions[req.Action]
+	if !ok {
+		slog.Warn("Rejected bulk request with unknown action", "action", req.Action, "ip", c.IP())
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Unknown action. Use pause, international, unsubscribe or resubscribe",
+		})
+	}
+
+	if len(req.Emails) == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "No emails provided",
+		})
+	}
+	if len(req.Emails) > bulkMaxEmails {
+		slog.Warn("Rejected oversized bulk request", "action", req.Action, "count", len(req.Emails), "max", bulkMaxEmails, "ip", c.IP())
+		return c.Status(413).JSON(fiber.Map{
+			"success": false,
+			"message": "Too many emails; split the batch into requests of at most " + strconv.Itoa(bulkMaxEmails),
+		})
+	}
+
+	recordAdminAction(c, "bulk", fmt.Sprintf("%s (%d emails)", req.Action, len(req.Emails)))
+	slog.Info("Processing bulk action", "action", req.Action, "count", len(req.Emails), "workers", bulkConcurrency, "admin", adminUser(c), "ip", c.IP())
+
+	// Validate every email, and look customers up where REQUIRE_EXISTING_CUSTOMER applies, before sending anything
+	ctx := c.Context()
+	results := make([]BulkResult, len(req.Emails))
+	records := make([]*recordInsert, len(req.Emails))
+	accepted := make([]string, len(req.Emails)) // Normalized email, empty when rejected
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(bulkConcurrency, len(req.Emails)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], records[i], accepted[i] = checkBulkEmail(ctx, req.Action, action, req.Emails[i])
+			}
+		}()
+	}
+	for i := range req.Emails {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	sendBulkBatch(ctx, req.Action, action, accepted, results, records)
+
+	// Record the whole batch in one transaction once every Customer.io call has finished
+	var batch []recordInsert
+	for _, record := range records {
+		if record != nil {
+			batch = append(batch, *record)
+		}
+	}
+	if len(batch) > 0 {
+		if inserted, err := insertEmailProcessingRecords(batch); err != nil {
+			slog.Warn("Failed to log bulk actions to database", "action", req.Action, "count", len(batch), "error", err)
+		} else if inserted < len(batch) {
+			slog.Info("Skipped bulk records already recorded today", "action", req.Action, "skipped", len(batch)-inserted)
+		}
+	}
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+	}
+	slog.Info("Bulk action completed", "action", req.Action, "succeeded", succeeded, "failed", len(results)-succeeded)
+
+	return c.JSON(fiber.Map{
+		"success":   succeeded == len(results),
+		"action":    req.Action,
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+		"results":   results,
+	})
+}
+
+// checkBulkEmail validates one email from a bulk request and, unless the action upserts, makes sure the
+// customer exists. It returns the normalized email if the action should be applied; otherwise the outcome
+// along with the record to store, which is nil when the email was rejected before any call.
+func checkBulkEmail(ctx context.Context, actionName string, action bulkAction, email string) (BulkResult, *recordInsert, string) {
+	normalizedEmail, err := validateEmail(email)
+	if err != nil {
+		return BulkResult{Email: email, Error: "invalid email address"}, nil, ""
+	}
+
+	if !action.upsert {
+		if err := ensureCustomerExists(ctx, normalizedEmail); err != nil {
+			return BulkResult{Email: normalizedEmail, Error: err.Error()}, &recordInsert{Email: normalizedEmail, Action: actionName, Details: action.details, Err: err}, ""
+		}
+	}
+	return BulkResult{Email: normalizedEmail}, nil, normalizedEmail
+}
+
+// sendBulkBatch applies the action to every accepted email through the Track API batch endpoint, so a
+// bulk request costs a few Customer.io calls rather than one or two per email, and fills in each
+// email's outcome and record
+func sendBulkBatch(ctx context.Context, actionName string, action bulkAction, accepted []string, results []BulkResult, records []*recordInsert) {
+	var ops []BatchOperation
+	var owners []int // Index of the email each operation belongs to
+	for i, email := range accepted {
+		if email == "" {
+			continue
+		}
+		for _, op := range action.operations(email) {
+			ops = append(ops, op)
+			owners = append(owners, i)
+		}
+	}
+	if len(ops) == 0 {
+		return
+	}
+
+	// An email's outcome is that of its first failed operation, or of its last one when all succeed
+	trackResults := make([]TrackResult, len(accepted))
+	errs := make([]error, len(accepted))
+	for j, outcome := range customerIO.SendBatch(ctx, ops) {
+		if i := owners[j]; errs[i] == nil {
+			trackResults[i], errs[i] = outcome.Result, outcome.Err
+		}
+	}
+
+	for i, email := range accepted {
+		if email == "" {
+			continue
+		}
+		records[i] = &recordInsert{Email: email, Action: actionName, Details: action.details, Result: trackResults[i], Err: errs[i]}
+		if errs[i] != nil {
+			slog.Error("Bulk action failed", "email", logEmail(email), "action", actionName, "error", errs[i])
+			results[i] = BulkResult{Email: email, Error: errs[i].Error()}
+			continue
+		}
+		results[i] = BulkResult{Email: email, Success: true}
+	}
+}
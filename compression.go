@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+)
+
+// newCompressionMiddleware returns a handler that gzip/deflate/brotli-compresses responses for clients
+// sending a matching Accept-Encoding. RESPONSE_COMPRESSION=false turns it into a no-op.
+// It must not be used on Server-Sent Event routes, where compression would hold back live events.
+func newCompressionMiddleware() fiber.Handler {
+	if os.Getenv("RESPONSE_COMPRESSION") == "false" {
+		log.Println("Response compression disabled (RESPONSE_COMPRESSION=false).")
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	log.Println("Compressing results pages and exports for clients that accept it.")
+	return compress.New(compress.Config{
+		Level: compress.LevelBestSpeed,
+	})
+}
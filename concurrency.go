@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCustomerIOMaxConcurrency  = 10              // Simultaneous Customer.io requests across all handlers and workers
+	defaultCustomerIOConcurrencyWait = 5 * time.Second // Longest a request waits for a free slot before giving up
+)
+
+var (
+	customerIOSlots           chan struct{} // One entry per in-flight Customer.io request; nil means unlimited
+	customerIOConcurrencyWait = defaultCustomerIOConcurrencyWait
+)
+
+// errCustomerIOBusy means every Customer.io request slot stayed taken for CUSTOMERIO_CONCURRENCY_WAIT_MS.
+// Link handlers answer 429 so a burst of scanner prefetches backs off instead of tripping Customer.io's limits.
+var errCustomerIOBusy = errors.New("too many concurrent Customer.io requests")
+
+// configureCustomerIOConcurrency reads CUSTOMERIO_MAX_CONCURRENCY (0 disables the limit) and
+// CUSTOMERIO_CONCURRENCY_WAIT_MS from environment variables
+func configureCustomerIOConcurrency() {
+	limit := defaultCustomerIOMaxConcurrency
+	if limitStr := os.Getenv("CUSTOMERIO_MAX_CONCURRENCY"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 0 {
+			log.Printf("WARNING: Invalid CUSTOMERIO_MAX_CONCURRENCY '%s', using default %d", limitStr, defaultCustomerIOMaxConcurrency)
+		} else {
+			limit = parsed
+		}
+	}
+
+	if waitStr := os.Getenv("CUSTOMERIO_CONCURRENCY_WAIT_MS"); waitStr != "" {
+		waitMs, err := strconv.Atoi(waitStr)
+		if err != nil || waitMs < 0 {
+			log.Printf("WARNING: Invalid CUSTOMERIO_CONCURRENCY_WAIT_MS '%s', using default %s", waitStr, defaultCustomerIOConcurrencyWait)
+		} else {
+			customerIOConcurrencyWait = time.Duration(waitMs) * time.Millisecond
+		}
+	}
+
+	if limit == 0 {
+		customerIOSlots = nil
+		log.Println("Customer.io concurrency limit disabled (CUSTOMERIO_MAX_CONCURRENCY=0).")
+		return
+	}
+	customerIOSlots = make(chan struct{}, limit)
+	log.Printf("Customer.io requests limited to %d at a time, waiting up to %s for a free slot.", limit, customerIOConcurrencyWait)
+}
+
+// acquireCustomerIOSlot waits for a free Customer.io request slot, for at most customerIOConcurrencyWait
+// or until ctx ends. The returned release must be called once the response has been read.
+func acquireCustomerIOSlot(ctx context.Context) (release func(), err error) {
+	slots := customerIOSlots
+	if slots == nil {
+		return func() {}, nil
+	}
+	release = sync.OnceFunc(func() { <-slots })
+
+	// Fast path: no timer when a slot is free
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	started := time.Now()
+	timer := time.NewTimer(customerIOConcurrencyWait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		slog.Debug("Waited for a Customer.io request slot", "wait", time.Since(started).String())
+		return release, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a Customer.io request slot: %w", ctx.Err())
+	case <-timer.C:
+		slog.Warn("No Customer.io request slot free, rejecting request", "limit", cap(slots), "wait", customerIOConcurrencyWait.String())
+		return nil, errCustomerIOBusy
+	}
+}
+
+// slotReleasingBody frees a Customer.io request slot when the response body is closed, so the slot
+// covers the whole exchange rather than just the wait for response headers
+type slotReleasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *slotReleasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
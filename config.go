@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Config is the optional JSON settings file named by CONFIG_FILE. Every setting is still an environment
+// variable: the file only fills in variables that are not set, so the environment (and .env) always wins.
+// The common settings have fields of their own; any other variable can be given in Env by name.
+type Config struct {
+	CustomerIOSiteID    string            `json:"customerio_site_id"`
+	CustomerIOAPIKey    string            `json:"customerio_api_key"`
+	CustomerIOAppAPIKey string            `json:"customerio_app_api_key"`
+	CustomerIOTrackURL  string            `json:"customerio_track_url"`
+	CustomerIOAppURL    string            `json:"customerio_app_url"`
+	ObjectTypeID        string            `json:"customerio_object_type_id"`
+	SubscriptionKeys    []string          `json:"subscription_keys"`
+	DisplayTimezone     string            `json:"display_timezone"`
+	AdminUsername       string            `json:"admin_username"`
+	AdminPassword       string            `json:"admin_password"`
+	AdminPasswordBcrypt string            `json:"admin_password_bcrypt"`
+	AdminUsers          map[string]string `json:"admin_users"` // Username -> bcrypt hash
+	Port                int               `json:"port"`
+	DatabasePath        string            `json:"database_path"`
+
+	Env map[string]string `json:"env"` // Any other setting, keyed by environment variable name
+}
+
+// environment returns the file's settings by environment variable name, leaving out empty values.
+// A named field wins over the same variable in Env.
+func (cfg Config) environment() map[string]string {
+	settings := make(map[string]string)
+	for name, value := range cfg.Env {
+		settings[name] = value
+	}
+
+	adminUsers := make([]string, 0, len(cfg.AdminUsers))
+	for _, username := range slices.Sorted(maps.Keys(cfg.AdminUsers)) {
+		adminUsers = append(adminUsers, username+":"+cfg.AdminUsers[username])
+	}
+	port := ""
+	if cfg.Port > 0 {
+		port = strconv.Itoa(cfg.Port)
+	}
+
+	for name, value := range map[string]string{
+		"CUSTOMERIO_SITE_ID":        cfg.CustomerIOSiteID,
+		"CUSTOMERIO_API_KEY":        cfg.CustomerIOAPIKey,
+		"CUSTOMERIO_APP_API_KEY":    cfg.CustomerIOAppAPIKey,
+		"CUSTOMERIO_TRACK_URL":      cfg.CustomerIOTrackURL,
+		"CUSTOMERIO_APP_URL":        cfg.CustomerIOAppURL,
+		"CUSTOMERIO_OBJECT_TYPE_ID": cfg.ObjectTypeID,
+		"SUBSCRIPTION_KEYS":         strings.Join(cfg.SubscriptionKeys, ","),
+		"DISPLAY_TIMEZONE":          cfg.DisplayTimezone,
+		"ADMIN_USERNAME":            cfg.AdminUsername,
+		"ADMIN_PASSWORD":            cfg.AdminPassword,
+		"ADMIN_PASSWORD_BCRYPT":     cfg.AdminPasswordBcrypt,
+		"ADMIN_USERS":               strings.Join(adminUsers, ","),
+		"PORT":                      port,
+		"DATABASE_PATH":             cfg.DatabasePath,
+	} {
+		if value != "" {
+			settings[name] = value
+		}
+	}
+	return settings
+}
+
+// loadConfigFile reads CONFIG_FILE, if set, and sets every variable from it that is not already in the
+// environment. Unknown fields are rejected so a misspelt setting doesn't go unnoticed.
+func loadConfigFile() error {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read CONFIG_FILE: %w", err)
+	}
+	var cfg Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return fmt.Errorf("invalid CONFIG_FILE %s: %w", path, err)
+	}
+
+	settings := cfg.environment()
+	var applied, overridden []string
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		if _, set := os.LookupEnv(name); set {
+			overridden = append(overridden, name)
+			continue
+		}
+		if err := os.Setenv(name, settings[name]); err != nil {
+			return fmt.Errorf("failed to apply %s from CONFIG_FILE: %w", name, err)
+		}
+		applied = append(applied, name)
+	}
+
+	log.Printf("Loaded %d settings from CONFIG_FILE %s", len(applied), path)
+	if len(overridden) > 0 {
+		log.Printf("Environment variables override CONFIG_FILE for: %s", strings.Join(overridden, ", "))
+	}
+	return nil
+}
+
+// missingRequiredSettings lists the required settings that are set neither in the environment nor in CONFIG_FILE
+func missingRequiredSettings() []string {
+	var missing []string
+	for _, name := range []string{"CUSTOMERIO_SITE_ID", "CUSTOMERIO_API_KEY"} {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	// Either the shared admin account, with a password, or per-person ADMIN_USERS accounts
+	switch {
+	case os.Getenv("ADMIN_USERNAME") == "" && os.Getenv("ADMIN_USERS") == "":
+		missing = append(missing, "ADMIN_USERNAME (or ADMIN_USERS)")
+	case os.Getenv("ADMIN_USERNAME") != "" && os.Getenv("ADMIN_PASSWORD") == "" && os.Getenv("ADMIN_PASSWORD_BCRYPT") == "":
+		missing = append(missing, "ADMIN_PASSWORD (or ADMIN_PASSWORD_BCRYPT)")
+	}
+
+	if os.Getenv("REQUIRE_EXISTING_CUSTOMER") == "true" && os.Getenv("CUSTOMERIO_APP_API_KEY") == "" {
+		missing = append(missing, "CUSTOMERIO_APP_API_KEY (required by REQUIRE_EXISTING_CUSTOMER)")
+	}
+	return missing
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// actionConfirmPrompt returns the question shown before a link action is applied, in locale, or "" for unknown actions
+func actionConfirmPrompt(locale, action, email, from, to string) string {
+	definition, ok := findLinkAction(action)
+	if !ok {
+		return ""
+	}
+	return definition.confirmPrompt(locale, email, from, to)
+}
+
+// renderActionConfirmation renders the confirmation page for a verified link action without changing anything.
+// The page POSTs to /confirm with a CSRF token bound to the customer, which stands in for the link verification.
+func renderActionConfirmation(c *fiber.Ctx, email, cioID, action string) error {
+	locale := requestLocale(c)
+	identifier, prompt := email, actionConfirmPrompt(locale, action, email, c.Query("from"), c.Query("to"))
+	if email == "" {
+		identifier = cioID
+		if action == "" {
+			action = "pause"
+		}
+		prompt = actionConfirmPrompt(locale, action, translate(locale, "customer ID %s", cioID), c.Query("from"), c.Query("to"))
+	}
+
+	if prompt == "" {
+		slog.Warn("Unknown action requested", "email", logEmail(email), "action", action)
+		return c.Render("minimal", fiber.Map{
+			"Message": translate(locale, "Unknown action requested."),
+			"Success": false,
+			"Locale":  locale,
+		})
+	}
+
+	csrfToken, err := issueCSRFToken(c, identifier)
+	if err != nil {
+		slog.Error("Failed to issue CSRF token", "email", logEmail(email), "cio_id", cioID, "error", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "We couldn't load this page. Please try the link again.")
+	}
+
+	slog.Debug("Showing action confirmation", "email", logEmail(email), "cio_id", cioID, "action", action)
+	return c.Render("confirm", fiber.Map{
+		"Prompt":    prompt,
+		"Email":     email,
+		"CioID":     cioID,
+		"Action":    action,
+		"From":      c.Query("from"),
+		"To":        c.Query("to"),
+		"Redirect":  c.Query("redirect"),
+		"CSRFToken": csrfToken,
+		"Locale":    locale,
+	})
+}
+
+// handleConfirmAction applies a link action once the customer confirms it on the confirmation page
+func handleConfirmAction(c *fiber.Ctx) error {
+	locale := requestLocale(c)
+	email := c.FormValue("email")
+	cioID := c.FormValue("cio")
+	action := c.FormValue("action")
+
+	if email != "" {
+		normalizedEmail, err := validateEmail(email)
+		if err != nil {
+			slog.Warn("Rejected invalid email in confirmation", "ip", c.IP(), "error", err)
+			return c.Status(400).Render("minimal", fiber.Map{
+				"Message": translate(locale, "Please provide a valid email address."),
+				"Success": false,
+				"Locale":  locale,
+			})
+		}
+		email = normalizedEmail
+	} else if cioID != "" {
+		normalizedID, err := validateCustomerID(cioID)
+		if err != nil {
+			slog.Warn("Rejected invalid customer ID in confirmation", "ip", c.IP(), "error", err)
+			return c.Status(400).Render("minimal", fiber.Map{
+				"Message": translate(locale, "Please provide a valid customer ID."),
+				"Success": false,
+				"Locale":  locale,
+			})
+		}
+		cioID = normalizedID
+	}
+
+	identifier := email
+	if identifier == "" {
+		identifier = cioID
+	}
+	if identifier == "" || (email != "" && action == "") {
+		return c.Status(400).Render("minimal", fiber.Map{
+			"Message": translate(locale, "Missing customer or action."),
+			"Success": false,
+			"Locale":  locale,
+		})
+	}
+
+	if err := verifyCSRFToken(c, identifier, requestCSRFToken(c, c.FormValue("csrf_token"))); err != nil {
+		slog.Warn("Rejected confirmation with invalid CSRF token", "email", logEmail(email), "cio_id", cioID, "ip", c.IP(), "error", err)
+		return c.Status(403).Render("minimal", fiber.Map{
+			"Message": translate(locale, "This confirmation has expired. Please open the link from your email again."),
+			"Success": false,
+			"Locale":  locale,
+		})
+	}
+
+	// Customer IDs go through the same action helpers as emails; bare cio= links always pause
+	if email == "" && action == "" {
+		action = "pause"
+	}
+	outcome := performLinkAction(c.Context(), identifier, action, c.FormValue("from"), c.FormValue("to"))
+
+	if target := actionRedirectTarget(c.FormValue("redirect")); target != nil {
+		return redirectWithOutcome(c, target, locale, action, outcome)
+	}
+	return c.Render("minimal", fiber.Map{
+		"Message":   outcome.localizedMessage(locale),
+		"Success":   outcome.Success,
+		"CancelURL": outcome.CancelURL,
+		"Locale":    locale,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	csrfHeaderName = "X-CSRF-Token" // Header the preference page sends the token in (or the csrf_token body field)
+	csrfCookieName = "csrf_session" // Per-browser nonce the token is bound to
+	csrfTokenTTL   = 2 * time.Hour  // How long a rendered preference page can still be submitted
+)
+
+// csrfSecret keys CSRF token signatures (CSRF_SECRET, or random per process)
+var csrfSecret []byte
+
+// configureCSRF loads the CSRF signing secret, generating a random one if it is not configured
+func configureCSRF() {
+	if secret := os.Getenv("CSRF_SECRET"); secret != "" {
+		csrfSecret = []byte(secret)
+		log.Println("CSRF protection enabled with CSRF_SECRET.")
+		return
+	}
+
+	csrfSecret = make([]byte, 32)
+	if _, err := rand.Read(csrfSecret); err != nil {
+		log.Fatalf("CRITICAL: Failed to generate CSRF secret: %v", err)
+	}
+	log.Println("WARNING: CSRF_SECRET not set - using a random secret; open preference pages will fail after a restart or across machines.")
+}
+
+// signCSRFToken computes the token signature for a browser session, email and issue time
+func signCSRFToken(session, email string, issuedAt int64) string {
+	mac := hmac.New(sha256.New, csrfSecret)
+	fmt.Fprintf(mac, "%s|%s|%d", session, strings.ToLower(email), issuedAt)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issueCSRFToken returns a token for the preference page, bound to the email and to a
+// per-browser session cookie that is set here if the browser does not have one yet
+func issueCSRFToken(c *fiber.Ctx, email string) (string, error) {
+	session := c.Cookies(csrfCookieName)
+	if session == "" {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return "", fmt.Errorf("error generating CSRF session: %w", err)
+		}
+		session = hex.EncodeToString(nonce)
+		c.Cookie(&fiber.Cookie{
+			Name:     csrfCookieName,
+			Value:    session,
+			Path:     "/",
+			HTTPOnly: true,
+			Secure:   isProduction(),
+			SameSite: fiber.CookieSameSiteStrictMode,
+		})
+	}
+
+	issuedAt := time.Now().Unix()
+	return fmt.Sprintf("%d.%s", issuedAt, signCSRFToken(session, email, issuedAt)), nil
+}
+
+// hasCSRFSession reports whether the request comes from a browser that was issued a CSRF session cookie.
+// The cookie is SameSite=Strict, so cross-site requests never carry it.
+func hasCSRFSession(c *fiber.Ctx) bool {
+	return c.Cookies(csrfCookieName) != ""
+}
+
+// verifyCSRFToken checks that a token was issued to this browser session for this email and has not expired
+func verifyCSRFToken(c *fiber.Ctx, email, token string) error {
+	if token == "" {
+		return errors.New("missing CSRF token")
+	}
+
+	session := c.Cookies(csrfCookieName)
+	if session == "" {
+		return errors.New("missing CSRF session cookie")
+	}
+
+	issuedStr, signature, found := strings.Cut(token, ".")
+	if !found {
+		return errors.New("malformed CSRF token")
+	}
+	issuedAt, err := strconv.ParseInt(issuedStr, 10, 64)
+	if err != nil {
+		return errors.New("malformed CSRF token")
+	}
+
+	if !hmac.Equal([]byte(signCSRFToken(session, email, issuedAt)), []byte(signature)) {
+		return errors.New("CSRF token mismatch")
+	}
+	if time.Since(time.Unix(issuedAt, 0)) > csrfTokenTTL {
+		return errors.New("CSRF token expired")
+	}
+
+	return nil
+}
+
+// requestCSRFToken reads the CSRF token from the request header, falling back to the JSON body field
+func requestCSRFToken(c *fiber.Ctx, bodyToken string) string {
+	if token := c.Get(csrfHeaderName); token != "" {
+		return token
+	}
+	return bodyToken
+}
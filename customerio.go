@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCustomerIOTrackURL = "https://track.customer.io" // US region Track API host
+	defaultCustomerIOAppURL   = "https://api.customer.io"   // US region App API host, used for profile lookups
+	defaultCustomerIOTimeout  = 10 * time.Second            // Default upper bound on a single Track API request
+	defaultObjectTypeID       = "1"                         // Customer.io object type used for brand relationships
+)
+
+// Connection pool defaults for the shared Track API transport
+const (
+	defaultMaxIdleConnsPerHost = 20 // Idle connections kept per API host; net/http's default of 2 is too few for bulk workers
+	defaultIdleConnTimeoutSecs = 90 // How long an unused pooled connection stays open
+)
+
+var (
+	customerIOMaxIdleConnsPerHost = defaultMaxIdleConnsPerHost               // CUSTOMERIO_MAX_IDLE_CONNS_PER_HOST
+	customerIOIdleConnTimeout     = defaultIdleConnTimeoutSecs * time.Second // CUSTOMERIO_IDLE_CONN_TIMEOUT_SECONDS
+)
+
+// configureTransport reads the Track API connection pool settings from environment variables.
+// It must run before the client is built.
+func configureTransport() {
+	customerIOMaxIdleConnsPerHost = positiveIntFromEnv("CUSTOMERIO_MAX_IDLE_CONNS_PER_HOST", defaultMaxIdleConnsPerHost)
+	customerIOIdleConnTimeout = time.Duration(positiveIntFromEnv("CUSTOMERIO_IDLE_CONN_TIMEOUT_SECONDS", defaultIdleConnTimeoutSecs)) * time.Second
+	slog.Info("Customer.io connection pool configured", "max_idle_conns_per_host", customerIOMaxIdleConnsPerHost, "idle_conn_timeout", customerIOIdleConnTimeout)
+}
+
+// newTrackTransport returns a transport whose idle pool keeps connections to the Track and App API
+// hosts open between calls, so concurrent requests reuse them instead of repeating TLS handshakes
+func newTrackTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = customerIOMaxIdleConnsPerHost
+	transport.MaxIdleConns = max(transport.MaxIdleConns, 2*customerIOMaxIdleConnsPerHost)
+	transport.IdleConnTimeout = customerIOIdleConnTimeout
+	return transport
+}
+
+// defaultUserAgent identifies this service and build to Customer.io (override with CUSTOMERIO_USER_AGENT)
+func defaultUserAgent() string {
+	return "CustomerIO-Pauser/" + version
+}
+
+// CustomerIOClient sends requests to the Customer.io Track API
+type CustomerIOClient struct {
+	SiteID     string       // Customer.io Site ID, used as the Basic Auth username
+	APIKey     string       // Customer.io API Key, used as the Basic Auth password
+	BaseURL    string       // Track API host, e.g. https://track.customer.io (overridable for tests)
+	HTTPClient *http.Client // Reused for every request so connections are pooled
+	UserAgent  string       // Sent on every request
+
+	ObjectTypeID string // Object type for relationship calls that don't specify one
+	DryRun       bool   // Log mutations instead of sending them (DRY_RUN)
+
+	AppAPIKey  string // App API key (Bearer token) for profile lookups; the Track API cannot read profiles
+	AppBaseURL string // App API host, e.g. https://api.customer.io
+}
+
+// TrackResult describes how a Track API call completed
+type TrackResult struct {
+	StatusCode int  // HTTP status of the final response, 0 if no response was received
+	Retries    int  // Retries needed before the final response
+	DryRun     bool // The request was logged but not sent (DRY_RUN)
+}
+
+// then combines the result of a follow-up call, keeping the final status and summing retries
+func (r TrackResult) then(next TrackResult) TrackResult {
+	return TrackResult{StatusCode: next.StatusCode, Retries: r.Retries + next.Retries, DryRun: r.DryRun || next.DryRun}
+}
+
+// TrackAPIError is returned when the Track API responds with a non-success status
+type TrackAPIError struct {
+	Operation  string // Call that failed, e.g. "attribute update"
+	Identifier string // Customer identifier as formatted for logs
+	Status     string // HTTP status line, e.g. "400 Bad Request"
+	StatusCode int
+	Body       string // Response body, which may echo customer data
+}
+
+func (e *TrackAPIError) Error() string {
+	return fmt.Sprintf("Customer.io %s returned non-success status for %s: %s. Body: %s", e.Operation, e.Identifier, e.Status, e.Body)
+}
+
+// Unwrap makes errors.Is(err, errCredentialsRejected) true for 401/403 responses, telling authentication
+// failures apart from other non-2xx responses
+func (e *TrackAPIError) Unwrap() error {
+	if isCredentialsRejectedStatus(e.StatusCode) {
+		return errCredentialsRejected
+	}
+	return nil
+}
+
+// errCredentialsRejected means Customer.io refused our site ID/API key (or App API key), typically after a
+// key rotation that wasn't applied. Retrying won't help until the configuration is fixed.
+var errCredentialsRejected = errors.New("customer.io credentials rejected")
+
+// isCredentialsRejectedStatus reports whether a Customer.io status code means the credentials were refused
+func isCredentialsRejectedStatus(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+// customerIO is the default client built in main() and used by the package-level helpers
+var customerIO *CustomerIOClient
+
+// NewCustomerIOClient creates a Track API client for the given credentials and host.
+// The timeout covers the whole request, including reading the response body.
+func NewCustomerIOClient(siteID, apiKey, baseURL string, timeout time.Duration) *CustomerIOClient {
+	if baseURL == "" {
+		baseURL = defaultCustomerIOTrackURL
+	}
+	if timeout <= 0 {
+		timeout = defaultCustomerIOTimeout
+	}
+	return &CustomerIOClient{
+		SiteID:     siteID,
+		APIKey:     apiKey,
+		BaseURL:    baseURL,
+		HTTPClient: &http.Client{Timeout: timeout, Transport: newTrackTransport()},
+		UserAgent:  defaultUserAgent(),
+
+		ObjectTypeID: defaultObjectTypeID,
+		AppBaseURL:   defaultCustomerIOAppURL,
+	}
+}
+
+// setRequestHeaders sets the User-Agent and a new X-Request-ID on an outbound request and returns
+// the ID. Retries reuse the header, so every attempt of one call can be matched to our logs.
+func (c *CustomerIOClient) setRequestHeaders(req *http.Request) string {
+	requestID := newRequestID()
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("X-Request-ID", requestID)
+	return requestID
+}
+
+// newRequestID returns a random 16-character hex ID for X-Request-ID
+func newRequestID() string {
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	return hex.EncodeToString(idBytes)
+}
+
+// customerURL returns the Track API endpoint for a customer identifier
+func (c *CustomerIOClient) customerURL(identifier string) string {
+	return fmt.Sprintf("%s/api/v1/customers/%s", c.BaseURL, escapeCustomerIdentifier(identifier))
+}
+
+// escapeCustomerIdentifier escapes an email (or customer ID) for use as a single path segment. '/', '?',
+// '#' and '%' anywhere in it, domain included, would otherwise change the request path; '@' is left as-is.
+// '+' is escaped explicitly because PathEscape leaves it as-is and it can be read as a space.
+func escapeCustomerIdentifier(identifier string) string {
+	return strings.ReplaceAll(url.PathEscape(identifier), "+", "%2B")
+}
+
+// UpdateAttributes sets attributes on a customer profile identified by email (or customer ID)
+func (c *CustomerIOClient) UpdateAttributes(ctx context.Context, email string, attrs map[string]interface{}) (TrackResult, error) {
+	return c.putCustomer(ctx, email, attrs, "attribute update")
+}
+
+// AddRelationship relates a customer to an object using the add_relationships action.
+func (c *CustomerIOClient) AddRelationship(ctx context.Context, email, objectTypeID, objectID string) (TrackResult, error) {
+	return c.putCustomer(ctx, email, relationshipPayload("add_relationships", c.objectType(objectTypeID), objectID), "relationship creation")
+}
+
+// RemoveRelationship removes a customer's relationship to an object using the delete_relationships action.
+func (c *CustomerIOClient) RemoveRelationship(ctx context.Context, email, objectTypeID, objectID string) (TrackResult, error) {
+	return c.putCustomer(ctx, email, relationshipPayload("delete_relationships", c.objectType(objectTypeID), objectID), "relationship removal")
+}
+
+// objectType returns the per-call object type override, or the client default. Every relationship method,
+// single or batch, resolves its objectTypeID through here, so an empty one uses the client's ObjectTypeID.
+func (c *CustomerIOClient) objectType(objectTypeID string) string {
+	if objectTypeID != "" {
+		return objectTypeID
+	}
+	if c.ObjectTypeID != "" {
+		return c.ObjectTypeID
+	}
+	return defaultObjectTypeID
+}
+
+// Identify creates or identifies a customer profile keyed by email
+func (c *CustomerIOClient) Identify(ctx context.Context, email string) (TrackResult, error) {
+	return c.putCustomer(ctx, email, map[string]interface{}{"email": email}, "identify")
+}
+
+// relationshipPayload builds a cio_relationships payload for the given action and object
+func relationshipPayload(action, objectTypeID, objectID string) map[string]interface{} {
+	return map[string]interface{}{
+		"cio_relationships": map[string]interface{}{
+			"action": action,
+			"relationships": []map[string]interface{}{
+				{
+					"identifiers": map[string]interface{}{
+						"object_type_id": objectTypeID,
+						"object_id":      objectID,
+					},
+				},
+			},
+		},
+	}
+}
+
+// TrackEvent records a named event with optional data on the customer's profile, so Customer.io
+// campaigns can trigger off it
+func (c *CustomerIOClient) TrackEvent(ctx context.Context, email, name string, data map[string]interface{}) (TrackResult, error) {
+	payload := map[string]interface{}{"name": name}
+	if len(data) > 0 {
+		payload["data"] = data
+	}
+	return c.sendTrackRequest(ctx, http.MethodPost, c.customerURL(email)+"/events", email, payload, "event "+name)
+}
+
+// putCustomer sends a PUT to the customer endpoint and checks the response.
+// operation describes the call in logs and errors (e.g. "attribute update").
+// ctx is usually the inbound Fiber request context (fasthttp cancels it on server shutdown),
+// so the request and any retry backoff stop when it is done.
+func (c *CustomerIOClient) putCustomer(ctx context.Context, identifier string, payload map[string]interface{}, operation string) (TrackResult, error) {
+	return c.sendTrackRequest(ctx, http.MethodPut, c.customerURL(identifier), identifier, payload, operation)
+}
+
+// sendTrackRequest sends a JSON payload to a Track API endpoint for one customer and checks the response
+func (c *CustomerIOClient) sendTrackRequest(ctx context.Context, method, endpoint, identifier string, payload map[string]interface{}, operation string) (TrackResult, error) {
+	var result TrackResult
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Failed to marshal Track API payload", "operation", operation, "email", logEmail(identifier), "error", err)
+		return result, fmt.Errorf("error marshalling %s payload: %w", operation, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		slog.Error("Failed to create Track API request", "operation", operation, "email", logEmail(identifier), "error", err)
+		return result, fmt.Errorf("error creating %s request: %w", operation, err)
+	}
+
+	// Track API uses Basic Auth: Site ID as username, API Key as password
+	req.SetBasicAuth(c.SiteID, c.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	requestID := c.setRequestHeaders(req)
+
+	slog.Debug("Sending Track API request", "operation", operation, "email", logEmail(identifier), "method", method, "request_id", requestID)
+	if debugPayloads {
+		slog.Debug("Track API request payload", "operation", operation, "request_id", requestID, "payload", string(payloadBytes))
+	}
+
+	// In dry-run mode, report what would have been sent and treat it as a success
+	if c.DryRun {
+		slog.Info("DRY RUN: Track API request not sent", "operation", operation, "request_id", requestID, "method", req.Method, "endpoint", req.URL.String(), "payload", string(payloadBytes))
+		result.DryRun = true
+		return result, nil
+	}
+
+	resp, retries, err := doTrackRequestWithRetry(c.HTTPClient, req, customerIOMaxRetries)
+	result.Retries = retries
+	if err != nil {
+		slog.Error("Failed to send Track API request", "operation", operation, "email", logEmail(identifier), "request_id", requestID, "error", err)
+		return result, fmt.Errorf("error sending %s request: %w", operation, err)
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+
+	respBodyBytes, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		slog.Error("Failed to read Track API response body", "operation", operation, "email", logEmail(identifier), "request_id", requestID, "error", readErr)
+		// Continue, but log this error.
+	}
+
+	if debugPayloads {
+		slog.Debug("Track API response", "operation", operation, "email", logEmail(identifier), "request_id", requestID, "status_code", resp.StatusCode, "body", string(respBodyBytes))
+	} else {
+		slog.Debug("Track API response", "operation", operation, "email", logEmail(identifier), "request_id", requestID, "status_code", resp.StatusCode)
+	}
+
+	// Anonymous profiles need to be identified before attribute updates apply reliably
+	if isAnonymousProfileResponse(resp.StatusCode, respBodyBytes) {
+		slog.Warn("Customer.io reports an anonymous profile", "operation", operation, "email", logEmail(identifier), "request_id", requestID, "status_code", resp.StatusCode)
+		return result, fmt.Errorf("%w: %s", errAnonymousProfile, logEmail(identifier))
+	}
+
+	// Check if response indicates success
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if isCredentialsRejectedStatus(resp.StatusCode) {
+			slog.Error("Customer.io credentials rejected - check CUSTOMERIO_SITE_ID and CUSTOMERIO_API_KEY", "operation", operation, "request_id", requestID, "status_code", resp.StatusCode)
+		} else {
+			slog.Error("Track API returned non-success status", "operation", operation, "email", logEmail(identifier), "request_id", requestID, "status_code", resp.StatusCode, "body", string(respBodyBytes))
+		}
+		return result, &TrackAPIError{
+			Operation:  operation,
+			Identifier: logEmail(identifier),
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Body:       string(respBodyBytes),
+		}
+	}
+
+	return result, nil
+}
+
+// CustomerExists reports whether Customer.io has a profile with this email (or customer ID), using the App API
+// customer search. Every Track API call upserts, so this is the only way to check without side effects.
+func (c *CustomerIOClient) CustomerExists(ctx context.Context, email string) (bool, error) {
+	if !isEmailIdentifier(email) {
+		_, found, err := c.CustomerAttributes(ctx, email)
+		return found, err
+	}
+
+	body, requestID, err := c.appAPIGet(ctx, "/v1/customers?email="+url.QueryEscape(email), "customer lookup", email)
+	if err != nil {
+		return false, err
+	}
+
+	var lookup struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(body, &lookup); err != nil {
+		return false, fmt.Errorf("error parsing customer lookup response: %w", err)
+	}
+
+	slog.Debug("Customer lookup", "email", logEmail(email), "request_id", requestID, "matches", len(lookup.Results))
+	return len(lookup.Results) > 0, nil
+}
+
+// CustomerAttributes returns the attributes of the profile with this email (or customer ID), using the
+// App API. found is false when Customer.io has no such profile.
+func (c *CustomerIOClient) CustomerAttributes(ctx context.Context, email string) (attributes map[string]interface{}, found bool, err error) {
+	idType := "email"
+	if !isEmailIdentifier(email) {
+		idType = "id"
+	}
+	path := "/v1/customers/" + escapeCustomerIdentifier(email) + "/attributes?id_type=" + idType
+	body, requestID, err := c.appAPIGet(ctx, path, "attribute lookup", email)
+	var apiErr *TrackAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		slog.Debug("Attribute lookup found no customer", "email", logEmail(email), "request_id", requestID)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var lookup struct {
+		Customer struct {
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"customer"`
+	}
+	if err := json.Unmarshal(body, &lookup); err != nil {
+		return nil, false, fmt.Errorf("error parsing attribute lookup response: %w", err)
+	}
+	return lookup.Customer.Attributes, true, nil
+}
+
+// appAPIGet sends an authenticated GET to the App API and returns the response body and request ID.
+// Non-2xx responses are returned as *TrackAPIError.
+func (c *CustomerIOClient) appAPIGet(ctx context.Context, path, operation, email string) ([]byte, string, error) {
+	if c.AppAPIKey == "" {
+		return nil, "", fmt.Errorf("%s requires an App API key", operation)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.AppBaseURL+path, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating %s request: %w", operation, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.AppAPIKey)
+	req.Header.Set("Accept", "application/json")
+	requestID := c.setRequestHeaders(req)
+
+	resp, _, err := doTrackRequestWithRetry(c.HTTPClient, req, customerIOMaxRetries)
+	if err != nil {
+		slog.Error("Failed to send App API request", "operation", operation, "email", logEmail(email), "request_id", requestID, "error", err)
+		return nil, requestID, fmt.Errorf("error sending %s request: %w", operation, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, requestID, fmt.Errorf("error reading %s response: %w", operation, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if isCredentialsRejectedStatus(resp.StatusCode) {
+			slog.Error("Customer.io credentials rejected - check CUSTOMERIO_APP_API_KEY", "operation", operation, "request_id", requestID, "status_code", resp.StatusCode)
+		}
+		return nil, requestID, &TrackAPIError{
+			Operation:  operation,
+			Identifier: logEmail(email),
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+		}
+	}
+	return body, requestID, nil
+}
+
+// Ping checks that the Track API is reachable and accepts our credentials, using the lightweight account
+// region endpoint. Rejected credentials are reported as errCredentialsRejected.
+func (c *CustomerIOClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/v1/accounts/region", nil)
+	if err != nil {
+		return fmt.Errorf("error creating ping request: %w", err)
+	}
+	req.SetBasicAuth(c.SiteID, c.APIKey)
+	c.setRequestHeaders(req)
+
+	// Not counted against CUSTOMERIO_MAX_CONCURRENCY, so a busy instance still passes its health checks;
+	// GET /health reuses the result for customerIOHealthCacheTTL (checkCustomerIOHealth) to bound the calls
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error reaching Track API: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if isCredentialsRejectedStatus(resp.StatusCode) {
+		return fmt.Errorf("Track API returned %s: %w", resp.Status, errCredentialsRejected)
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("Track API returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Limits of the Track API v2 batch endpoint
+const (
+	batchMaxPayloadBytes = 500 * 1024 // Largest batch request Customer.io accepts
+	batchMaxItemBytes    = 32 * 1024  // Largest single operation within a batch
+)
+
+// BatchOperation is one person operation sent through the Track API v2 batch endpoint
+type BatchOperation struct {
+	Identifier string                 // Email or customer ID the operation applies to
+	Operation  string                 // Describes the call in logs and errors (e.g. "attribute update")
+	item       map[string]interface{} // v2 operation body, including type, identifiers and action
+}
+
+// BatchItemResult is the outcome of one BatchOperation. Result is shared by every operation that
+// went out in the same request.
+type BatchItemResult struct {
+	Result TrackResult
+	Err    error
+}
+
+// BatchAttributeUpdate returns an operation setting attributes on the customer's profile
+func (c *CustomerIOClient) BatchAttributeUpdate(identifier string, attrs map[string]interface{}) BatchOperation {
+	item := batchPersonItem(identifier, "identify")
+	item["attributes"] = attrs
+	return BatchOperation{Identifier: identifier, Operation: "attribute update", item: item}
+}
+
+// BatchAddRelationship returns an operation relating the customer to an object.
+func (c *CustomerIOClient) BatchAddRelationship(identifier, objectTypeID, objectID string) BatchOperation {
+	return c.batchRelationship(identifier, "add_relationships", objectTypeID, objectID, "relationship creation")
+}
+
+// BatchRemoveRelationship returns an operation removing the customer's relationship to an object.
+func (c *CustomerIOClient) BatchRemoveRelationship(identifier, objectTypeID, objectID string) BatchOperation {
+	return c.batchRelationship(identifier, "delete_relationships", objectTypeID, objectID, "relationship removal")
+}
+
+func (c *CustomerIOClient) batchRelationship(identifier, action, objectTypeID, objectID, operation string) BatchOperation {
+	item := batchPersonItem(identifier, action)
+	item["cio_relationships"] = []map[string]interface{}{
+		{
+			"identifiers": map[string]interface{}{
+				"object_type_id": c.objectType(objectTypeID),
+				"object_id":      objectID,
+			},
+		},
+	}
+	return BatchOperation{Identifier: identifier, Operation: operation, item: item}
+}
+
+// batchPersonItem starts a v2 person operation, identified by email or by customer ID
+func batchPersonItem(identifier, action string) map[string]interface{} {
+	identifiers := map[string]interface{}{"id": identifier}
+	if isEmailIdentifier(identifier) {
+		identifiers = map[string]interface{}{"email": identifier}
+	}
+	return map[string]interface{}{
+		"type":        "person",
+		"identifiers": identifiers,
+		"action":      action,
+	}
+}
+
+// SendBatch sends operations through the Track API v2 batch endpoint, split into as few requests
+// as the size limits allow, and returns one result per operation in the same order. Requests go out
+// one after another, so operations for the same customer are applied in order.
+func (c *CustomerIOClient) SendBatch(ctx context.Context, ops []BatchOperation) []BatchItemResult {
+	results := make([]BatchItemResult, len(ops))
+
+	var chunk []int
+	var chunkItems []json.RawMessage
+	chunkBytes := 0
+	flush := func() {
+		if len(chunk) > 0 {
+			c.sendBatchChunk(ctx, ops, chunk, chunkItems, results)
+		}
+		chunk, chunkItems, chunkBytes = nil, nil, 0
+	}
+
+	for i, op := range ops {
+		itemBytes, err := json.Marshal(op.item)
+		if err != nil {
+			results[i].Err = fmt.Errorf("error marshalling batch %s: %w", op.Operation, err)
+			continue
+		}
+		if len(itemBytes) > batchMaxItemBytes {
+			slog.Error("Batch operation too large, not sent", "operation", op.Operation, "email", logEmail(op.Identifier), "bytes", len(itemBytes), "max", batchMaxItemBytes)
+			results[i].Err = fmt.Errorf("batch %s for %s is %d bytes, over the %d byte limit", op.Operation, logEmail(op.Identifier), len(itemBytes), batchMaxItemBytes)
+			continue
+		}
+
+		// {"batch":[...]} plus a comma per item
+		if chunkBytes+len(itemBytes)+1+len(`{"batch":[]}`) > batchMaxPayloadBytes {
+			flush()
+		}
+		chunk = append(chunk, i)
+		chunkItems = append(chunkItems, itemBytes)
+		chunkBytes += len(itemBytes) + 1
+	}
+	flush()
+
+	return results
+}
+
+// sendBatchChunk sends the operations at indexes in one batch request and fills in their results.
+// A failed request fails every operation in it; otherwise only those Customer.io lists as rejected fail.
+func (c *CustomerIOClient) sendBatchChunk(ctx context.Context, ops []BatchOperation, indexes []int, items []json.RawMessage, results []BatchItemResult) {
+	fail := func(result TrackResult, err error) {
+		for _, i := range indexes {
+			results[i] = BatchItemResult{Result: result, Err: err}
+		}
+	}
+
+	var result TrackResult
+	payloadBytes, err := json.Marshal(map[string]interface{}{"batch": items})
+	if err != nil {
+		fail(result, fmt.Errorf("error marshalling batch payload: %w", err))
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/api/v2/batch", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		fail(result, fmt.Errorf("error creating batch request: %w", err))
+		return
+	}
+	req.SetBasicAuth(c.SiteID, c.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	requestID := c.setRequestHeaders(req)
+
+	slog.Debug("Sending Track API batch request", "operations", len(indexes), "bytes", len(payloadBytes), "request_id", requestID)
+	if debugPayloads {
+		slog.Debug("Track API batch payload", "request_id", requestID, "payload", string(payloadBytes))
+	}
+
+	if c.DryRun {
+		slog.Info("DRY RUN: Track API batch request not sent", "request_id", requestID, "endpoint", req.URL.String(), "operations", len(indexes), "payload", string(payloadBytes))
+		result.DryRun = true
+		fail(result, nil)
+		return
+	}
+
+	resp, retries, err := doTrackRequestWithRetry(c.HTTPClient, req, customerIOMaxRetries)
+	result.Retries = retries
+	if err != nil {
+		slog.Error("Failed to send Track API batch request", "operations", len(indexes), "request_id", requestID, "error", err)
+		fail(result, fmt.Errorf("error sending batch request: %w", err))
+		return
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+
+	respBodyBytes, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		slog.Error("Failed to read Track API batch response body", "request_id", requestID, "error", readErr)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if isCredentialsRejectedStatus(resp.StatusCode) {
+			slog.Error("Customer.io credentials rejected - check CUSTOMERIO_SITE_ID and CUSTOMERIO_API_KEY", "operation", "batch", "request_id", requestID, "status_code", resp.StatusCode)
+		} else {
+			slog.Error("Track API batch returned non-success status", "operations", len(indexes), "request_id", requestID, "status_code", resp.StatusCode, "body", string(respBodyBytes))
+		}
+		for _, i := range indexes {
+			results[i] = BatchItemResult{Result: result, Err: &TrackAPIError{
+				Operation:  "batch " + ops[i].Operation,
+				Identifier: logEmail(ops[i].Identifier),
+				Status:     resp.Status,
+				StatusCode: resp.StatusCode,
+				Body:       string(respBodyBytes),
+			}}
+		}
+		return
+	}
+
+	fail(result, nil)
+
+	// Operations Customer.io could not apply are listed by their position in this request
+	var rejected struct {
+		Errors []struct {
+			BatchIndex int    `json:"batch_index"`
+			Reason     string `json:"reason"`
+			Field      string `json:"field"`
+			Message    string `json:"message"`
+		} `json:"errors"`
+	}
+	if len(respBodyBytes) > 0 && json.Unmarshal(respBodyBytes, &rejected) != nil {
+		slog.Warn("Could not parse Track API batch response", "request_id", requestID, "status_code", resp.StatusCode)
+	}
+	for _, itemErr := range rejected.Errors {
+		if itemErr.BatchIndex < 0 || itemErr.BatchIndex >= len(indexes) {
+			continue
+		}
+		op := ops[indexes[itemErr.BatchIndex]]
+		slog.Error("Track API batch operation rejected", "operation", op.Operation, "email", logEmail(op.Identifier), "request_id", requestID,
+			"reason", itemErr.Reason, "field", itemErr.Field, "message", itemErr.Message)
+		results[indexes[itemErr.BatchIndex]].Result.StatusCode = http.StatusBadRequest
+		results[indexes[itemErr.BatchIndex]].Err = &TrackAPIError{
+			Operation:  "batch " + op.Operation,
+			Identifier: logEmail(op.Identifier),
+			Status:     "rejected (" + itemErr.Reason + ")",
+			StatusCode: http.StatusBadRequest,
+			Body:       strings.TrimSpace(itemErr.Field + " " + itemErr.Message),
+		}
+	}
+	slog.Debug("Track API batch response", "operations", len(indexes), "rejected", len(rejected.Errors), "request_id", requestID, "status_code", resp.StatusCode)
+}
@@ -0,0 +1,737 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// trackRequest is a request received by the mock Track API
+type trackRequest struct {
+	Method   string
+	Path     string // Escaped path, so %2B in emails is visible
+	Username string
+	Password string
+	Body     map[string]interface{}
+}
+
+// mockTrackAPI is an httptest server standing in for the Customer.io Track API
+type mockTrackAPI struct {
+	mu       sync.Mutex
+	requests []trackRequest
+}
+
+// setupMockTrackAPI points the package client at an httptest server that answers every request
+// with status and body, and speeds up retries. Globals are restored when the test ends.
+func setupMockTrackAPI(t *testing.T, status int, body string) *mockTrackAPI {
+	t.Helper()
+	mock := &mockTrackAPI{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := trackRequest{Method: r.Method, Path: r.URL.EscapedPath()}
+		request.Username, request.Password, _ = r.BasicAuth()
+		if raw, err := io.ReadAll(r.Body); err == nil && len(raw) > 0 {
+			if err := json.Unmarshal(raw, &request.Body); err != nil {
+				t.Errorf("request body is not JSON: %v (%s)", err, raw)
+			}
+		}
+
+		mock.mu.Lock()
+		mock.requests = append(mock.requests, request)
+		mock.mu.Unlock()
+
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+
+	previousClient, previousRetries, previousDelay := customerIO, customerIOMaxRetries, customerIORetryBaseDelay
+	customerIO = NewCustomerIOClient("test-site", "test-key", server.URL, 5*time.Second)
+	customerIOMaxRetries = 2
+	customerIORetryBaseDelay = time.Millisecond
+	t.Cleanup(func() {
+		server.Close()
+		customerIO, customerIOMaxRetries, customerIORetryBaseDelay = previousClient, previousRetries, previousDelay
+	})
+
+	return mock
+}
+
+// received returns a copy of the requests the mock has seen
+func (m *mockTrackAPI) received() []trackRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]trackRequest(nil), m.requests...)
+}
+
+func TestTrackAPIHelpers(t *testing.T) {
+	const email = "jane+news@example.com"
+	const escapedPath = "/api/v1/customers/jane%2Bnews@example.com"
+
+	previousKeys := subscriptionKeys
+	subscriptionKeys = []string{"sub_bbau", "sub_bbus"}
+	t.Cleanup(func() { subscriptionKeys = previousKeys })
+
+	helpers := []struct {
+		name        string
+		call        func(ctx context.Context) (TrackResult, error)
+		wantPayload map[string]interface{}
+	}{
+		{
+			name: "pause",
+			call: func(ctx context.Context) (TrackResult, error) {
+				return updateCustomerPausedAttributeFlexible(ctx, email, true)
+			},
+			wantPayload: map[string]interface{}{"paused": true},
+		},
+		{
+			name: "unpause",
+			call: func(ctx context.Context) (TrackResult, error) {
+				return updateCustomerPausedAttributeFlexible(ctx, email, false)
+			},
+			wantPayload: map[string]interface{}{"paused": false},
+		},
+		{
+			name:        "unsubscribe",
+			call:        func(ctx context.Context) (TrackResult, error) { return unsubscribeCustomerByEmail(ctx, email) },
+			wantPayload: map[string]interface{}{"unsubscribed": true},
+		},
+		{
+			name: "create relationship",
+			call: func(ctx context.Context) (TrackResult, error) {
+				return createCustomerRelationship(ctx, email, "", "BBAU")
+			},
+			wantPayload: map[string]interface{}{
+				"cio_relationships": map[string]interface{}{
+					"action": "add_relationships",
+					"relationships": []interface{}{
+						map[string]interface{}{
+							"identifiers": map[string]interface{}{"object_type_id": "1", "object_id": "BBAU"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "unsubscribe all brands",
+			call: func(ctx context.Context) (TrackResult, error) { return unsubscribeAllBrands(ctx, email) },
+			wantPayload: map[string]interface{}{
+				"email":      email,
+				"attributes": map[string]interface{}{"unsubscribed": true, "sub_bbau": false, "sub_bbus": false},
+			},
+		},
+	}
+
+	responses := []struct {
+		name         string
+		status       int
+		wantErr      bool
+		wantRequests int // Retryable statuses are sent customerIOMaxRetries+1 times
+	}{
+		{name: "2xx", status: http.StatusOK, wantRequests: 1},
+		{name: "4xx", status: http.StatusBadRequest, wantErr: true, wantRequests: 1},
+		{name: "5xx", status: http.StatusInternalServerError, wantErr: true, wantRequests: 3},
+	}
+
+	for _, helper := range helpers {
+		for _, response := range responses {
+			t.Run(helper.name+"/"+response.name, func(t *testing.T) {
+				mock := setupMockTrackAPI(t, response.status, `{}`)
+
+				result, err := helper.call(context.Background())
+
+				var apiErr *TrackAPIError
+				switch {
+				case response.wantErr && !errors.As(err, &apiErr):
+					t.Fatalf("error = %v, want *TrackAPIError", err)
+				case response.wantErr && apiErr.StatusCode != response.status:
+					t.Errorf("TrackAPIError.StatusCode = %d, want %d", apiErr.StatusCode, response.status)
+				case !response.wantErr && err != nil:
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.StatusCode != response.status {
+					t.Errorf("result.StatusCode = %d, want %d", result.StatusCode, response.status)
+				}
+				if result.Retries != response.wantRequests-1 {
+					t.Errorf("result.Retries = %d, want %d", result.Retries, response.wantRequests-1)
+				}
+
+				requests := mock.received()
+				if len(requests) != response.wantRequests {
+					t.Fatalf("got %d requests, want %d", len(requests), response.wantRequests)
+				}
+				for _, request := range requests {
+					if request.Method != http.MethodPut {
+						t.Errorf("method = %s, want PUT", request.Method)
+					}
+					if request.Path != escapedPath {
+						t.Errorf("path = %s, want %s", request.Path, escapedPath)
+					}
+					if request.Username != "test-site" || request.Password != "test-key" {
+						t.Errorf("basic auth = %q:%q, want test-site:test-key", request.Username, request.Password)
+					}
+					if !reflect.DeepEqual(request.Body, helper.wantPayload) {
+						t.Errorf("payload = %v, want %v", request.Body, helper.wantPayload)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestTrackAPIAnonymousProfile(t *testing.T) {
+	setupMockTrackAPI(t, http.StatusBadRequest, `{"meta":{"error":"customer is anonymous"}}`)
+
+	_, err := unsubscribeCustomerByEmail(context.Background(), "anon@example.com")
+	if !errors.Is(err, errAnonymousProfile) {
+		t.Fatalf("error = %v, want errAnonymousProfile", err)
+	}
+}
+
+func TestTrackAPICredentialsRejected(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		mock := setupMockTrackAPI(t, status, `{"meta":{"error":"Unauthorized request"}}`)
+
+		_, err := unsubscribeCustomerByEmail(context.Background(), "jane@example.com")
+		if !errors.Is(err, errCredentialsRejected) {
+			t.Fatalf("status %d: error = %v, want errCredentialsRejected", status, err)
+		}
+		var apiErr *TrackAPIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != status {
+			t.Errorf("status %d: error = %v, want a *TrackAPIError with the status", status, err)
+		}
+		if got := len(mock.received()); got != 1 {
+			t.Errorf("status %d: got %d requests, want 1 (rejected credentials are not retried)", status, got)
+		}
+		if got := actionErrorStatus(err); got != http.StatusServiceUnavailable {
+			t.Errorf("status %d: actionErrorStatus = %d, want 503", status, got)
+		}
+	}
+
+	// Other client errors stay distinct from authentication failures
+	setupMockTrackAPI(t, http.StatusBadRequest, `{"meta":{"error":"bad payload"}}`)
+	_, err := unsubscribeCustomerByEmail(context.Background(), "jane@example.com")
+	if err == nil || errors.Is(err, errCredentialsRejected) {
+		t.Errorf("400 error = %v, want a non-credential error", err)
+	}
+	if got := customerIOFailureStatus(err); got != http.StatusInternalServerError {
+		t.Errorf("customerIOFailureStatus(400) = %d, want 500", got)
+	}
+
+	// Link actions tell a rejected request apart from a Customer.io outage
+	var apiErr *TrackAPIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || !strings.Contains(apiErr.Body, "bad payload") {
+		t.Errorf("400 error = %v, want a *TrackAPIError with the status and body", err)
+	}
+	if got := actionErrorStatus(err); got != http.StatusBadRequest {
+		t.Errorf("actionErrorStatus(400) = %d, want 400", got)
+	}
+	setupMockTrackAPI(t, http.StatusInternalServerError, `{}`)
+	_, err = unsubscribeCustomerByEmail(context.Background(), "jane@example.com")
+	if got := actionErrorStatus(err); got != http.StatusBadGateway {
+		t.Errorf("actionErrorStatus(500) = %d, want 502", got)
+	}
+}
+
+func TestTrackAPIConcurrencyLimit(t *testing.T) {
+	mock := setupMockTrackAPI(t, http.StatusOK, `{}`)
+	previousSlots, previousWait := customerIOSlots, customerIOConcurrencyWait
+	customerIOSlots, customerIOConcurrencyWait = make(chan struct{}, 1), 20*time.Millisecond
+	t.Cleanup(func() { customerIOSlots, customerIOConcurrencyWait = previousSlots, previousWait })
+
+	// With the only slot taken, a request waits out the limit and is rejected without being sent
+	release, err := acquireCustomerIOSlot(context.Background())
+	if err != nil {
+		t.Fatalf("acquireCustomerIOSlot failed: %v", err)
+	}
+	_, err = unsubscribeCustomerByEmail(context.Background(), "jane@example.com")
+	if !errors.Is(err, errCustomerIOBusy) {
+		t.Fatalf("error = %v, want errCustomerIOBusy", err)
+	}
+	if got := actionErrorStatus(err); got != http.StatusTooManyRequests {
+		t.Errorf("actionErrorStatus = %d, want 429", got)
+	}
+	if got := len(mock.received()); got != 0 {
+		t.Errorf("got %d requests, want 0 while the limit is reached", got)
+	}
+
+	// Once freed, requests go through and give their slot back when done
+	release()
+	release() // Releasing twice must not free a slot someone else holds
+	for i := 0; i < 2; i++ {
+		if _, err := unsubscribeCustomerByEmail(context.Background(), "jane@example.com"); err != nil {
+			t.Fatalf("request %d failed: %v", i+1, err)
+		}
+	}
+	if got := len(customerIOSlots); got != 0 {
+		t.Errorf("%d slots still held after the requests finished, want 0", got)
+	}
+}
+
+func TestTrackAPIHelpersByCustomerID(t *testing.T) {
+	previousKeys := subscriptionKeys
+	subscriptionKeys = []string{"sub_bbau"}
+	t.Cleanup(func() { subscriptionKeys = previousKeys })
+
+	id, err := validateCustomerIdentifier("", " abc123 ")
+	if err != nil || id != "abc123" || isEmailIdentifier(id) {
+		t.Fatalf("validateCustomerIdentifier(\"\", \" abc123 \") = %q, %v; want customer ID abc123", id, err)
+	}
+	for _, invalid := range []string{"", "a@b", "a/b", "a b"} {
+		if _, err := validateCustomerIdentifier("", invalid); err == nil {
+			t.Errorf("validateCustomerIdentifier(\"\", %q) succeeded, want error", invalid)
+		}
+	}
+
+	mock := setupMockTrackAPI(t, http.StatusOK, `{}`)
+	ctx := context.Background()
+	if _, err := updateCustomerSubscriptionAttributes(ctx, id, map[string]string{"sub_bbau": "false"}); err != nil {
+		t.Fatalf("updateCustomerSubscriptionAttributes: %v", err)
+	}
+	if _, err := unsubscribeAllBrands(ctx, id); err != nil {
+		t.Fatalf("unsubscribeAllBrands: %v", err)
+	}
+
+	// Customer IDs go in the path and are never sent as the email attribute
+	want := map[string]interface{}{
+		"attributes": map[string]interface{}{"unsubscribed": true, "sub_bbau": false},
+	}
+	requests := mock.received()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	for _, request := range requests {
+		if request.Path != "/api/v1/customers/abc123" {
+			t.Errorf("path = %s, want /api/v1/customers/abc123", request.Path)
+		}
+		if !reflect.DeepEqual(request.Body, want) {
+			t.Errorf("payload = %v, want %v", request.Body, want)
+		}
+	}
+}
+
+func TestTrackAPIReusesConnections(t *testing.T) {
+	var mu sync.Mutex
+	remoteAddrs := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remoteAddrs[r.RemoteAddr] = true
+		mu.Unlock()
+		io.WriteString(w, `{}`)
+	}))
+	t.Cleanup(server.Close)
+
+	previousClient := customerIO
+	customerIO = NewCustomerIOClient("test-site", "test-key", server.URL, 5*time.Second)
+	t.Cleanup(func() { customerIO = previousClient })
+
+	for range 5 {
+		if _, err := updateCustomerPausedAttributeFlexible(context.Background(), "jane@example.com", true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Sequential calls should all travel over one pooled keep-alive connection
+	if len(remoteAddrs) != 1 {
+		t.Errorf("requests used %d connections, want 1", len(remoteAddrs))
+	}
+	transport := customerIO.HTTPClient.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != customerIOMaxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, customerIOMaxIdleConnsPerHost)
+	}
+}
+
+func TestCustomerURL(t *testing.T) {
+	client := NewCustomerIOClient("test-site", "test-key", "https://track.example.com", 5*time.Second)
+	tests := []struct {
+		identifier string
+		want       string
+	}{
+		{"jane@example.com", "https://track.example.com/api/v1/customers/jane@example.com"},
+		{"jane+news@example.com", "https://track.example.com/api/v1/customers/jane%2Bnews@example.com"},
+		{"o'brien/a#b?c%d e+f@ex/ample.com", "https://track.example.com/api/v1/customers/o%27brien%2Fa%23b%3Fc%25d%20e%2Bf@ex%2Fample.com"},
+		{"cio_03000001", "https://track.example.com/api/v1/customers/cio_03000001"},
+	}
+	for _, tt := range tests {
+		if got := client.customerURL(tt.identifier); got != tt.want {
+			t.Errorf("customerURL(%q) = %s, want %s", tt.identifier, got, tt.want)
+		}
+	}
+
+	// The Track API must see the whole identifier as one path segment
+	mock := setupMockTrackAPI(t, http.StatusOK, `{}`)
+	const email = "o'brien/a#b?c%d e+f@example.com"
+	if _, err := updateCustomerPausedAttributeFlexible(context.Background(), email, true); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+	requests := mock.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	if want := "/api/v1/customers/o%27brien%2Fa%23b%3Fc%25d%20e%2Bf@example.com"; requests[0].Path != want {
+		t.Errorf("path = %s, want %s", requests[0].Path, want)
+	}
+}
+
+func TestCustomerAttributes(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantFound bool
+		wantErr   bool
+		want      map[string]interface{}
+	}{
+		{
+			name:      "existing customer",
+			status:    http.StatusOK,
+			body:      `{"customer":{"id":"42","attributes":{"sub_bbau":"true","sub_bbus":"false"}}}`,
+			wantFound: true,
+			want:      map[string]interface{}{"sub_bbau": "true", "sub_bbus": "false"},
+		},
+		{name: "unknown customer", status: http.StatusNotFound, body: `{"errors":[{"detail":"not found"}]}`},
+		{name: "unauthorized", status: http.StatusUnauthorized, body: `{}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath, gotAuth string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath, gotAuth = r.URL.EscapedPath()+"?"+r.URL.RawQuery, r.Header.Get("Authorization")
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			t.Cleanup(server.Close)
+
+			client := NewCustomerIOClient("test-site", "test-key", "", 5*time.Second)
+			client.AppBaseURL, client.AppAPIKey = server.URL, "app-key"
+
+			attributes, found, err := client.CustomerAttributes(context.Background(), "jane+news@example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if found != tt.wantFound || !reflect.DeepEqual(attributes, tt.want) {
+				t.Errorf("CustomerAttributes = %v, %v; want %v, %v", attributes, found, tt.want, tt.wantFound)
+			}
+			if want := "/v1/customers/jane%2Bnews@example.com/attributes?id_type=email"; gotPath != want {
+				t.Errorf("path = %s, want %s", gotPath, want)
+			}
+			if gotAuth != "Bearer app-key" {
+				t.Errorf("Authorization = %q, want Bearer app-key", gotAuth)
+			}
+		})
+	}
+}
+
+func TestSubscriptionState(t *testing.T) {
+	tests := map[interface{}]string{
+		"true":  "true",
+		true:    "true",
+		"false": "false",
+		false:   "false",
+		"none":  "none",
+		nil:     "none",
+		"yes":   "none",
+	}
+	for value, want := range tests {
+		if got := subscriptionState(value); got != want {
+			t.Errorf("subscriptionState(%v) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestTrackEvent(t *testing.T) {
+	mock := setupMockTrackAPI(t, http.StatusOK, `{}`)
+
+	data := map[string]interface{}{"subscriptions": map[string]string{"sub_bbau": "true"}}
+	if err := trackEvent(context.Background(), "jane+news@example.com", "preferences_updated", data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	requests := mock.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+	if request.Method != http.MethodPost || request.Path != "/api/v1/customers/jane%2Bnews@example.com/events" {
+		t.Errorf("request = %s %s, want POST /api/v1/customers/jane%%2Bnews@example.com/events", request.Method, request.Path)
+	}
+	want := map[string]interface{}{
+		"name": "preferences_updated",
+		"data": map[string]interface{}{"subscriptions": map[string]interface{}{"sub_bbau": "true"}},
+	}
+	if !reflect.DeepEqual(request.Body, want) {
+		t.Errorf("payload = %v, want %v", request.Body, want)
+	}
+}
+
+func TestSubscriptionChangeDetails(t *testing.T) {
+	requested := map[string]string{"sub_bbau": "false", "sub_bbus": "true"}
+
+	setupMockTrackAPI(t, http.StatusOK, `{"customer":{"attributes":{"sub_bbau":"true"}}}`)
+	if got, want := subscriptionChangeDetails(context.Background(), "jane@example.com", requested), map[string]interface{}{
+		"sub_bbau": "false",
+		"sub_bbus": "true",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without App API key = %v, want %v", got, want)
+	}
+
+	customerIO.AppBaseURL, customerIO.AppAPIKey = customerIO.BaseURL, "app-key"
+	want := map[string]interface{}{
+		"sub_bbau": map[string]interface{}{"from": "true", "to": "false"},
+		"sub_bbus": map[string]interface{}{"from": "none", "to": "true"},
+	}
+	got := subscriptionChangeDetails(context.Background(), "jane@example.com", requested)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("with prior states = %v, want %v", got, want)
+	}
+	if label, wantLabel := (DisplayRecord{Details: recordDetails(got)}).DetailsLabel(), "sub_bbau: true → false, sub_bbus: none → true"; label != wantLabel {
+		t.Errorf("DetailsLabel = %q, want %q", label, wantLabel)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	mock := setupMockTrackAPI(t, http.StatusOK, `{"errors":[{"batch_index":1,"reason":"invalid","field":"identifiers","message":"email is invalid"}]}`)
+
+	ops := []BatchOperation{
+		customerIO.BatchAttributeUpdate("jane@example.com", map[string]interface{}{"paused": true}),
+		customerIO.BatchAttributeUpdate("not-an-email@", map[string]interface{}{"paused": true}),
+		customerIO.BatchRemoveRelationship("cust-42", "", "BBUS"),
+	}
+	results := customerIO.SendBatch(context.Background(), ops)
+
+	requests := mock.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	if requests[0].Method != http.MethodPost || requests[0].Path != "/api/v2/batch" {
+		t.Errorf("request = %s %s, want POST /api/v2/batch", requests[0].Method, requests[0].Path)
+	}
+	wantBatch := []interface{}{
+		map[string]interface{}{
+			"type": "person", "action": "identify",
+			"identifiers": map[string]interface{}{"email": "jane@example.com"},
+			"attributes":  map[string]interface{}{"paused": true},
+		},
+		map[string]interface{}{
+			"type": "person", "action": "identify",
+			"identifiers": map[string]interface{}{"email": "not-an-email@"},
+			"attributes":  map[string]interface{}{"paused": true},
+		},
+		map[string]interface{}{
+			"type": "person", "action": "delete_relationships",
+			"identifiers": map[string]interface{}{"id": "cust-42"},
+			"cio_relationships": []interface{}{
+				map[string]interface{}{
+					"identifiers": map[string]interface{}{"object_type_id": "1", "object_id": "BBUS"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(requests[0].Body["batch"], wantBatch) {
+		t.Errorf("batch = %#v, want %#v", requests[0].Body["batch"], wantBatch)
+	}
+
+	if len(results) != len(ops) {
+		t.Fatalf("got %d results, want %d", len(results), len(ops))
+	}
+	for i, result := range results {
+		if wantErr := i == 1; (result.Err != nil) != wantErr {
+			t.Errorf("result %d error = %v, want error: %v", i, result.Err, wantErr)
+		}
+	}
+	var apiErr *TrackAPIError
+	if !errors.As(results[1].Err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("rejected operation error = %v, want a 400 TrackAPIError", results[1].Err)
+	}
+}
+
+func TestSendBatchSplitsBySize(t *testing.T) {
+	mock := setupMockTrackAPI(t, http.StatusOK, `{}`)
+
+	// 20 operations of ~30 KB exceed the 500 KB request limit; the oversized one is never sent
+	padding := strings.Repeat("x", 30*1024)
+	var ops []BatchOperation
+	for i := range 20 {
+		ops = append(ops, customerIO.BatchAttributeUpdate(fmt.Sprintf("user%d@example.com", i), map[string]interface{}{"note": padding}))
+	}
+	ops = append(ops, customerIO.BatchAttributeUpdate("big@example.com", map[string]interface{}{"note": padding + padding}))
+	results := customerIO.SendBatch(context.Background(), ops)
+
+	requests := mock.received()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	sent := 0
+	for _, request := range requests {
+		sent += len(request.Body["batch"].([]interface{}))
+	}
+	if sent != 20 {
+		t.Errorf("sent %d operations, want 20", sent)
+	}
+	for i, result := range results {
+		if wantErr := i == 20; (result.Err != nil) != wantErr {
+			t.Errorf("result %d error = %v, want error: %v", i, result.Err, wantErr)
+		}
+	}
+}
+
+func TestSendBatchRequestFailure(t *testing.T) {
+	setupMockTrackAPI(t, http.StatusUnauthorized, `{"meta":{"error":"unauthorized"}}`)
+
+	results := customerIO.SendBatch(context.Background(), []BatchOperation{
+		customerIO.BatchAttributeUpdate("a@example.com", map[string]interface{}{"paused": true}),
+		customerIO.BatchAddRelationship("b@example.com", "", "BBAU"),
+	})
+	for i, result := range results {
+		if !errors.Is(result.Err, errCredentialsRejected) {
+			t.Errorf("result %d error = %v, want errCredentialsRejected", i, result.Err)
+		}
+	}
+}
+
+func TestWebhookTimestampFresh(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		timestamp string
+		want      bool
+	}{
+		{"1700000000", true},
+		{"1699999700", true},  // 5 minutes old
+		{"1700000300", true},  // 5 minutes ahead (clock skew)
+		{"1699999699", false}, // A captured delivery replayed later
+		{"1700000301", false},
+		{"", false},
+		{"not-a-number", false},
+	}
+	for _, tt := range tests {
+		if got := webhookTimestampFresh(tt.timestamp, now); got != tt.want {
+			t.Errorf("webhookTimestampFresh(%q) = %t, want %t", tt.timestamp, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCustomerIOHealthCachesResult(t *testing.T) {
+	mock := setupMockTrackAPI(t, http.StatusOK, `{}`)
+	resetHealthCache := func() {
+		customerIOHealth.mu.Lock()
+		customerIOHealth.checkedAt, customerIOHealth.err = time.Time{}, nil
+		customerIOHealth.mu.Unlock()
+	}
+	resetHealthCache()
+	t.Cleanup(resetHealthCache)
+
+	// A burst of health checks makes a single Customer.io call
+	for i := 0; i < 5; i++ {
+		if err := checkCustomerIOHealth(context.Background()); err != nil {
+			t.Fatalf("check %d: %v", i+1, err)
+		}
+	}
+	if got := len(mock.received()); got != 1 {
+		t.Errorf("got %d Customer.io requests, want 1", got)
+	}
+
+	// Once the cached result expires the next check pings again
+	customerIOHealth.mu.Lock()
+	customerIOHealth.checkedAt = time.Now().Add(-customerIOHealthCacheTTL)
+	customerIOHealth.mu.Unlock()
+	if err := checkCustomerIOHealth(context.Background()); err != nil {
+		t.Fatalf("check after expiry: %v", err)
+	}
+	if got := len(mock.received()); got != 2 {
+		t.Errorf("got %d Customer.io requests after expiry, want 2", got)
+	}
+}
+
+func TestRetryDelayBounded(t *testing.T) {
+	previousDelay := customerIORetryBaseDelay
+	customerIORetryBaseDelay = 200 * time.Millisecond
+	t.Cleanup(func() { customerIORetryBaseDelay = previousDelay })
+
+	// Far past the retry where doubling the base delay would overflow a Duration
+	for _, retry := range []int{1, 2, 8, 36, 40, 64, 1000} {
+		if got := retryDelay(retry, nil); got < customerIORetryBaseDelay || got > maxRetryDelay {
+			t.Errorf("retryDelay(%d) = %s, want between %s and %s", retry, got, customerIORetryBaseDelay, maxRetryDelay)
+		}
+	}
+
+	for _, retryAfter := range []string{"31", "9223372036854775807"} {
+		resp := &http.Response{Header: http.Header{"Retry-After": []string{retryAfter}}}
+		if got := retryDelay(1, resp); got != maxRetryDelay {
+			t.Errorf("retryDelay with Retry-After %s = %s, want %s", retryAfter, got, maxRetryDelay)
+		}
+	}
+}
+
+func TestActionTokenRoundTrip(t *testing.T) {
+	previousSecret, previousLegacy := actionTokenSecret, acceptLegacyActionTokens
+	actionTokenSecret, acceptLegacyActionTokens = "test-secret", true
+	t.Cleanup(func() { actionTokenSecret, acceptLegacyActionTokens = previousSecret, previousLegacy })
+
+	for _, email := range []string{"jane@example.com", "a|b@example.com", "a|1@example.com", "|||@example.com"} {
+		claims, err := parseActionToken(generateActionToken(email, "pause"))
+		if err != nil {
+			t.Errorf("parseActionToken for %q: %v", email, err)
+			continue
+		}
+		if claims.Email != email || claims.Action != "pause" || claims.IssuedAt.IsZero() {
+			t.Errorf("parseActionToken for %q = %+v, want email %q, action pause and an issued-at time", email, claims, email)
+		}
+	}
+
+	// Tokens issued before expiry support have no issued-at part
+	payload := base64.RawURLEncoding.EncodeToString([]byte("unpause|a|b@example.com"))
+	claims, err := parseActionToken(payload + "." + signActionPayload(payload))
+	if err != nil {
+		t.Fatalf("parseActionToken for legacy token: %v", err)
+	}
+	if claims.Email != "a|b@example.com" || claims.Action != "unpause" || !claims.IssuedAt.IsZero() {
+		t.Errorf("parseActionToken for legacy token = %+v, want email a|b@example.com, action unpause and no issued-at time", claims)
+	}
+
+	for _, claims := range []string{"pause", "|jane@example.com|1700000000", "pause||1700000000", "pause|jane@example.com|soon"} {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(claims))
+		if _, err := parseActionToken(payload + "." + signActionPayload(payload)); !errors.Is(err, errInvalidActionToken) {
+			t.Errorf("parseActionToken for claims %q = %v, want errInvalidActionToken", claims, err)
+		}
+	}
+}
+
+func TestVerifyLinkCredential(t *testing.T) {
+	previousToken, previousLink := actionTokenSecret, linkSigningSecret
+	actionTokenSecret, linkSigningSecret = "test-secret", "test-link-secret"
+	t.Cleanup(func() { actionTokenSecret, linkSigningSecret = previousToken, previousLink })
+
+	email := "jane@example.com"
+	tests := []struct {
+		name      string
+		signature string
+		token     string
+		wantErr   bool
+	}{
+		{"no credential", "", "", true},
+		{"link signature", signLink(email), "", false},
+		{"wrong signature", signLink("other@example.com"), "", true},
+		{"action token", "", generateActionToken(email, "pause"), false},
+		{"token for another action", "", generateActionToken(email, "unpause"), true},
+		{"token for another customer", "", generateActionToken("other@example.com", "pause"), true},
+	}
+	for _, tt := range tests {
+		if err := verifyLinkCredential(email, tt.signature, tt.token, "pause"); (err != nil) != tt.wantErr {
+			t.Errorf("%s: verifyLinkCredential error = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+	}
+
+	linkSigningSecret = ""
+	if err := verifyLinkCredential(email, "deadbeef", "", "pause"); err == nil {
+		t.Error("verifyLinkCredential accepted a signature with link signing disabled")
+	}
+}
@@ -2,37 +2,148 @@ package main
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
-	_ "modernc.org/sqlite" // Pure-Go SQLite driver (no CGO required)
+	"modernc.org/sqlite" // Pure-Go SQLite driver (no CGO required)
 )
 
-var db *sql.DB
+// dbHandle is the shared connection pool, set once by initDatabase and cleared by closeDatabase.
+// It is only read through database(), so handlers racing startup or shutdown see a clear error.
+var dbHandle atomic.Pointer[sql.DB]
 
-// initDatabase initializes the SQLite database and creates the table if it doesn't exist
-func initDatabase() error {
-	var err error
+// databaseDisabled is set when DB_OPTIONAL let the app start after initDatabase failed. Customer actions
+// still go to Customer.io; recording them, the pending action queue and the results pages are unavailable.
+var databaseDisabled bool
+
+var (
+	errDatabaseNotInitialized     = errors.New("database not initialized")
+	errDatabaseAlreadyInitialized = errors.New("database already initialized")
+)
+
+// database returns the connection pool, or errDatabaseNotInitialized before initDatabase or after closeDatabase
+func database() (*sql.DB, error) {
+	db := dbHandle.Load()
+	if db == nil {
+		return nil, errDatabaseNotInitialized
+	}
+	return db, nil
+}
+
+// Outcomes stored in email_processing_records.status
+const (
+	recordStatusSuccess = "success"
+	recordStatusFailed  = "failed"
+	recordStatusDryRun  = "dry_run" // Logged but not sent to Customer.io (DRY_RUN); not counted as a success
+)
+
+// SQLite connection settings applied to every pooled connection
+const (
+	sqliteBusyTimeoutMs = 5000 // How long a write waits for the lock before failing with "database is locked"
+	maxOpenDBConns      = 4    // WAL lets readers run alongside the single writer; writers queue on busy_timeout
+)
+
+// defaultDisplayTimezone is the IANA timezone records are stored and shown in when DISPLAY_TIMEZONE is unset
+const defaultDisplayTimezone = "Australia/Sydney"
+
+// displayLocation is the timezone records are stored and shown in, loaded once by configureDisplayTimezone
+var displayLocation = time.UTC
+
+// configureDisplayTimezone loads DISPLAY_TIMEZONE (IANA name), falling back to UTC if it is invalid
+func configureDisplayTimezone() {
+	name := os.Getenv("DISPLAY_TIMEZONE")
+	if name == "" {
+		name = defaultDisplayTimezone
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("WARNING: Failed to load display timezone %q, using UTC: %v", name, err)
+		location = time.UTC
+	}
+	displayLocation = location
+	log.Printf("Display timezone: %s", displayLocation)
+}
+
+// databaseSchemaVersion identifies the layout of email_processing_records for exports and importers.
+// Version 5 stores timestamps in UTC; earlier versions stored Sydney local time. Version 6 stores
+// region move details as JSON ({"from":"BBUS","to":"BBAU"}) instead of "BBUS->BBAU".
+const databaseSchemaVersion = 6
+
+// initDatabase initializes the SQLite database and creates the table if it doesn't exist.
+// It may only be called once (or again after closeDatabase); the pool only becomes visible
+// to database() once the schema is ready.
+func initDatabase() (err error) {
+	if dbHandle.Load() != nil {
+		return errDatabaseAlreadyInitialized
+	}
 
 	// Open SQLite database (creates file if it doesn't exist)
-	// Use mounted volume in production, local file in development
-	dbPath := "./email_processing.db"
-	if os.Getenv("FLY_APP_NAME") != "" {
-		// Production - use mounted volume
-		dbPath = "/app/data/email_processing.db"
+	// DATABASE_PATH wins; otherwise use mounted volume in production, local file in development
+	dbPath := os.Getenv("DATABASE_PATH")
+	if dbPath == "" {
+		dbPath = "./email_processing.db"
+		if os.Getenv("FLY_APP_NAME") != "" {
+			// Production - use mounted volume
+			dbPath = "/app/data/email_processing.db"
+		}
+	}
+	log.Printf("Database path: %s", dbPath)
+
+	// Refuse to silently create a fresh database when the persistent one is expected to exist
+	if os.Getenv("REQUIRE_EXISTING_DB") == "true" {
+		if _, statErr := os.Stat(dbPath); statErr != nil {
+			return fmt.Errorf("database file %s not found and REQUIRE_EXISTING_DB is set (is the volume mounted?): %w", dbPath, statErr)
+		}
+		log.Printf("Existing database file found at %s", dbPath)
+	}
+
+	// Create the parent directory so a freshly mounted volume doesn't fail on open
+	if err = os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return fmt.Errorf("failed to create database directory: %w", err)
 	}
-	db, err = sql.Open("sqlite", dbPath)
+
+	// Pragmas in the DSN run on every new connection, not just the first one in the pool.
+	// Write transactions take the lock up front (_txlock=immediate) so busy_timeout applies
+	// instead of failing when a read transaction tries to upgrade to a write.
+	dsn := fmt.Sprintf("%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_txlock=immediate", dbPath, sqliteBusyTimeoutMs)
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
+	defer func() {
+		if err != nil {
+			db.Close()
+		}
+	}()
+	db.SetMaxOpenConns(maxOpenDBConns)
+	db.SetMaxIdleConns(maxOpenDBConns)
 
 	// Test the connection
 	if err = db.Ping(); err != nil {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	var journalMode string
+	var busyTimeout int
+	if err = db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil {
+		return fmt.Errorf("failed to read journal_mode: %w", err)
+	}
+	if err = db.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout); err != nil {
+		return fmt.Errorf("failed to read busy_timeout: %w", err)
+	}
+	log.Printf("Database pragmas: journal_mode=%s, busy_timeout=%dms, max_open_conns=%d", journalMode, busyTimeout, maxOpenDBConns)
+
 	// Create the email_processing_records table if it doesn't exist
 	createTableSQL := `
 	CREATE TABLE IF NOT EXISTS email_processing_records (
@@ -47,300 +158,1452 @@ func initDatabase() error {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
 
-	log.Println("Database initialized successfully")
-	return nil
-}
+	// Add columns introduced after the original schema
+	if err = ensureColumn(db, "email_processing_records", "retry_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// Rows written before status tracking only ever recorded successes
+	if err = ensureColumn(db, "email_processing_records", "status", "TEXT NOT NULL DEFAULT 'success'"); err != nil {
+		return err
+	}
+	if err = ensureColumn(db, "email_processing_records", "status_code", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = ensureColumn(db, "email_processing_records", "details", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
 
-// closeDatabase closes the database connection
-func closeDatabase() error {
-	if db != nil {
-		return db.Close()
+	// Timestamps used to be stored in local time; convert them before the dedup index is built
+	if err = migrateTimestampsToUTC(db); err != nil {
+		return err
+	}
+	if err = migrateRegionMoveDetails(db); err != nil {
+		return err
 	}
-	return nil
-}
 
-// insertEmailProcessingRecord inserts a new email processing record into the database
-func insertEmailProcessingRecord(email, action string) error {
-	if db == nil {
-		return fmt.Errorf("database not initialized")
+	// Optionally enforce at most one record per email, action and day
+	if err = configureDailyActionDedup(db, os.Getenv("DEDUPE_DAILY_ACTIONS") == "true"); err != nil {
+		return err
 	}
 
-	// Get current time in Sydney timezone
-	sydneyLocation, err := time.LoadLocation("Australia/Sydney")
+	// Index the columns the results page and CSV export filter and sort on
+	createIndexesSQL := `
+	CREATE INDEX IF NOT EXISTS idx_records_action_timestamp ON email_processing_records (action, timestamp);
+	CREATE INDEX IF NOT EXISTS idx_records_timestamp ON email_processing_records (timestamp);
+	CREATE INDEX IF NOT EXISTS idx_records_email_timestamp ON email_processing_records (email, timestamp);`
+
+	if _, err = db.Exec(createIndexesSQL); err != nil {
+		return fmt.Errorf("failed to create email_processing_records indexes: %w", err)
+	}
+
+	// Create the pending_actions table for deferred actions (unsubscribe grace period, failed action retries)
+	createPendingTableSQL := `
+	CREATE TABLE IF NOT EXISTS pending_actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token TEXT NOT NULL UNIQUE,
+		email TEXT NOT NULL,
+		action TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		execute_at INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'PENDING'
+	);`
+
+	_, err = db.Exec(createPendingTableSQL)
 	if err != nil {
-		log.Printf("WARNING: Failed to load Sydney timezone, using UTC: %v", err)
-		sydneyLocation = time.UTC
+		return fmt.Errorf("failed to create pending_actions table: %w", err)
+	}
+
+	// Retried actions (see queueActionRetry) also carry their attempt count, region move and last failure
+	if err = ensureColumn(db, "pending_actions", "attempts", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = ensureColumn(db, "pending_actions", "details", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err = ensureColumn(db, "pending_actions", "last_error", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
 	}
 
-	timestamp := time.Now().In(sydneyLocation)
+	// Create the idempotency_keys table caching responses to POST requests sent with an Idempotency-Key
+	createIdempotencyTableSQL := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key TEXT PRIMARY KEY,
+		request_hash TEXT NOT NULL,
+		status_code INTEGER NOT NULL,
+		content_type TEXT NOT NULL DEFAULT '',
+		body BLOB NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys (created_at);`
 
-	// Map the action to the correct database format
-	var dbAction string
-	switch action {
-	case "pause":
-		dbAction = "PAUSE"
-	case "international":
-		dbAction = "BBAU"
-	case "unsubscribe":
-		dbAction = "UNSUBSCRIBE"
-	case "subscription_update":
-		dbAction = "SUBSCRIPTION_UPDATE"
-	case "unsubscribe_all":
-		dbAction = "UNSUBSCRIBE_ALL"
-	default:
-		return fmt.Errorf("unknown action: %s", action)
+	_, err = db.Exec(createIdempotencyTableSQL)
+	if err != nil {
+		return fmt.Errorf("failed to create idempotency_keys table: %w", err)
 	}
 
-	insertSQL := `
-	INSERT INTO email_processing_records (timestamp, email, action)
-	VALUES (?, ?, ?)`
+	// Create the webhook_events table of Customer.io webhook event IDs already recorded, so a redelivered
+	// or replayed event adds no second record. It is kept when records are cleared.
+	createWebhookEventsTableSQL := `
+	CREATE TABLE IF NOT EXISTS webhook_events (
+		event_id TEXT PRIMARY KEY,
+		received_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_events_received_at ON webhook_events (received_at);`
 
-	_, err = db.Exec(insertSQL, timestamp, email, dbAction)
+	_, err = db.Exec(createWebhookEventsTableSQL)
 	if err != nil {
-		return fmt.Errorf("failed to insert email processing record: %w", err)
+		return fmt.Errorf("failed to create webhook_events table: %w", err)
 	}
 
-	log.Printf("Database: Successfully recorded %s action for email %s at %s", dbAction, email, timestamp.Format("2006-01-02 15:04:05 MST"))
-	return nil
-}
+	// Create the admin_audit table recording who viewed, exported or cleared records.
+	// It is separate from email_processing_records so clearing records keeps the audit trail.
+	createAuditTableSQL := `
+	CREATE TABLE IF NOT EXISTS admin_audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at INTEGER NOT NULL,
+		username TEXT NOT NULL,
+		action TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT ''
+	);`
 
-// getEmailProcessingRecords retrieves all email processing records from the database
-// This function is provided for future use (e.g., for a results page)
-func getEmailProcessingRecords() ([]EmailProcessingRecord, error) {
-	if db == nil {
-		return nil, fmt.Errorf("database not initialized")
+	_, err = db.Exec(createAuditTableSQL)
+	if err != nil {
+		return fmt.Errorf("failed to create admin_audit table: %w", err)
 	}
 
-	query := `
-	SELECT id, timestamp, email, action
-	FROM email_processing_records
-	ORDER BY timestamp DESC`
+	if !dbHandle.CompareAndSwap(nil, db) {
+		return errDatabaseAlreadyInitialized
+	}
+	log.Println("Database initialized successfully")
+	return nil
+}
 
-	rows, err := db.Query(query)
+// ensureColumn adds a column to an existing table if it is not already present
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		return nil, fmt.Errorf("failed to query email processing records: %w", err)
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
 	}
 	defer rows.Close()
 
-	var records []EmailProcessingRecord
 	for rows.Next() {
-		var record EmailProcessingRecord
-		var timestampStr string
-
-		err := rows.Scan(&record.ID, &timestampStr, &record.Email, &record.Action)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan table info for %s: %w", table, err)
 		}
-
-		// Parse the timestamp
-		record.Timestamp, err = time.Parse("2006-01-02 15:04:05.999999999-07:00", timestampStr)
-		if err != nil {
-			// Try alternative format
-			record.Timestamp, err = time.Parse("2006-01-02 15:04:05", timestampStr)
-			if err != nil {
-				log.Printf("WARNING: Failed to parse timestamp %s: %v", timestampStr, err)
-				record.Timestamp = time.Now()
-			}
+		if name == column {
+			return nil
 		}
-
-		records = append(records, record)
 	}
-
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating rows: %w", err)
+		return fmt.Errorf("error iterating table info for %s: %w", table, err)
 	}
 
-	return records, nil
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s to %s: %w", column, table, err)
+	}
+
+	log.Printf("Database: Added column %s to %s", column, table)
+	return nil
 }
 
-// EmailProcessingRecord represents a record in the email_processing_records table
-type EmailProcessingRecord struct {
-	ID        int       `json:"id"`
-	Timestamp time.Time `json:"timestamp"`
-	Email     string    `json:"email"`
-	Action    string    `json:"action"`
+// legacyTimestampLayouts are the local-time formats timestamps were stored in before they moved to UTC
+var legacyTimestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST", // How the driver writes a time.Time
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05", // No offset; read as UTC
 }
 
-// getActionSummary retrieves summary counts for each action type
-func getActionSummary() (map[string]int, error) {
-	if db == nil {
-		return nil, fmt.Errorf("database not initialized")
+// migrateTimestampsToUTC rewrites timestamps stored in a legacy local-time format as UTC.
+// Rows that cannot be parsed are logged and left unchanged.
+func migrateTimestampsToUTC(db *sql.DB) error {
+	// CAST returns the stored text rather than the driver's parsed DATETIME value
+	rows, err := db.Query(`SELECT id, CAST(timestamp AS TEXT) FROM email_processing_records WHERE timestamp NOT LIKE '%Z'`)
+	if err != nil {
+		return fmt.Errorf("failed to query legacy timestamps: %w", err)
 	}
 
-	query := `
-	SELECT action, COUNT(*) as count
-	FROM email_processing_records
-	GROUP BY action`
+	updates := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var stored string
+		if err := rows.Scan(&id, &stored); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan legacy timestamp: %w", err)
+		}
 
-	rows, err := db.Query(query)
+		for _, layout := range legacyTimestampLayouts {
+			if timestamp, err := time.Parse(layout, stored); err == nil {
+				updates[id] = formatRecordTimestamp(timestamp)
+				break
+			}
+		}
+		if _, ok := updates[id]; !ok {
+			log.Printf("WARNING: Could not migrate timestamp %q of record %d to UTC", stored, id)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating legacy timestamps: %w", err)
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	err = withTxOn(db, func(tx *sql.Tx) error {
+		// A converted row can land on a different UTC day and collide in the daily dedup index;
+		// configureDailyActionDedup rebuilds it afterwards
+		if _, err := tx.Exec(`DROP INDEX IF EXISTS idx_email_action_day_success`); err != nil {
+			return fmt.Errorf("failed to drop daily dedup index: %w", err)
+		}
+		for id, timestamp := range updates {
+			if _, err := tx.Exec(`UPDATE email_processing_records SET timestamp = ? WHERE id = ?`, timestamp, id); err != nil {
+				return fmt.Errorf("failed to migrate timestamp of record %d: %w", id, err)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to query action summary: %w", err)
+		return err
 	}
-	defer rows.Close()
 
-	summary := make(map[string]int)
-	for rows.Next() {
-		var action string
-		var count int
+	log.Printf("Database: Migrated %d record timestamps to UTC", len(updates))
+	return nil
+}
 
-		err := rows.Scan(&action, &count)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan summary row: %w", err)
+// migrateRegionMoveDetails rewrites region move details stored as "FROM->TO" as JSON, and fills in
+// the BBUS->BBAU move on international (BBAU) records written before details were recorded
+func migrateRegionMoveDetails(db *sql.DB) error {
+	result, err := db.Exec(`
+	UPDATE email_processing_records
+	SET details = CASE
+		WHEN details = '' THEN json_object('from', 'BBUS', 'to', 'BBAU')
+		ELSE json_object('from', substr(details, 1, instr(details, '->') - 1), 'to', substr(details, instr(details, '->') + 2))
+	END
+	WHERE (action = 'BBAU' AND details = '') OR (action IN ('BBAU', 'REGION_MOVE') AND instr(details, '->') > 0)`)
+	if err != nil {
+		return fmt.Errorf("failed to migrate region move details: %w", err)
+	}
+
+	if migrated, _ := result.RowsAffected(); migrated > 0 {
+		log.Printf("Database: Migrated %d region move details to JSON", migrated)
+	}
+	return nil
+}
+
+// configureDailyActionDedup creates or drops the unique index that rejects duplicate successful
+// email/action records on the same UTC day (the date prefix of the stored timestamp).
+// Failed attempts are not deduplicated so a later retry can still be recorded.
+func configureDailyActionDedup(db *sql.DB, enabled bool) error {
+	// idx_email_action_day predates status tracking and also covered failed attempts
+	if _, err := db.Exec(`DROP INDEX IF EXISTS idx_email_action_day`); err != nil {
+		return fmt.Errorf("failed to drop legacy daily dedup index: %w", err)
+	}
+
+	if !enabled {
+		if _, err := db.Exec(`DROP INDEX IF EXISTS idx_email_action_day_success`); err != nil {
+			return fmt.Errorf("failed to drop daily dedup index: %w", err)
 		}
+		return nil
+	}
 
-		summary[action] = count
+	createIndexSQL := `
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_email_action_day_success
+	ON email_processing_records (email, action, substr(timestamp, 1, 10))
+	WHERE status = 'success'`
+
+	if _, err := db.Exec(createIndexSQL); err != nil {
+		// Existing duplicate rows prevent the index from being built
+		log.Printf("WARNING: Could not enable daily action dedup (remove existing duplicates first): %v", err)
+		return nil
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating summary rows: %w", err)
+	log.Println("Database: Daily duplicate action dedup enabled")
+	return nil
+}
+
+// closeDatabase closes the database connection
+func closeDatabase() error {
+	if db := dbHandle.Swap(nil); db != nil {
+		return db.Close()
 	}
+	return nil
+}
 
-	return summary, nil
+// insertEmailProcessingRecord inserts a new successful email processing record into the database,
+// with optional structured details stored as JSON (nil for none). It reports false without error
+// when DEDUPE_DAILY_ACTIONS skipped the record as a repeat of today's action.
+func insertEmailProcessingRecord(email, action string, details map[string]interface{}) (bool, error) {
+	return insertEmailProcessingRecordWithResult(email, action, recordDetails(details), TrackResult{}, nil)
 }
 
-// getAllRecordsForDisplay retrieves all records formatted for display with Sydney timezone
-func getAllRecordsForDisplay() ([]DisplayRecord, error) {
-	if db == nil {
-		return nil, fmt.Errorf("database not initialized")
+// recordDetails encodes structured context for the details column as a JSON object, or "" when there is none
+func recordDetails(details map[string]interface{}) string {
+	if len(details) == 0 {
+		return ""
 	}
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		log.Printf("WARNING: Failed to encode record details: %v", err)
+		return ""
+	}
+	return string(encoded)
+}
 
-	query := `
-	SELECT timestamp, email, action
-	FROM email_processing_records
-	ORDER BY timestamp DESC`
+// insertEmailProcessingRecordWithResult inserts a new email processing record with the outcome of
+// its Customer.io call: success or failure (actionErr), the final HTTP status and the retries needed.
+// details holds action-specific context such as the regions of a region move (may be empty).
+// Like insertEmailProcessingRecord, it reports whether the record was inserted or skipped as a duplicate.
+func insertEmailProcessingRecordWithResult(email, action, details string, result TrackResult, actionErr error) (bool, error) {
+	inserted, err := insertEmailProcessingRecords([]recordInsert{{Email: email, Action: action, Details: details, Result: result, Err: actionErr}})
+	return inserted == 1, err
+}
 
-	rows, err := db.Query(query)
+// recordInsert is an email processing record waiting to be written by insertEmailProcessingRecords
+type recordInsert struct {
+	Email   string
+	Action  string
+	Details string
+	Result  TrackResult
+	Err     error  // Error from the Customer.io call; nil when it succeeded
+	EventID string // Customer.io webhook event ID; a record whose event ID was seen before is skipped
+}
+
+// webhookEventRetention is how long webhook event IDs are remembered for deduplication
+const webhookEventRetention = 30 * 24 * time.Hour
+
+// withTx runs fn inside a transaction, committing if it returns nil and rolling back otherwise
+// (including when fn panics), so multi-statement writes are never left half-applied.
+func withTx(fn func(*sql.Tx) error) error {
+	db, err := database()
 	if err != nil {
-		return nil, fmt.Errorf("failed to query records for display: %w", err)
+		return err
 	}
-	defer rows.Close()
+	return withTxOn(db, fn)
+}
 
-	sydneyLocation, err := time.LoadLocation("Australia/Sydney")
+// withTxOn is withTx on a given pool, for migrations that run before initDatabase publishes it
+func withTxOn(db *sql.DB, fn func(*sql.Tx) error) (err error) {
+	tx, err := db.Begin()
 	if err != nil {
-		log.Printf("WARNING: Failed to load Sydney timezone, using UTC: %v", err)
-		sydneyLocation = time.UTC
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("WARNING: Failed to roll back transaction: %v", rbErr)
+			}
+		}
+	}()
 
-	var records []DisplayRecord
-	for rows.Next() {
-		var record DisplayRecord
-		var timestampStr string
+	if err = fn(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
 
-		err := rows.Scan(&timestampStr, &record.Email, &record.Action)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan display row: %w", err)
+// insertEmailProcessingRecords inserts several records in a single transaction: either all of them
+// are recorded or, on error, none are. Live dashboard clients are only notified after the commit.
+// It returns how many rows were inserted, which is fewer than len(records) when DEDUPE_DAILY_ACTIONS
+// skipped successful repeats of an action already recorded for the email today, or when a record's
+// webhook EventID was already recorded.
+func insertEmailProcessingRecords(records []recordInsert) (int, error) {
+	timestamp := time.Now()
+	formattedDate := timestamp.In(displayLocation).Format(displayTimeFormat)
+
+	// Map the actions to the correct database format before touching the database
+	var err error
+	dbActions := make([]string, len(records))
+	for i, record := range records {
+		if dbActions[i], err = dbActionName(record.Action); err != nil {
+			return 0, err
 		}
+	}
 
-		// Parse the timestamp
-		timestamp, err := time.Parse("2006-01-02 15:04:05.999999999-07:00", timestampStr)
-		if err != nil {
-			// Try alternative format
-			timestamp, err = time.Parse("2006-01-02 15:04:05", timestampStr)
+	insertSQL := `
+	INSERT INTO email_processing_records (timestamp, email, action, retry_count, status, status_code, details)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT DO NOTHING`
+
+	var events []RecordEvent
+	err = withTx(func(tx *sql.Tx) error {
+		for i, record := range records {
+			if record.EventID != "" {
+				recorded, err := claimWebhookEvent(tx, record.EventID, timestamp)
+				if err != nil {
+					return err
+				}
+				if !recorded {
+					log.Printf("Database: Webhook event %s already recorded, skipping insert", record.EventID)
+					continue
+				}
+			}
+
+			status := recordStatusSuccess
+			if record.Err != nil {
+				status = recordStatusFailed
+			} else if record.Result.DryRun {
+				status = recordStatusDryRun
+			}
+
+			insertResult, err := tx.Exec(insertSQL, formatRecordTimestamp(timestamp), record.Email, dbActions[i], record.Result.Retries, status, record.Result.StatusCode, record.Details)
 			if err != nil {
-				log.Printf("WARNING: Failed to parse timestamp %s: %v", timestampStr, err)
-				timestamp = time.Now()
+				return fmt.Errorf("failed to insert email processing record: %w", err)
 			}
-		}
 
-		// Convert to Sydney timezone and format for display
-		sydneyTime := timestamp.In(sydneyLocation)
-		record.FormattedDate = sydneyTime.Format("2006-01-02 15:04:05 MST")
+			// With DEDUPE_DAILY_ACTIONS enabled, a repeat of today's action is an idempotent no-op
+			if rowsAffected, err := insertResult.RowsAffected(); err == nil && rowsAffected == 0 {
+				log.Printf("Database: Duplicate %s action for email %s today, skipping insert", dbActions[i], logEmail(record.Email))
+				continue
+			}
 
-		records = append(records, record)
+			recordID, err := insertResult.LastInsertId()
+			if err != nil {
+				log.Printf("WARNING: Could not get inserted record ID: %v", err)
+			}
+			events = append(events, RecordEvent{
+				ID:            recordID,
+				FormattedDate: formattedDate,
+				Email:         record.Email,
+				Action:        dbActions[i],
+				Status:        status,
+				StatusCode:    record.Result.StatusCode,
+				Details:       record.Details,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		dbInsertFailuresTotal.Add(float64(len(records)))
+		return 0, err
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating display rows: %w", err)
+	for _, event := range events {
+		actionsTotal.WithLabelValues(event.Action, event.Status).Inc()
+		log.Printf("Database: Successfully recorded %s %s action for email %s at %s", event.Status, event.Action, logEmail(event.Email), event.FormattedDate)
+
+		// Push the new record to any connected live dashboard clients
+		broadcaster.publish(event)
 	}
 
-	return records, nil
+	return len(events), nil
 }
 
-// DisplayRecord represents a record formatted for display
-type DisplayRecord struct {
-	FormattedDate string `json:"formatted_date"`
-	Email         string `json:"email"`
-	Action        string `json:"action"`
+// claimWebhookEvent stores a webhook event ID, reporting false if it was already stored. Event IDs older
+// than webhookEventRetention are pruned first; webhooks that old are rejected by their timestamp anyway.
+func claimWebhookEvent(tx *sql.Tx, eventID string, now time.Time) (bool, error) {
+	if _, err := tx.Exec(`DELETE FROM webhook_events WHERE received_at < ?`, now.Add(-webhookEventRetention).Unix()); err != nil {
+		return false, fmt.Errorf("failed to prune webhook events: %w", err)
+	}
+	result, err := tx.Exec(`INSERT INTO webhook_events (event_id, received_at) VALUES (?, ?) ON CONFLICT DO NOTHING`, eventID, now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to store webhook event: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to store webhook event: %w", err)
+	}
+	return rowsAffected == 1, nil
 }
 
-// clearAllRecords deletes all records from the email_processing_records table
-func clearAllRecords() error {
-	if db == nil {
-		return fmt.Errorf("database not initialized")
+// dbActionName maps a request action to the action name stored in the database
+func dbActionName(action string) (string, error) {
+	definition, ok := findAction(action)
+	if !ok || definition.dbAction == "" {
+		return "", fmt.Errorf("unknown action: %s", action)
 	}
+	return definition.dbAction, nil
+}
 
-	deleteSQL := `DELETE FROM email_processing_records`
+// recentlyProcessed reports whether the email's most recent successful action is this same action and
+// happened within the given window. A different action in between (e.g. resubscribe after unsubscribe)
+// means a repeat is a real request. An action with no database name always reports false.
+func recentlyProcessed(email, action string, within time.Duration) (bool, error) {
+	db, err := database()
+	if err != nil {
+		return false, err
+	}
 
-	result, err := db.Exec(deleteSQL)
+	dbAction, err := dbActionName(action)
 	if err != nil {
-		return fmt.Errorf("failed to clear records: %w", err)
+		return false, nil
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	query := `
+	SELECT action, timestamp
+	FROM email_processing_records
+	WHERE email = ? AND status = 'success'
+	ORDER BY id DESC
+	LIMIT 1`
+
+	var lastAction string
+	var lastProcessed time.Time
+	err = db.QueryRow(query, email).Scan(&lastAction, &lastProcessed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
-		log.Printf("WARNING: Could not get rows affected count: %v", err)
-	} else {
-		log.Printf("Successfully cleared %d records from database", rowsAffected)
+		return false, fmt.Errorf("failed to query recent actions: %w", err)
 	}
 
-	return nil
+	return lastAction == dbAction && time.Since(lastProcessed) < within, nil
 }
 
-// getRecordsByAction retrieves records filtered by action type for CSV export
-func getRecordsByAction(action string) ([]DisplayRecord, error) {
-	if db == nil {
-		return nil, fmt.Errorf("database not initialized")
+// getEmailProcessingRecords retrieves all email processing records from the database
+// This function is provided for future use (e.g., for a results page)
+func getEmailProcessingRecords() ([]EmailProcessingRecord, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
 	}
 
 	query := `
-	SELECT timestamp, email, action
+	SELECT id, timestamp, email, action, retry_count, status, status_code, details
 	FROM email_processing_records
-	WHERE action = ?
 	ORDER BY timestamp DESC`
 
-	rows, err := db.Query(query, action)
+	rows, err := db.Query(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query records by action: %w", err)
+		return nil, fmt.Errorf("failed to query email processing records: %w", err)
 	}
 	defer rows.Close()
 
-	sydneyLocation, err := time.LoadLocation("Australia/Sydney")
-	if err != nil {
-		log.Printf("WARNING: Failed to load Sydney timezone, using UTC: %v", err)
-		sydneyLocation = time.UTC
-	}
-
-	var records []DisplayRecord
+	var records []EmailProcessingRecord
 	for rows.Next() {
-		var record DisplayRecord
+		var record EmailProcessingRecord
 		var timestampStr string
 
-		err := rows.Scan(&timestampStr, &record.Email, &record.Action)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan record row: %w", err)
-		}
-
-		// Parse the timestamp
-		timestamp, err := time.Parse("2006-01-02 15:04:05.999999999-07:00", timestampStr)
+		err := rows.Scan(&record.ID, &timestampStr, &record.Email, &record.Action, &record.RetryCount, &record.Status, &record.StatusCode, &record.Details)
 		if err != nil {
-			// Try alternative format
-			timestamp, err = time.Parse("2006-01-02 15:04:05", timestampStr)
-			if err != nil {
-				log.Printf("WARNING: Failed to parse timestamp %s: %v", timestampStr, err)
-				timestamp = time.Now()
-			}
+			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 
-		// Convert to Sydney timezone and format for display
-		sydneyTime := timestamp.In(sydneyLocation)
-		record.FormattedDate = sydneyTime.Format("2006-01-02 15:04:05 MST")
+		record.Timestamp = parseRecordTimestamp(timestampStr)
 
 		records = append(records, record)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating record rows: %w", err)
+		return nil, fmt.Errorf("error iterating rows: %w", err)
 	}
 
 	return records, nil
 }
+
+// recordTimestampLayout is how record timestamps are stored: fixed-width ISO 8601 in UTC, so text
+// comparisons and ORDER BY follow chronological order
+const recordTimestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// formatRecordTimestamp formats a time for storage in email_processing_records.timestamp
+func formatRecordTimestamp(t time.Time) string {
+	return t.UTC().Format(recordTimestampLayout)
+}
+
+// parseRecordTimestamp parses a scanned record timestamp (the driver returns DATETIME columns as
+// RFC 3339), falling back to the current time so one malformed row doesn't break the results page or an export
+func parseRecordTimestamp(timestampStr string) time.Time {
+	timestamp, err := time.Parse(time.RFC3339Nano, timestampStr)
+	if err != nil {
+		log.Printf("WARNING: Failed to parse timestamp %s: %v", timestampStr, err)
+		return time.Now()
+	}
+	return timestamp
+}
+
+// scanDisplayRecord scans a "timestamp, email, action, status, status_code, details" row into a
+// DisplayRecord with its time formatted in the display timezone
+func scanDisplayRecord(rows *sql.Rows) (DisplayRecord, error) {
+	var record DisplayRecord
+	var timestampStr string
+	if err := rows.Scan(&timestampStr, &record.Email, &record.Action, &record.Status, &record.StatusCode, &record.Details); err != nil {
+		return DisplayRecord{}, err
+	}
+	record.FormattedDate = parseRecordTimestamp(timestampStr).In(displayLocation).Format(displayTimeFormat)
+	return record, nil
+}
+
+// EmailProcessingRecord represents a record in the email_processing_records table
+type EmailProcessingRecord struct {
+	ID         int       `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Email      string    `json:"email"`
+	Action     string    `json:"action"`
+	RetryCount int       `json:"retry_count"`
+	Status     string    `json:"status"`
+	StatusCode int       `json:"status_code"`
+	Details    string    `json:"details"`
+}
+
+// DateRange is an inclusive range of display-timezone dates (YYYY-MM-DD); empty bounds are open-ended
+type DateRange struct {
+	From string
+	To   string
+}
+
+// bounds returns the stored UTC timestamps the range covers, for "timestamp >= from AND timestamp < to":
+// from the start of From to the start of the day after To in the display timezone. Open-ended
+// bounds sort before or after every stored timestamp.
+func (r DateRange) bounds() (string, string) {
+	from, to := "0000-01-01", "9999-12-31"
+	if start, err := time.ParseInLocation("2006-01-02", r.From, displayLocation); err == nil {
+		from = formatRecordTimestamp(start)
+	}
+	if end, err := time.ParseInLocation("2006-01-02", r.To, displayLocation); err == nil {
+		to = formatRecordTimestamp(end.AddDate(0, 0, 1))
+	}
+	return from, to
+}
+
+// ActionCounts is the number of recorded Customer.io calls for one action, split by outcome
+type ActionCounts struct {
+	Success int `json:"success"`
+	Failed  int `json:"failed"`
+}
+
+// Total returns the number of successful and failed calls
+func (a ActionCounts) Total() int {
+	return a.Success + a.Failed
+}
+
+// ErrorRate returns the share of calls that failed, as a percentage (0 when there were none)
+func (a ActionCounts) ErrorRate() float64 {
+	if a.Total() == 0 {
+		return 0
+	}
+	return float64(a.Failed) / float64(a.Total()) * 100
+}
+
+// ErrorRateLabel formats ErrorRate for display, e.g. "2.4%"
+func (a ActionCounts) ErrorRateLabel() string {
+	return fmt.Sprintf("%.1f%%", a.ErrorRate())
+}
+
+// getActionSummary retrieves successful and failed counts for each action type within a date range
+func getActionSummary(dateRange DateRange) (map[string]ActionCounts, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT action,
+		SUM(CASE WHEN status = 'success' THEN 1 ELSE 0 END) as succeeded,
+		SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) as failed
+	FROM email_processing_records
+	WHERE timestamp >= ? AND timestamp < ?
+	GROUP BY action`
+
+	from, to := dateRange.bounds()
+	rows, err := db.Query(query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query action summary: %w", err)
+	}
+	defer rows.Close()
+
+	summary := make(map[string]ActionCounts)
+	for rows.Next() {
+		var action string
+		var counts ActionCounts
+
+		err := rows.Scan(&action, &counts.Success, &counts.Failed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan summary row: %w", err)
+		}
+
+		summary[action] = counts
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating summary rows: %w", err)
+	}
+
+	return summary, nil
+}
+
+// display_date(timestamp) returns a stored record timestamp's calendar date (YYYY-MM-DD) in the display
+// timezone, so queries can group by local day; SQLite's own date() only knows UTC and the server's zone.
+// It is registered once, before any connection is opened.
+func init() {
+	sqlite.MustRegisterScalarFunction("display_date", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		stored, ok := args[0].(string)
+		if !ok {
+			return nil, nil
+		}
+		timestamp, err := time.Parse(time.RFC3339Nano, stored)
+		if err != nil {
+			return nil, nil
+		}
+		return timestamp.In(displayLocation).Format("2006-01-02"), nil
+	})
+}
+
+// timeSeriesBuckets maps each time series interval to the SQL expression giving a record's bucket date
+var timeSeriesBuckets = map[string]string{
+	"day":   `display_date(timestamp)`,
+	"week":  `date(display_date(timestamp), '-6 days', 'weekday 1')`, // The Monday starting the week
+	"month": `strftime('%Y-%m-01', display_date(timestamp))`,
+}
+
+// TimeSeriesPoint is the number of successful records in one interval, keyed by the interval's first day
+type TimeSeriesPoint struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// getActionTimeSeries counts successful records per day, week (from Monday) or month in the display
+// timezone, oldest first, for one database action or every action when it is empty. Intervals without
+// records are filled with zero, across the whole date range when it is bounded.
+func getActionTimeSeries(action, interval string, dateRange DateRange) ([]TimeSeriesPoint, error) {
+	bucket, ok := timeSeriesBuckets[interval]
+	if !ok {
+		return nil, fmt.Errorf("unknown interval %q", interval)
+	}
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+	SELECT %s AS bucket, COUNT(*)
+	FROM email_processing_records
+	WHERE status = 'success' AND timestamp >= ? AND timestamp < ? AND (? = '' OR action = ?)
+	GROUP BY bucket
+	HAVING bucket IS NOT NULL
+	ORDER BY bucket`, bucket)
+
+	from, to := dateRange.bounds()
+	rows, err := db.Query(query, from, to, action, action)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query time series: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	var first, last string
+	for rows.Next() {
+		var date string
+		var count int
+		if err := rows.Scan(&date, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan time series row: %w", err)
+		}
+		counts[date] = count
+		if first == "" {
+			first = date
+		}
+		last = date
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating time series rows: %w", err)
+	}
+
+	if dateRange.From != "" {
+		first = dateRange.From
+	}
+	if dateRange.To != "" {
+		last = dateRange.To
+	}
+	if first == "" || last == "" {
+		return []TimeSeriesPoint{}, nil
+	}
+	start, errStart := time.Parse("2006-01-02", first)
+	end, errEnd := time.Parse("2006-01-02", last)
+	if errStart != nil || errEnd != nil {
+		return nil, fmt.Errorf("invalid time series bounds %s to %s", first, last)
+	}
+
+	points := []TimeSeriesPoint{}
+	for day := timeSeriesBucketStart(start, interval); !day.After(end); day = nextTimeSeriesBucket(day, interval) {
+		date := day.Format("2006-01-02")
+		points = append(points, TimeSeriesPoint{Date: date, Count: counts[date]})
+	}
+	return points, nil
+}
+
+// timeSeriesBucketStart returns the first day of the interval containing day, matching timeSeriesBuckets
+func timeSeriesBucketStart(day time.Time, interval string) time.Time {
+	switch interval {
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+// nextTimeSeriesBucket returns the first day of the interval after the one starting on day
+func nextTimeSeriesBucket(day time.Time, interval string) time.Time {
+	switch interval {
+	case "week":
+		return day.AddDate(0, 0, 7)
+	case "month":
+		return day.AddDate(0, 1, 0)
+	}
+	return day.AddDate(0, 0, 1)
+}
+
+// getAllRecordsForDisplay retrieves all records formatted for display in the display timezone
+func getAllRecordsForDisplay() ([]DisplayRecord, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT timestamp, email, action, status, status_code, details
+	FROM email_processing_records
+	ORDER BY timestamp DESC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query records for display: %w", err)
+	}
+	defer rows.Close()
+
+	var records []DisplayRecord
+	for rows.Next() {
+		record, err := scanDisplayRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan display row: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating display rows: %w", err)
+	}
+
+	return records, nil
+}
+
+// getRecordsFingerprint cheaply summarizes the records table (row count, newest ID and latest timestamp)
+// so the results page can tell whether anything has changed since a client last loaded it
+func getRecordsFingerprint() (string, error) {
+	db, err := database()
+	if err != nil {
+		return "", err
+	}
+
+	var count, maxID int64
+	var latest string
+	query := `SELECT COUNT(*), COALESCE(MAX(id), 0), COALESCE(MAX(timestamp), '') FROM email_processing_records`
+	if err := db.QueryRow(query).Scan(&count, &maxID, &latest); err != nil {
+		return "", fmt.Errorf("failed to fingerprint records: %w", err)
+	}
+	return fmt.Sprintf("%d-%d-%s", count, maxID, latest), nil
+}
+
+// getRecordsPaginated retrieves one page of records within a date range formatted for display,
+// newest first, along with the total number of matching records
+func getRecordsPaginated(limit, offset int, dateRange DateRange, emailSearch string, exactEmail bool) ([]DisplayRecord, int, error) {
+	db, err := database()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	from, to := dateRange.bounds()
+	where := `timestamp >= ? AND timestamp < ?`
+	args := []interface{}{from, to}
+
+	// Partial, case-insensitive email match (SQLite LIKE ignores ASCII case), or the whole
+	// address when exactEmail is set
+	if emailSearch != "" && exactEmail {
+		where += ` AND email = ?`
+		args = append(args, emailSearch)
+	} else if emailSearch != "" {
+		where += ` AND email LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(emailSearch)+"%")
+	}
+
+	var total int
+	countSQL := `SELECT COUNT(*) FROM email_processing_records WHERE ` + where
+	if err := db.QueryRow(countSQL, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count records: %w", err)
+	}
+
+	query := `
+	SELECT timestamp, email, action, status, status_code, details
+	FROM email_processing_records
+	WHERE ` + where + `
+	ORDER BY timestamp DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query paginated records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []DisplayRecord
+	for rows.Next() {
+		record, err := scanDisplayRecord(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan paginated row: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating paginated rows: %w", err)
+	}
+
+	return records, total, nil
+}
+
+// displayTimeFormat is how record and audit times are shown, in displayLocation
+const displayTimeFormat = "2006-01-02 15:04:05 MST"
+
+// DisplayRecord represents a record formatted for display
+type DisplayRecord struct {
+	FormattedDate string `json:"formatted_date"`
+	Email         string `json:"email"`
+	Action        string `json:"action"`
+	Status        string `json:"status"`
+	StatusCode    int    `json:"status_code"`
+	Details       string `json:"details"` // JSON object (see recordDetails) or, for older records, plain text
+}
+
+// DetailsLabel returns the details as shown on the results pages: region moves read "BBUS → BBAU",
+// other JSON details "key: value" (or "key: from → to") pairs in key order, and plain text as stored
+func (r DisplayRecord) DetailsLabel() string {
+	if from, to, ok := parseRegionMoveDetails(r.Details); ok {
+		return from + " → " + to
+	}
+
+	var details map[string]interface{}
+	if err := json.Unmarshal([]byte(r.Details), &details); err != nil {
+		return r.Details
+	}
+	pairs := make([]string, 0, len(details))
+	for _, key := range slices.Sorted(maps.Keys(details)) {
+		// Changes recorded as {"from": ..., "to": ...} read "from → to"
+		if change, ok := details[key].(map[string]interface{}); ok && change["from"] != nil && change["to"] != nil {
+			pairs = append(pairs, fmt.Sprintf("%s: %v → %v", key, change["from"], change["to"]))
+			continue
+		}
+		pairs = append(pairs, fmt.Sprintf("%s: %v", key, details[key]))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// clearAllRecords deletes all records from the email_processing_records table
+func clearAllRecords() error {
+	_, err := clearRecordsBefore("")
+	return err
+}
+
+// clearRecordsBefore deletes the records from before a display-timezone date (YYYY-MM-DD), or every
+// record when before is empty, and returns how many were deleted
+func clearRecordsBefore(before string) (int64, error) {
+	return deleteRecords("", before)
+}
+
+// deleteRecords deletes the records matching a database action name and/or falling before a
+// display-timezone date (YYYY-MM-DD) and returns how many were deleted. An empty filter matches
+// everything, so with neither set every record is deleted.
+func deleteRecords(action, before string) (int64, error) {
+	var where []string
+	var args []interface{}
+	if action != "" {
+		if !isRecordedAction(action) {
+			return 0, fmt.Errorf("unknown action %q", action)
+		}
+		where = append(where, "action = ?")
+		args = append(args, action)
+	}
+	if before != "" {
+		if _, err := time.Parse("2006-01-02", before); err != nil {
+			return 0, fmt.Errorf("invalid cutoff date %q, expected YYYY-MM-DD", before)
+		}
+		cutoff, _ := DateRange{From: before}.bounds()
+		where = append(where, "timestamp < ?")
+		args = append(args, cutoff)
+	}
+
+	deleteSQL := `DELETE FROM email_processing_records`
+	if len(where) > 0 {
+		deleteSQL += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var result sql.Result
+	err := withTx(func(tx *sql.Tx) error {
+		var err error
+		if result, err = tx.Exec(deleteSQL, args...); err != nil {
+			return fmt.Errorf("failed to delete records: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("WARNING: Could not get rows affected count: %v", err)
+		return 0, nil
+	}
+	log.Printf("Successfully deleted %d records from database (action=%q, before=%q)", rowsAffected, action, before)
+	return rowsAffected, nil
+}
+
+// escapeLike escapes the LIKE wildcards in user input so they match literally (used with ESCAPE '\')
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// recordsByActionQuery builds the forEachRecordByAction query. The action filter is only added when
+// set, so SQLite can use idx_records_action_timestamp instead of scanning the table.
+func recordsByActionQuery(action string, dateRange DateRange) (string, []interface{}) {
+	from, to := dateRange.bounds()
+	where := "timestamp >= ? AND timestamp < ?"
+	args := []interface{}{from, to}
+	if action != "" {
+		where = "action = ? AND " + where
+		args = append([]interface{}{action}, args...)
+	}
+
+	query := `
+	SELECT timestamp, email, action, status, status_code, details
+	FROM email_processing_records
+	WHERE ` + where + `
+	ORDER BY timestamp DESC`
+	return query, args
+}
+
+// getRecordsByAction retrieves records filtered by action type and date range, newest first.
+// An empty action returns records of every action type.
+func getRecordsByAction(action string, dateRange DateRange) ([]DisplayRecord, error) {
+	var records []DisplayRecord
+	err := forEachRecordByAction(action, dateRange, func(record DisplayRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// forEachRecordByAction calls fn for each record matching getRecordsByAction's filters, reading rows
+// one at a time so the CSV download can stream arbitrarily large exports. An error from fn stops the iteration.
+func forEachRecordByAction(action string, dateRange DateRange, fn func(DisplayRecord) error) error {
+	db, err := database()
+	if err != nil {
+		return err
+	}
+
+	query, args := recordsByActionQuery(action, dateRange)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query records by action: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanDisplayRecord(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan record row: %w", err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating record rows: %w", err)
+	}
+
+	return nil
+}
+
+// PendingAction represents a deferred action waiting for its grace period to expire or for its next retry
+type PendingAction struct {
+	ID        int       `json:"id"`
+	Token     string    `json:"token"`
+	Email     string    `json:"email"`
+	Action    string    `json:"action"`
+	Details   string    `json:"details"`    // Action-specific details, e.g. regionMoveDetails for region moves
+	Attempts  int       `json:"attempts"`   // Failed attempts so far; 0 for actions that have not run yet
+	LastError string    `json:"last_error"` // Why the last attempt failed
+	ExecuteAt time.Time `json:"execute_at"`
+}
+
+// getRecordsByEmail returns every record for one customer, oldest first, for their action timeline
+func getRecordsByEmail(email string) ([]DisplayRecord, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT timestamp, email, action, status, status_code, details
+	FROM email_processing_records
+	WHERE email = ?
+	ORDER BY timestamp ASC, id ASC`
+
+	rows, err := db.Query(query, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query records by email: %w", err)
+	}
+	defer rows.Close()
+
+	var records []DisplayRecord
+	for rows.Next() {
+		record, err := scanDisplayRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan record row: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating record rows: %w", err)
+	}
+
+	return records, nil
+}
+
+// insertPendingAction records a deferred action that will run at its ExecuteAt unless cancelled
+func insertPendingAction(action PendingAction) error {
+	db, err := database()
+	if err != nil {
+		return err
+	}
+
+	insertSQL := `
+	INSERT INTO pending_actions (token, email, action, details, attempts, last_error, created_at, execute_at, status)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'PENDING')`
+
+	_, err = db.Exec(insertSQL, action.Token, action.Email, action.Action, action.Details, action.Attempts, action.LastError,
+		time.Now().Unix(), action.ExecuteAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to insert pending action: %w", err)
+	}
+
+	log.Printf("Database: Scheduled pending %s action for email %s at %s", action.Action, logEmail(action.Email), action.ExecuteAt.Format(time.RFC3339))
+	return nil
+}
+
+// cancelPendingAction marks a pending action as cancelled.
+// It returns the email of the cancelled action, or an empty string if no pending action matched the token.
+func cancelPendingAction(token string) (string, error) {
+	db, err := database()
+	if err != nil {
+		return "", err
+	}
+
+	var email string
+	err = db.QueryRow(`SELECT email FROM pending_actions WHERE token = ? AND status = 'PENDING'`, token).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up pending action: %w", err)
+	}
+
+	result, err := db.Exec(`UPDATE pending_actions SET status = 'CANCELLED' WHERE token = ? AND status = 'PENDING'`, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to cancel pending action: %w", err)
+	}
+
+	// The scheduler may have picked the action up between the lookup and the update
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return "", nil
+	}
+
+	return email, nil
+}
+
+// getDuePendingActions retrieves pending actions whose grace period or retry delay has expired
+func getDuePendingActions(now time.Time) ([]PendingAction, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT id, token, email, action, details, attempts, last_error, execute_at
+	FROM pending_actions
+	WHERE status = 'PENDING' AND execute_at <= ?
+	ORDER BY execute_at ASC`
+
+	rows, err := db.Query(query, now.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query due pending actions: %w", err)
+	}
+	defer rows.Close()
+
+	var actions []PendingAction
+	for rows.Next() {
+		var action PendingAction
+		var executeAt int64
+
+		err := rows.Scan(&action.ID, &action.Token, &action.Email, &action.Action, &action.Details, &action.Attempts, &action.LastError, &executeAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pending action row: %w", err)
+		}
+
+		action.ExecuteAt = time.Unix(executeAt, 0)
+		actions = append(actions, action)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating pending action rows: %w", err)
+	}
+
+	return actions, nil
+}
+
+// updatePendingActionStatus moves a pending action from one status to another.
+// It returns false if the action was no longer in the expected status.
+func updatePendingActionStatus(id int, fromStatus, toStatus string) (bool, error) {
+	db, err := database()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.Exec(`UPDATE pending_actions SET status = ? WHERE id = ? AND status = ?`, toStatus, id, fromStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to update pending action status: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// reschedulePendingAction returns a claimed (PROCESSING) action to PENDING after a failed attempt,
+// counting the attempt and recording why it failed
+func reschedulePendingAction(id int, executeAt time.Time, lastError string) error {
+	db, err := database()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	UPDATE pending_actions
+	SET status = 'PENDING', attempts = attempts + 1, last_error = ?, execute_at = ?
+	WHERE id = ? AND status = 'PROCESSING'`, lastError, executeAt.Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule pending action: %w", err)
+	}
+	return nil
+}
+
+// countPendingActions returns how many actions are waiting to run, including retries of failed actions
+func countPendingActions() (int, error) {
+	db, err := database()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pending_actions WHERE status IN ('PENDING', 'PROCESSING')`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count pending actions: %w", err)
+	}
+	return count, nil
+}
+
+// IdempotentResponse is a response cached for an Idempotency-Key, replayed when the key is sent again
+type IdempotentResponse struct {
+	RequestHash string // SHA-256 of the original request body, so a reused key with a different body is detected
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// getIdempotentResponse returns the response stored for key since the given time, or nil if there is none
+func getIdempotentResponse(key string, since time.Time) (*IdempotentResponse, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+
+	var response IdempotentResponse
+	err = db.QueryRow(`
+	SELECT request_hash, status_code, content_type, body
+	FROM idempotency_keys
+	WHERE key = ? AND created_at >= ?`, key, since.Unix()).Scan(&response.RequestHash, &response.StatusCode, &response.ContentType, &response.Body)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up idempotency key: %w", err)
+	}
+	return &response, nil
+}
+
+// saveIdempotentResponse stores the response for key, replacing an expired entry, and prunes keys created
+// before expiredBefore so the table only holds keys within their TTL
+func saveIdempotentResponse(key string, response IdempotentResponse, expiredBefore time.Time) error {
+	return withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, expiredBefore.Unix()); err != nil {
+			return fmt.Errorf("failed to prune idempotency keys: %w", err)
+		}
+		_, err := tx.Exec(`
+		INSERT OR REPLACE INTO idempotency_keys (key, request_hash, status_code, content_type, body, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, key, response.RequestHash, response.StatusCode, response.ContentType, response.Body, time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("failed to store idempotency key: %w", err)
+		}
+		return nil
+	})
+}
+
+// forEachEmailProcessingRecord streams every record in the table to fn in id order without buffering them all
+func forEachEmailProcessingRecord(fn func(EmailProcessingRecord) error) error {
+	db, err := database()
+	if err != nil {
+		return err
+	}
+
+	query := `
+	SELECT id, timestamp, email, action, retry_count, status, status_code, details
+	FROM email_processing_records
+	ORDER BY id ASC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to query records for export: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record EmailProcessingRecord
+		var timestampStr string
+
+		err := rows.Scan(&record.ID, &timestampStr, &record.Email, &record.Action, &record.RetryCount, &record.Status, &record.StatusCode, &record.Details)
+		if err != nil {
+			return fmt.Errorf("failed to scan export row: %w", err)
+		}
+
+		record.Timestamp = parseRecordTimestamp(timestampStr)
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating export rows: %w", err)
+	}
+
+	return nil
+}
+
+// AdminAuditRecord is one admin action from the admin_audit table, formatted for display
+type AdminAuditRecord struct {
+	FormattedDate string `json:"formatted_date"`
+	Username      string `json:"username"`
+	Action        string `json:"action"`
+	IP            string `json:"ip"`
+	Details       string `json:"details"`
+}
+
+// insertAdminAuditRecord records an admin action (view, csv_download, json_export, clear, bulk)
+func insertAdminAuditRecord(username, action, ip, details string) error {
+	db, err := database()
+	if err != nil {
+		return err
+	}
+
+	insertSQL := `
+	INSERT INTO admin_audit (created_at, username, action, ip, details)
+	VALUES (?, ?, ?, ?, ?)`
+
+	if _, err := db.Exec(insertSQL, time.Now().Unix(), username, action, ip, details); err != nil {
+		return fmt.Errorf("failed to insert admin audit record: %w", err)
+	}
+	return nil
+}
+
+// getAdminAuditRecords returns the most recent admin actions, newest first
+func getAdminAuditRecords(limit int) ([]AdminAuditRecord, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT created_at, username, action, ip, details
+	FROM admin_audit
+	ORDER BY created_at DESC, id DESC
+	LIMIT ?`
+
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin audit records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []AdminAuditRecord
+	for rows.Next() {
+		var record AdminAuditRecord
+		var createdAt int64
+		if err := rows.Scan(&createdAt, &record.Username, &record.Action, &record.IP, &record.Details); err != nil {
+			return nil, fmt.Errorf("failed to scan admin audit row: %w", err)
+		}
+		record.FormattedDate = time.Unix(createdAt, 0).In(displayLocation).Format(displayTimeFormat)
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating admin audit rows: %w", err)
+	}
+
+	return records, nil
+}
+
+// RetryStats summarizes how many Customer.io retries recorded actions needed
+type RetryStats struct {
+	TotalActions       int     `json:"total_actions"`
+	ActionsWithRetries int     `json:"actions_with_retries"`
+	RetryPercentage    float64 `json:"retry_percentage"`
+	TotalRetries       int     `json:"total_retries"`
+	MaxRetries         int     `json:"max_retries"`
+}
+
+// getRetryStats retrieves aggregate retry statistics across all recorded actions
+func getRetryStats() (RetryStats, error) {
+	var stats RetryStats
+	db, err := database()
+	if err != nil {
+		return stats, err
+	}
+
+	query := `
+	SELECT COUNT(*),
+		COALESCE(SUM(CASE WHEN retry_count > 0 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(retry_count), 0),
+		COALESCE(MAX(retry_count), 0)
+	FROM email_processing_records`
+
+	err = db.QueryRow(query).Scan(&stats.TotalActions, &stats.ActionsWithRetries, &stats.TotalRetries, &stats.MaxRetries)
+	if err != nil {
+		return stats, fmt.Errorf("failed to query retry stats: %w", err)
+	}
+
+	if stats.TotalActions > 0 {
+		stats.RetryPercentage = float64(stats.ActionsWithRetries) / float64(stats.TotalActions) * 100
+	}
+
+	return stats, nil
+}
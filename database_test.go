@@ -0,0 +1,960 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setupTestDatabase initializes a fresh database in a temporary directory for the duration of a test
+func setupTestDatabase(t *testing.T) {
+	t.Helper()
+	t.Setenv("DATABASE_PATH", filepath.Join(t.TempDir(), "test.db"))
+	t.Setenv("DISPLAY_TIMEZONE", "")
+	configureDisplayTimezone()
+	if err := initDatabase(); err != nil {
+		t.Fatalf("initDatabase: %v", err)
+	}
+	t.Cleanup(func() {
+		closeDatabase()
+	})
+}
+
+// testDB returns the pool opened by setupTestDatabase
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database()
+	if err != nil {
+		t.Fatalf("database: %v", err)
+	}
+	return db
+}
+
+func TestDatabaseInitializedOnce(t *testing.T) {
+	if _, err := countPendingActions(); !errors.Is(err, errDatabaseNotInitialized) {
+		t.Fatalf("countPendingActions before initDatabase: got %v, want errDatabaseNotInitialized", err)
+	}
+	if _, err := insertEmailProcessingRecord("before@example.com", "pause", nil); !errors.Is(err, errDatabaseNotInitialized) {
+		t.Fatalf("insert before initDatabase: got %v, want errDatabaseNotInitialized", err)
+	}
+
+	setupTestDatabase(t)
+	db := testDB(t)
+	if err := initDatabase(); !errors.Is(err, errDatabaseAlreadyInitialized) {
+		t.Fatalf("second initDatabase: got %v, want errDatabaseAlreadyInitialized", err)
+	}
+	if got := testDB(t); got != db {
+		t.Fatal("second initDatabase replaced the connection pool")
+	}
+
+	if err := closeDatabase(); err != nil {
+		t.Fatalf("closeDatabase: %v", err)
+	}
+	if _, err := countPendingActions(); !errors.Is(err, errDatabaseNotInitialized) {
+		t.Fatalf("countPendingActions after closeDatabase: got %v, want errDatabaseNotInitialized", err)
+	}
+}
+
+func TestRecordsByActionQueryUsesActionIndex(t *testing.T) {
+	setupTestDatabase(t)
+
+	query, args := recordsByActionQuery("UNSUBSCRIBE", DateRange{})
+	rows, err := testDB(t).Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatalf("EXPLAIN QUERY PLAN: %v", err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatalf("scan plan row: %v", err)
+		}
+		plan = append(plan, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("iterate plan rows: %v", err)
+	}
+
+	if !strings.Contains(strings.Join(plan, "\n"), "idx_records_action_timestamp") {
+		t.Errorf("action-filtered query does not use idx_records_action_timestamp; plan:\n%s", strings.Join(plan, "\n"))
+	}
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	setupTestDatabase(t)
+
+	if _, err := insertEmailProcessingRecord("kept@example.com", "pause", nil); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	wantErr := errors.New("second statement failed")
+	err := withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM email_processing_records`); err != nil {
+			return err
+		}
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("withTx error = %v, want %v", err, wantErr)
+	}
+
+	var count int
+	if err := testDB(t).QueryRow(`SELECT COUNT(*) FROM email_processing_records`).Scan(&count); err != nil {
+		t.Fatalf("count records: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("record count after rolled back delete = %d, want 1", count)
+	}
+}
+
+func TestConcurrentInsertsWaitForLock(t *testing.T) {
+	setupTestDatabase(t)
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := insertEmailProcessingRecord(fmt.Sprintf("user%d@example.com", i), "pause", nil)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent insert: %v", err)
+		}
+	}
+}
+
+func TestGetRecordsPaginatedEmailSearch(t *testing.T) {
+	setupTestDatabase(t)
+
+	for _, email := range []string{"alice@example.com", "bob@example.com", "a_b@example.com"} {
+		if _, err := insertEmailProcessingRecord(email, "pause", nil); err != nil {
+			t.Fatalf("insert %s: %v", email, err)
+		}
+	}
+
+	tests := []struct {
+		search string
+		exact  bool
+		want   int
+	}{
+		{"", false, 3},
+		{"ALICE", false, 1},
+		{"example.com", false, 3},
+		{"a_b", false, 1}, // '_' matches literally, not any character
+		{"%", false, 0},
+		{"", true, 3},
+		{"alice", true, 0},
+		{"alice@example.com", true, 1},
+	}
+	for _, tt := range tests {
+		records, total, err := getRecordsPaginated(10, 0, DateRange{}, tt.search, tt.exact)
+		if err != nil {
+			t.Fatalf("getRecordsPaginated(%q, %t): %v", tt.search, tt.exact, err)
+		}
+		if total != tt.want || len(records) != tt.want {
+			t.Errorf("getRecordsPaginated(%q, %t) = %d records (total %d), want %d", tt.search, tt.exact, len(records), total, tt.want)
+		}
+	}
+}
+
+func TestMaskAuditDetails(t *testing.T) {
+	tests := []struct {
+		details string
+		want    string
+	}{
+		{"/results?page=2&email=jane%40example.com", "/results?page=2&email=j***@e***.com"},
+		{"/results?email=jan&from=2024-01-01", "/results?email=***&from=2024-01-01"},
+		{"/results?email=&page=1", "/results?email=&page=1"},
+		{"customer history for jane@example.com", "customer history for j***@e***.com"},
+		{"pause (12 emails)", "pause (12 emails)"},
+	}
+	for _, tt := range tests {
+		if got := maskAuditDetails(tt.details); got != tt.want {
+			t.Errorf("maskAuditDetails(%q) = %q, want %q", tt.details, got, tt.want)
+		}
+	}
+}
+
+// storedAt returns the stored UTC form of a display-timezone time ("2006-01-02 15:04")
+func storedAt(t *testing.T, local string) string {
+	t.Helper()
+	timestamp, err := time.ParseInLocation("2006-01-02 15:04", local, displayLocation)
+	if err != nil {
+		t.Fatalf("parse %q: %v", local, err)
+	}
+	return formatRecordTimestamp(timestamp)
+}
+
+// insertRecordAt inserts a record with a raw stored timestamp, bypassing the time.Now() used by inserts
+func insertRecordAt(t *testing.T, timestamp, email, action string) {
+	t.Helper()
+	_, err := testDB(t).Exec(`INSERT INTO email_processing_records (timestamp, email, action) VALUES (?, ?, ?)`, timestamp, email, action)
+	if err != nil {
+		t.Fatalf("insert record at %q: %v", timestamp, err)
+	}
+}
+
+// recordEmails returns the email of each record, in order
+func recordEmails(records []DisplayRecord) []string {
+	emails := make([]string, len(records))
+	for i, record := range records {
+		emails[i] = record.Email
+	}
+	return emails
+}
+
+func TestDBActionName(t *testing.T) {
+	tests := map[string]string{
+		"pause":               "PAUSE",
+		"international":       "BBAU",
+		"unsubscribe":         "UNSUBSCRIBE",
+		"subscription_update": "SUBSCRIPTION_UPDATE",
+		"unsubscribe_all":     "UNSUBSCRIBE_ALL",
+		"resubscribe":         "RESUBSCRIBE",
+		"region":              "REGION_MOVE",
+		"cio_unsubscribed":    "CIO_UNSUBSCRIBED",
+		"cio_spam_reported":   "CIO_SPAM_REPORTED",
+		"cio_bounced":         "CIO_BOUNCED",
+		"unpause":             "UNPAUSE",
+	}
+	for action, want := range tests {
+		got, err := dbActionName(action)
+		if err != nil || got != want {
+			t.Errorf("dbActionName(%q) = %q, %v; want %q", action, got, err, want)
+		}
+	}
+
+	for _, action := range []string{"", "bogus", "PAUSE"} {
+		if got, err := dbActionName(action); err == nil {
+			t.Errorf("dbActionName(%q) = %q, want unknown action error", action, got)
+		}
+	}
+}
+
+func TestActionDefinitions(t *testing.T) {
+	names := make(map[string]bool)
+	dbActions := make(map[string]bool)
+	for _, definition := range actionDefinitions {
+		if names[definition.name] {
+			t.Errorf("action %q is defined more than once", definition.name)
+		}
+		names[definition.name] = true
+
+		if definition.dbAction != "" {
+			if dbActions[definition.dbAction] {
+				t.Errorf("database action %q is used by more than one action", definition.dbAction)
+			}
+			dbActions[definition.dbAction] = true
+			if !isRecordedAction(definition.dbAction) {
+				t.Errorf("isRecordedAction(%q) = false", definition.dbAction)
+			}
+		}
+
+		if definition.link != nil && definition.confirmPrompt == nil {
+			t.Errorf("link action %q has no confirmation prompt", definition.name)
+		}
+	}
+
+	if len(recordedActions()) != len(dbActions) {
+		t.Errorf("recordedActions() = %v, want %d actions", recordedActions(), len(dbActions))
+	}
+	if isRecordedAction("") {
+		t.Error(`isRecordedAction("") = true`)
+	}
+}
+
+func TestInsertEmailProcessingRecord(t *testing.T) {
+	setupTestDatabase(t)
+
+	if _, err := insertEmailProcessingRecord("jane@example.com", "international", nil); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := insertEmailProcessingRecord("jane@example.com", "bogus", nil); err == nil || !strings.Contains(err.Error(), "unknown action") {
+		t.Errorf("insert with unknown action error = %v, want unknown action error", err)
+	}
+
+	records, err := getAllRecordsForDisplay()
+	if err != nil {
+		t.Fatalf("getAllRecordsForDisplay: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1 (unknown action must not be stored)", len(records))
+	}
+	if got := records[0]; got.Email != "jane@example.com" || got.Action != "BBAU" || got.Status != recordStatusSuccess {
+		t.Errorf("record = %+v, want jane@example.com BBAU success", got)
+	}
+}
+
+func TestInsertEmailProcessingRecordDetails(t *testing.T) {
+	setupTestDatabase(t)
+
+	details := map[string]interface{}{"sub_bbus": "false", "sub_bbau": "true"}
+	if _, err := insertEmailProcessingRecord("jane@example.com", "subscription_update", details); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	records, err := getAllRecordsForDisplay()
+	if err != nil {
+		t.Fatalf("getAllRecordsForDisplay: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	if want := `{"sub_bbau":"true","sub_bbus":"false"}`; records[0].Details != want {
+		t.Errorf("details = %q, want %q", records[0].Details, want)
+	}
+	if want := "sub_bbau: true, sub_bbus: false"; records[0].DetailsLabel() != want {
+		t.Errorf("DetailsLabel = %q, want %q", records[0].DetailsLabel(), want)
+	}
+
+	if got := recordDetails(nil); got != "" {
+		t.Errorf("recordDetails(nil) = %q, want empty", got)
+	}
+	if got := (DisplayRecord{Details: "legacy text"}).DetailsLabel(); got != "legacy text" {
+		t.Errorf("DetailsLabel of plain text = %q, want it unchanged", got)
+	}
+}
+
+func TestDailyActionDedup(t *testing.T) {
+	setupTestDatabase(t)
+	if err := configureDailyActionDedup(testDB(t), true); err != nil {
+		t.Fatalf("configureDailyActionDedup: %v", err)
+	}
+
+	for i, want := range []bool{true, false} {
+		inserted, err := insertEmailProcessingRecord("jane@example.com", "pause", nil)
+		if err != nil {
+			t.Fatalf("insert %d: %v", i+1, err)
+		}
+		if inserted != want {
+			t.Errorf("insert %d reported inserted = %t, want %t", i+1, inserted, want)
+		}
+	}
+
+	// Failed attempts and other actions are not repeats of today's successful pause
+	if inserted, err := insertEmailProcessingRecordWithResult("jane@example.com", "pause", "", TrackResult{}, errors.New("timeout")); err != nil || !inserted {
+		t.Errorf("failed attempt: inserted = %t, err = %v, want inserted", inserted, err)
+	}
+	records := []recordInsert{{Email: "jane@example.com", Action: "pause"}, {Email: "jane@example.com", Action: "unsubscribe"}}
+	if inserted, err := insertEmailProcessingRecords(records); err != nil || inserted != 1 {
+		t.Errorf("batch: inserted = %d, err = %v, want 1", inserted, err)
+	}
+
+	summary, err := getActionSummary(DateRange{})
+	if err != nil {
+		t.Fatalf("getActionSummary: %v", err)
+	}
+	want := map[string]ActionCounts{"PAUSE": {Success: 1, Failed: 1}, "UNSUBSCRIBE": {Success: 1}}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("summary = %v, want %v", summary, want)
+	}
+}
+
+func TestWebhookEventDedup(t *testing.T) {
+	setupTestDatabase(t)
+
+	record := recordInsert{Email: "jane@example.com", Action: "cio_unsubscribed", EventID: "01E4C4C6P79C12J5A6KPE6XNFD"}
+	for i, want := range []int{1, 0} {
+		inserted, err := insertEmailProcessingRecords([]recordInsert{record})
+		if err != nil {
+			t.Fatalf("delivery %d: %v", i+1, err)
+		}
+		if inserted != want {
+			t.Errorf("delivery %d inserted %d records, want %d", i+1, inserted, want)
+		}
+	}
+
+	// Clearing the records keeps the seen event IDs, so a replay still adds nothing
+	if err := clearAllRecords(); err != nil {
+		t.Fatalf("clearAllRecords: %v", err)
+	}
+	if inserted, err := insertEmailProcessingRecords([]recordInsert{record}); err != nil || inserted != 0 {
+		t.Errorf("replay after clear: inserted = %d, err = %v, want 0", inserted, err)
+	}
+
+	record.EventID = "01E4C4C6P79C12J5A6KPE6XNFE"
+	if inserted, err := insertEmailProcessingRecords([]recordInsert{record}); err != nil || inserted != 1 {
+		t.Errorf("new event: inserted = %d, err = %v, want 1", inserted, err)
+	}
+}
+
+func TestGetActionSummary(t *testing.T) {
+	setupTestDatabase(t)
+
+	insertRecordAt(t, storedAt(t, "2024-03-01 09:00"), "a@example.com", "PAUSE")
+	insertRecordAt(t, storedAt(t, "2024-03-02 09:00"), "b@example.com", "PAUSE")
+	insertRecordAt(t, storedAt(t, "2024-03-03 09:00"), "c@example.com", "UNSUBSCRIBE")
+	if _, err := testDB(t).Exec(`INSERT INTO email_processing_records (timestamp, email, action, status) VALUES (?, ?, ?, ?)`,
+		storedAt(t, "2024-03-03 10:00"), "d@example.com", "UNSUBSCRIBE", recordStatusFailed); err != nil {
+		t.Fatalf("insert failed record: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		dateRange DateRange
+		want      map[string]ActionCounts
+	}{
+		{
+			name: "all time",
+			want: map[string]ActionCounts{"PAUSE": {Success: 2}, "UNSUBSCRIBE": {Success: 1, Failed: 1}},
+		},
+		{
+			name:      "date range",
+			dateRange: DateRange{From: "2024-03-02", To: "2024-03-02"},
+			want:      map[string]ActionCounts{"PAUSE": {Success: 1}},
+		},
+	}
+	for _, tt := range tests {
+		summary, err := getActionSummary(tt.dateRange)
+		if err != nil {
+			t.Fatalf("%s: getActionSummary: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(summary, tt.want) {
+			t.Errorf("%s: summary = %v, want %v", tt.name, summary, tt.want)
+		}
+	}
+}
+
+func TestGetActionTimeSeries(t *testing.T) {
+	setupTestDatabase(t)
+
+	// 00:30 in Sydney is the previous day in UTC, so these only group correctly by display-timezone date
+	insertRecordAt(t, storedAt(t, "2024-03-01 00:30"), "a@example.com", "UNSUBSCRIBE")
+	insertRecordAt(t, storedAt(t, "2024-03-01 23:30"), "b@example.com", "UNSUBSCRIBE")
+	insertRecordAt(t, storedAt(t, "2024-03-04 09:00"), "c@example.com", "UNSUBSCRIBE")
+	insertRecordAt(t, storedAt(t, "2024-04-02 09:00"), "d@example.com", "UNSUBSCRIBE")
+	insertRecordAt(t, storedAt(t, "2024-03-02 09:00"), "e@example.com", "PAUSE")
+	if _, err := testDB(t).Exec(`INSERT INTO email_processing_records (timestamp, email, action, status) VALUES (?, ?, ?, ?)`,
+		storedAt(t, "2024-03-01 10:00"), "f@example.com", "UNSUBSCRIBE", recordStatusFailed); err != nil {
+		t.Fatalf("insert failed record: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		action    string
+		interval  string
+		dateRange DateRange
+		want      []TimeSeriesPoint
+	}{
+		{
+			name:      "days with gaps filled",
+			action:    "UNSUBSCRIBE",
+			interval:  "day",
+			dateRange: DateRange{From: "2024-02-29", To: "2024-03-04"},
+			want: []TimeSeriesPoint{
+				{Date: "2024-02-29", Count: 0}, {Date: "2024-03-01", Count: 2}, {Date: "2024-03-02", Count: 0},
+				{Date: "2024-03-03", Count: 0}, {Date: "2024-03-04", Count: 1},
+			},
+		},
+		{
+			name:     "weeks start on Monday",
+			action:   "UNSUBSCRIBE",
+			interval: "week",
+			want: []TimeSeriesPoint{
+				{Date: "2024-02-26", Count: 2}, {Date: "2024-03-04", Count: 1}, {Date: "2024-03-11", Count: 0},
+				{Date: "2024-03-18", Count: 0}, {Date: "2024-03-25", Count: 0}, {Date: "2024-04-01", Count: 1},
+			},
+		},
+		{
+			name:     "months across all actions",
+			interval: "month",
+			want:     []TimeSeriesPoint{{Date: "2024-03-01", Count: 4}, {Date: "2024-04-01", Count: 1}},
+		},
+		{
+			name:     "no records",
+			action:   "RESUBSCRIBE",
+			interval: "day",
+			want:     []TimeSeriesPoint{},
+		},
+	}
+	for _, tt := range tests {
+		points, err := getActionTimeSeries(tt.action, tt.interval, tt.dateRange)
+		if err != nil {
+			t.Fatalf("%s: getActionTimeSeries: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(points, tt.want) {
+			t.Errorf("%s: points = %v, want %v", tt.name, points, tt.want)
+		}
+	}
+
+	if _, err := getActionTimeSeries("UNSUBSCRIBE", "hour", DateRange{}); err == nil {
+		t.Error("getActionTimeSeries with interval hour succeeded, want error")
+	}
+}
+
+func TestActionCountsErrorRate(t *testing.T) {
+	tests := []struct {
+		counts ActionCounts
+		want   string
+	}{
+		{ActionCounts{}, "0.0%"},
+		{ActionCounts{Success: 120, Failed: 3}, "2.4%"},
+		{ActionCounts{Failed: 2}, "100.0%"},
+	}
+	for _, tt := range tests {
+		if got := tt.counts.ErrorRateLabel(); got != tt.want {
+			t.Errorf("%+v.ErrorRateLabel() = %s, want %s", tt.counts, got, tt.want)
+		}
+	}
+}
+
+func TestGetRecordsByAction(t *testing.T) {
+	setupTestDatabase(t)
+
+	insertRecordAt(t, storedAt(t, "2024-03-01 09:00"), "old-pause@example.com", "PAUSE")
+	insertRecordAt(t, storedAt(t, "2024-03-05 09:00"), "new-pause@example.com", "PAUSE")
+	insertRecordAt(t, storedAt(t, "2024-03-03 09:00"), "unsub@example.com", "UNSUBSCRIBE")
+	// Either side of midnight at the end of the range, which is 13:00 UTC
+	insertRecordAt(t, storedAt(t, "2024-03-04 23:30"), "late@example.com", "BBAU")
+	insertRecordAt(t, storedAt(t, "2024-03-05 00:30"), "next-day@example.com", "BBAU")
+
+	tests := []struct {
+		name      string
+		action    string
+		dateRange DateRange
+		want      []string
+	}{
+		{"one action", "PAUSE", DateRange{}, []string{"new-pause@example.com", "old-pause@example.com"}},
+		{"every action", "", DateRange{}, []string{"new-pause@example.com", "next-day@example.com", "late@example.com", "unsub@example.com", "old-pause@example.com"}},
+		{"date range", "", DateRange{From: "2024-03-02", To: "2024-03-04"}, []string{"late@example.com", "unsub@example.com"}},
+		{"no matches", "REGION_MOVE", DateRange{}, []string{}},
+	}
+	for _, tt := range tests {
+		records, err := getRecordsByAction(tt.action, tt.dateRange)
+		if err != nil {
+			t.Fatalf("%s: getRecordsByAction: %v", tt.name, err)
+		}
+		if got := recordEmails(records); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: records = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetAllRecordsForDisplayNewestFirst(t *testing.T) {
+	setupTestDatabase(t)
+
+	insertRecordAt(t, storedAt(t, "2024-03-02 09:00"), "second@example.com", "PAUSE")
+	insertRecordAt(t, storedAt(t, "2024-03-03 09:00"), "third@example.com", "UNSUBSCRIBE")
+	insertRecordAt(t, storedAt(t, "2024-03-01 09:00"), "first@example.com", "BBAU")
+
+	records, err := getAllRecordsForDisplay()
+	if err != nil {
+		t.Fatalf("getAllRecordsForDisplay: %v", err)
+	}
+	want := []string{"third@example.com", "second@example.com", "first@example.com"}
+	if got := recordEmails(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+}
+
+func TestDisplayRecordTimestamps(t *testing.T) {
+	setupTestDatabase(t)
+
+	if displayLocation.String() != "Australia/Sydney" {
+		t.Skip("Sydney timezone data not available")
+	}
+
+	tests := []struct {
+		email     string
+		timestamp string
+		want      string
+	}{
+		// Stored in UTC and converted to Sydney daylight and standard time
+		{"summer@example.com", "2024-01-15T10:30:00.000000000Z", "2024-01-15 21:30:00 AEDT"},
+		{"winter@example.com", "2024-07-15T00:30:00.123456789Z", "2024-07-15 10:30:00 AEST"},
+	}
+	for _, tt := range tests {
+		insertRecordAt(t, tt.timestamp, tt.email, "PAUSE")
+	}
+	// Unparseable timestamps fall back to the current time rather than failing the page
+	insertRecordAt(t, "not a timestamp", "unparseable@example.com", "PAUSE")
+
+	records, err := getAllRecordsForDisplay()
+	if err != nil {
+		t.Fatalf("getAllRecordsForDisplay: %v", err)
+	}
+	byEmail := make(map[string]DisplayRecord)
+	for _, record := range records {
+		byEmail[record.Email] = record
+	}
+
+	for _, tt := range tests {
+		if got := byEmail[tt.email].FormattedDate; got != tt.want {
+			t.Errorf("FormattedDate for %q = %q, want %q", tt.timestamp, got, tt.want)
+		}
+	}
+	if got := byEmail["unparseable@example.com"].FormattedDate; got == "" {
+		t.Error("unparseable timestamp produced an empty FormattedDate, want the current time")
+	}
+
+	// The CSV export path formats timestamps the same way
+	exported, err := getRecordsByAction("PAUSE", DateRange{})
+	if err != nil {
+		t.Fatalf("getRecordsByAction: %v", err)
+	}
+	for _, record := range exported {
+		if want := byEmail[record.Email].FormattedDate; record.FormattedDate != want {
+			t.Errorf("exported FormattedDate for %s = %q, want %q", record.Email, record.FormattedDate, want)
+		}
+	}
+}
+
+func TestMigrateTimestampsToUTC(t *testing.T) {
+	setupTestDatabase(t)
+
+	tests := []struct {
+		email  string
+		stored string
+		want   string
+	}{
+		// Legacy rows written in Sydney local time by the driver
+		{"driver@example.com", "2024-01-15 21:30:00.5 +1100 AEDT", "2024-01-15T10:30:00.500000000Z"},
+		{"offset@example.com", "2024-01-15 21:30:00+11:00", "2024-01-15T10:30:00.000000000Z"},
+		{"fractional@example.com", "2024-07-15 10:30:00.123456789+10:00", "2024-07-15T00:30:00.123456789Z"},
+		// Legacy rows without an offset are read as UTC
+		{"no-offset@example.com", "2024-07-15 10:30:00", "2024-07-15T10:30:00.000000000Z"},
+		// Already migrated and unparseable rows are left alone
+		{"utc@example.com", "2024-07-15T10:30:00.000000000Z", "2024-07-15T10:30:00.000000000Z"},
+		{"unparseable@example.com", "not a timestamp", "not a timestamp"},
+	}
+	for _, tt := range tests {
+		insertRecordAt(t, tt.stored, tt.email, "PAUSE")
+	}
+
+	// Reopening runs the migration at startup, before the pool is published; doing it twice
+	// checks the migration is a no-op once rows are converted
+	for range 2 {
+		closeDatabase()
+		if err := initDatabase(); err != nil {
+			t.Fatalf("initDatabase: %v", err)
+		}
+	}
+
+	for _, tt := range tests {
+		var got string
+		if err := testDB(t).QueryRow(`SELECT CAST(timestamp AS TEXT) FROM email_processing_records WHERE email = ?`, tt.email).Scan(&got); err != nil {
+			t.Fatalf("read %s: %v", tt.email, err)
+		}
+		if got != tt.want {
+			t.Errorf("timestamp %q migrated to %q, want %q", tt.stored, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateRegionMoveDetails(t *testing.T) {
+	setupTestDatabase(t)
+
+	tests := []struct {
+		email   string
+		action  string
+		details string
+		want    string
+	}{
+		{"legacy-move@example.com", "REGION_MOVE", "BBUS->BBUK", `{"from":"BBUS","to":"BBUK"}`},
+		{"legacy-intl@example.com", "BBAU", "BBUS->BBAU", `{"from":"BBUS","to":"BBAU"}`},
+		// International records from before details were recorded were always BBUS to BBAU
+		{"no-details@example.com", "BBAU", "", `{"from":"BBUS","to":"BBAU"}`},
+		{"json@example.com", "REGION_MOVE", `{"from":"BBAU","to":"BBNZ"}`, `{"from":"BBAU","to":"BBNZ"}`},
+		{"pause@example.com", "PAUSE", "", ""},
+	}
+	for _, tt := range tests {
+		if _, err := testDB(t).Exec(`INSERT INTO email_processing_records (timestamp, email, action, details) VALUES (?, ?, ?, ?)`,
+			formatRecordTimestamp(time.Now()), tt.email, tt.action, tt.details); err != nil {
+			t.Fatalf("insert %s: %v", tt.email, err)
+		}
+	}
+
+	for range 2 {
+		if err := migrateRegionMoveDetails(testDB(t)); err != nil {
+			t.Fatalf("migrateRegionMoveDetails: %v", err)
+		}
+	}
+
+	for _, tt := range tests {
+		var got string
+		if err := testDB(t).QueryRow(`SELECT details FROM email_processing_records WHERE email = ?`, tt.email).Scan(&got); err != nil {
+			t.Fatalf("read %s: %v", tt.email, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s details %q migrated to %q, want %q", tt.action, tt.details, got, tt.want)
+		}
+	}
+
+	if got := (DisplayRecord{Details: regionMoveDetails("bbus", "bbuk")}).DetailsLabel(); got != "BBUS → BBUK" {
+		t.Errorf("DetailsLabel = %q, want %q", got, "BBUS → BBUK")
+	}
+}
+
+func TestClearAllRecords(t *testing.T) {
+	setupTestDatabase(t)
+
+	for _, action := range []string{"pause", "unsubscribe", "international"} {
+		if _, err := insertEmailProcessingRecord("jane@example.com", action, nil); err != nil {
+			t.Fatalf("insert %s: %v", action, err)
+		}
+	}
+
+	if err := clearAllRecords(); err != nil {
+		t.Fatalf("clearAllRecords: %v", err)
+	}
+
+	records, err := getAllRecordsForDisplay()
+	if err != nil {
+		t.Fatalf("getAllRecordsForDisplay: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("got %d records after clear, want 0", len(records))
+	}
+	summary, err := getActionSummary(DateRange{})
+	if err != nil {
+		t.Fatalf("getActionSummary: %v", err)
+	}
+	if len(summary) != 0 {
+		t.Errorf("summary after clear = %v, want empty", summary)
+	}
+}
+
+func TestGetRecordsFingerprint(t *testing.T) {
+	setupTestDatabase(t)
+
+	empty, err := getRecordsFingerprint()
+	if err != nil {
+		t.Fatalf("getRecordsFingerprint: %v", err)
+	}
+	if _, err := insertEmailProcessingRecord("jane@example.com", "pause", nil); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	inserted, err := getRecordsFingerprint()
+	if err != nil {
+		t.Fatalf("getRecordsFingerprint: %v", err)
+	}
+	if inserted == empty {
+		t.Errorf("fingerprint %q unchanged after insert", inserted)
+	}
+	if again, _ := getRecordsFingerprint(); again != inserted {
+		t.Errorf("fingerprint changed without writes: %q, then %q", inserted, again)
+	}
+
+	if err := clearAllRecords(); err != nil {
+		t.Fatalf("clearAllRecords: %v", err)
+	}
+	if cleared, _ := getRecordsFingerprint(); cleared == inserted {
+		t.Errorf("fingerprint %q unchanged after clear", cleared)
+	}
+}
+
+func TestClearRecordsBefore(t *testing.T) {
+	setupTestDatabase(t)
+
+	insertRecordAt(t, storedAt(t, "2025-03-01 10:00"), "old@example.com", "PAUSE")
+	insertRecordAt(t, storedAt(t, "2025-03-02 00:00"), "cutoff@example.com", "PAUSE")
+	insertRecordAt(t, storedAt(t, "2025-03-05 09:00"), "new@example.com", "PAUSE")
+
+	if _, err := clearRecordsBefore("March 2"); err == nil {
+		t.Error("clearRecordsBefore with an invalid date succeeded, want error")
+	}
+
+	cleared, err := clearRecordsBefore("2025-03-02")
+	if err != nil {
+		t.Fatalf("clearRecordsBefore: %v", err)
+	}
+	if cleared != 1 {
+		t.Errorf("cleared = %d, want 1", cleared)
+	}
+
+	records, err := getAllRecordsForDisplay()
+	if err != nil {
+		t.Fatalf("getAllRecordsForDisplay: %v", err)
+	}
+	if got, want := recordEmails(records), []string{"new@example.com", "cutoff@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining records = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteRecords(t *testing.T) {
+	setupTestDatabase(t)
+
+	insertRecordAt(t, storedAt(t, "2025-03-01 10:00"), "old-pause@example.com", "PAUSE")
+	insertRecordAt(t, storedAt(t, "2025-03-01 11:00"), "old-unsub@example.com", "UNSUBSCRIBE")
+	insertRecordAt(t, storedAt(t, "2025-03-05 09:00"), "new-pause@example.com", "PAUSE")
+
+	if _, err := deleteRecords("NOT_AN_ACTION", ""); err == nil {
+		t.Error("deleteRecords with an unknown action succeeded, want error")
+	}
+
+	deleted, err := deleteRecords("PAUSE", "2025-03-02")
+	if err != nil {
+		t.Fatalf("deleteRecords: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted = %d, want 1", deleted)
+	}
+
+	records, err := getAllRecordsForDisplay()
+	if err != nil {
+		t.Fatalf("getAllRecordsForDisplay: %v", err)
+	}
+	if got, want := recordEmails(records), []string{"new-pause@example.com", "old-unsub@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining records = %v, want %v", got, want)
+	}
+
+	if deleted, err = deleteRecords("PAUSE", ""); err != nil || deleted != 1 {
+		t.Errorf("deleteRecords(PAUSE) = %d, %v, want 1, nil", deleted, err)
+	}
+}
+
+func TestQueueActionRetry(t *testing.T) {
+	setupTestDatabase(t)
+
+	cause := &TrackAPIError{Operation: "relationship update", StatusCode: 503, Body: "customer@example.com"}
+	if err := queueActionRetry("customer@example.com", "region", "BBUS->BBUK", cause); err != nil {
+		t.Fatalf("queueActionRetry: %v", err)
+	}
+
+	if due, err := getDuePendingActions(time.Now()); err != nil || len(due) != 0 {
+		t.Fatalf("getDuePendingActions(now) = %v, %v, want nothing before the retry delay", due, err)
+	}
+
+	due, err := getDuePendingActions(time.Now().Add(pendingRetryBaseDelay + time.Second))
+	if err != nil {
+		t.Fatalf("getDuePendingActions: %v", err)
+	}
+	if len(due) != 1 {
+		t.Fatalf("got %d due actions, want 1", len(due))
+	}
+	action := due[0]
+	if action.Action != "region" || action.Details != "BBUS->BBUK" || action.Attempts != 1 {
+		t.Errorf("queued action = %+v, want region BBUS->BBUK after 1 attempt", action)
+	}
+	if action.LastError != "Customer.io relationship update failed with HTTP 503" {
+		t.Errorf("LastError = %q, want the error summary without the response body", action.LastError)
+	}
+
+	if claimed, err := updatePendingActionStatus(action.ID, "PENDING", "PROCESSING"); err != nil || !claimed {
+		t.Fatalf("claim = %v, %v", claimed, err)
+	}
+	if err := reschedulePendingAction(action.ID, time.Now().Add(time.Hour), "timed out"); err != nil {
+		t.Fatalf("reschedulePendingAction: %v", err)
+	}
+
+	count, err := countPendingActions()
+	if err != nil {
+		t.Fatalf("countPendingActions: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("countPendingActions = %d, want 1", count)
+	}
+
+	due, err = getDuePendingActions(time.Now().Add(2 * time.Hour))
+	if err != nil || len(due) != 1 {
+		t.Fatalf("getDuePendingActions after reschedule = %v, %v", due, err)
+	}
+	if due[0].Attempts != 2 || due[0].LastError != "timed out" {
+		t.Errorf("rescheduled action = %+v, want 2 attempts and the new error", due[0])
+	}
+}
+
+func TestIsTransientActionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"customer not found", errCustomerNotFound, false},
+		{"anonymous profile", fmt.Errorf("identify: %w", errAnonymousProfile), false},
+		{"bad request", &TrackAPIError{StatusCode: 400}, false},
+		{"rate limited", &TrackAPIError{StatusCode: 429}, true},
+		{"server error", &TrackAPIError{StatusCode: 502}, true},
+		{"network error", errors.New("dial tcp: connection refused"), true},
+	}
+	for _, tt := range tests {
+		if got := isTransientActionError(tt.err); got != tt.want {
+			t.Errorf("%s: isTransientActionError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if got := pendingRetryDelay(1); got != pendingRetryBaseDelay {
+		t.Errorf("pendingRetryDelay(1) = %s, want %s", got, pendingRetryBaseDelay)
+	}
+	if got := pendingRetryDelay(3); got != 4*pendingRetryBaseDelay {
+		t.Errorf("pendingRetryDelay(3) = %s, want %s", got, 4*pendingRetryBaseDelay)
+	}
+	if got := pendingRetryDelay(50); got != pendingRetryMaxDelay {
+		t.Errorf("pendingRetryDelay(50) = %s, want %s", got, pendingRetryMaxDelay)
+	}
+}
+
+func TestIdempotentResponses(t *testing.T) {
+	setupTestDatabase(t)
+
+	stored := IdempotentResponse{RequestHash: "abc", StatusCode: 200, ContentType: "application/json", Body: []byte(`{"success":true}`)}
+	if err := saveIdempotentResponse("/update-subscriptions key-1", stored, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("saveIdempotentResponse: %v", err)
+	}
+
+	got, err := getIdempotentResponse("/update-subscriptions key-1", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("getIdempotentResponse: %v", err)
+	}
+	if got == nil || !reflect.DeepEqual(*got, stored) {
+		t.Errorf("getIdempotentResponse = %+v, want %+v", got, stored)
+	}
+
+	if got, err := getIdempotentResponse("/unsubscribe-all key-1", time.Now().Add(-time.Hour)); err != nil || got != nil {
+		t.Errorf("getIdempotentResponse for another route = %+v, %v, want nil", got, err)
+	}
+
+	// Keys older than the TTL are neither returned nor kept once another key is stored
+	if got, err := getIdempotentResponse("/update-subscriptions key-1", time.Now().Add(time.Minute)); err != nil || got != nil {
+		t.Errorf("getIdempotentResponse past the TTL = %+v, %v, want nil", got, err)
+	}
+	if err := saveIdempotentResponse("/update-subscriptions key-2", stored, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("saveIdempotentResponse: %v", err)
+	}
+	var count int
+	if err := testDB(t).QueryRow(`SELECT COUNT(*) FROM idempotency_keys`).Scan(&count); err != nil {
+		t.Fatalf("count idempotency keys: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("idempotency_keys holds %d rows after pruning, want 1", count)
+	}
+}
+
+func TestConfigureDisplayTimezone(t *testing.T) {
+	previous := displayLocation
+	t.Cleanup(func() { displayLocation = previous })
+
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"", defaultDisplayTimezone},
+		{"America/New_York", "America/New_York"},
+		{"Not/AZone", "UTC"},
+	}
+	for _, tt := range tests {
+		t.Setenv("DISPLAY_TIMEZONE", tt.env)
+		configureDisplayTimezone()
+		if got := displayLocation.String(); got != tt.want {
+			t.Errorf("DISPLAY_TIMEZONE=%q: displayLocation = %s, want %s", tt.env, got, tt.want)
+		}
+	}
+}
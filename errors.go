@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	fiberrecover "github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// requestIDLocalsKey stores the request ID set by requestIDMiddleware in the request locals
+const requestIDLocalsKey = "request_id"
+
+// requestIDMiddleware tags every request with an ID, echoed in the X-Request-ID response header,
+// the access log and error pages so a support report can be matched to the logs. An incoming
+// X-Request-ID from the proxy is kept.
+func requestIDMiddleware() fiber.Handler {
+	return requestid.New(requestid.Config{
+		Generator:  newRequestID,
+		ContextKey: requestIDLocalsKey,
+	})
+}
+
+// requestID returns the ID requestIDMiddleware assigned to the current request
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDLocalsKey).(string)
+	return id
+}
+
+// recoverMiddleware turns a panic in a handler into a 500 for handleError instead of crashing the
+// server, logging the panic with its stack trace, path and request ID
+func recoverMiddleware() fiber.Handler {
+	return fiberrecover.New(fiberrecover.Config{
+		EnableStackTrace: true,
+		StackTraceHandler: func(c *fiber.Ctx, panicValue interface{}) {
+			slog.Error("Recovered from panic in handler", "method", c.Method(), "path", c.Path(), "request_id", requestID(c),
+				"panic", panicValue, "stack", string(debug.Stack()))
+		},
+	})
+}
+
+// recoverStreamPanic is deferred by body stream writers, which run after the handler has returned
+// and so outside recoverMiddleware. The response is cut short, but the server keeps running.
+func recoverStreamPanic(stream, id string) {
+	if panicValue := recover(); panicValue != nil {
+		slog.Error("Recovered from panic while streaming response", "stream", stream, "request_id", id,
+			"panic", panicValue, "stack", string(debug.Stack()))
+	}
+}
+
+// handleError is the app's ErrorHandler. A *fiber.Error keeps its status and message and an uninitialized
+// database is a 503; any other error is logged and shown as a generic 500 so internal details never reach
+// the client. Browsers get the error.html page, JSON clients a JSON body and everyone else plain text, all
+// carrying the request ID.
+func handleError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	message := "Something went wrong on our side. Please try again later."
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status, message = fiberErr.Code, fiberErr.Message
+	} else if errors.Is(err, errDatabaseNotInitialized) {
+		// Requests racing startup or shutdown; the client can simply retry
+		slog.Warn("Request reached the database before it was available", "method", c.Method(), "path", c.Path(), "request_id", requestID(c))
+		status, message = fiber.StatusServiceUnavailable, "The database is not available. Please try again shortly."
+	} else {
+		slog.Error("Unhandled request error", "method", c.Method(), "path", c.Path(), "request_id", requestID(c), "error", err)
+	}
+
+	c.Status(status)
+	switch {
+	case wantsJSON(c):
+		return c.JSON(fiber.Map{
+			"success":    false,
+			"message":    message,
+			"request_id": requestID(c),
+		})
+	case strings.Contains(c.Get(fiber.HeaderAccept), fiber.MIMETextHTML):
+		renderErr := c.Render("error", fiber.Map{
+			"Title":     http.StatusText(status),
+			"Message":   message,
+			"RequestID": requestID(c),
+		})
+		if renderErr == nil {
+			return nil
+		}
+		slog.Error("Failed to render error page", "request_id", requestID(c), "error", renderErr)
+	}
+
+	// Errors raised before the middleware chain (e.g. an oversized body) have no request ID
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	if id := requestID(c); id != "" {
+		message += " (request ID " + id + ")"
+	}
+	return c.SendString(message)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	healthCheckTimeout        = 2 * time.Second  // Upper bound on each dependency check so the probe never hangs
+	startupHealthcheckTimeout = 10 * time.Second // Upper bound on the STARTUP_HEALTHCHECK call to Customer.io
+	customerIOHealthCacheTTL  = 15 * time.Second // How long a Customer.io health check result is reused
+	defaultHealthRateLimit    = 60               // GET /health requests per IP per minute
+)
+
+var startTime = time.Now() // Process start time, used to report uptime
+
+// customerIOHealth caches the last Customer.io ping made for GET /health. The ping is an authenticated
+// Track API call outside CUSTOMERIO_MAX_CONCURRENCY, so without the cache a flood of health checks
+// would spend the account's rate limit that customer actions need.
+var customerIOHealth struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// checkCustomerIOHealth pings Customer.io at most once per customerIOHealthCacheTTL, returning the cached
+// result in between. Concurrent callers wait for the one ping in flight rather than sending their own.
+func checkCustomerIOHealth(ctx context.Context) error {
+	customerIOHealth.mu.Lock()
+	defer customerIOHealth.mu.Unlock()
+
+	if !customerIOHealth.checkedAt.IsZero() && time.Since(customerIOHealth.checkedAt) < customerIOHealthCacheTTL {
+		return customerIOHealth.err
+	}
+	customerIOHealth.err = customerIO.Ping(ctx)
+	customerIOHealth.checkedAt = time.Now()
+	return customerIOHealth.err
+}
+
+// runStartupHealthcheck makes one authenticated Track API call when STARTUP_HEALTHCHECK=true and exits if
+// Customer.io rejects the credentials, so a misconfigured secret fails the deploy instead of the first
+// customer action. An unreachable API only logs a warning, since it may be a passing outage. It is off by
+// default so offline development isn't blocked.
+func runStartupHealthcheck() {
+	if os.Getenv("STARTUP_HEALTHCHECK") != "true" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), startupHealthcheckTimeout)
+	defer cancel()
+	err := customerIO.Ping(ctx)
+	if errors.Is(err, errCredentialsRejected) {
+		log.Fatalf("CRITICAL: Startup healthcheck failed: Customer.io rejected the credentials - check CUSTOMERIO_SITE_ID and CUSTOMERIO_API_KEY (%v)", err)
+	}
+	if err != nil {
+		log.Printf("WARNING: Startup healthcheck could not reach Customer.io, continuing: %v", err)
+		return
+	}
+	log.Println("Startup healthcheck passed: Customer.io accepted the credentials.")
+}
+
+// handleHealth reports database and Customer.io connectivity for readiness probes, along with how many
+// actions are waiting in the pending_actions queue (grace-period unsubscribes and retries of failed calls)
+func handleHealth(c *fiber.Ctx) error {
+	status := "ok"
+	databaseStatus := "ok"
+	customerIOStatus := "ok"
+
+	dbCtx, cancelDB := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancelDB()
+	if databaseDisabled {
+		databaseStatus = "disabled"
+		status = "degraded"
+	} else if db, err := database(); err != nil {
+		databaseStatus = "not initialized"
+		status = "degraded"
+	} else if err := db.PingContext(dbCtx); err != nil {
+		log.Printf("ERROR: Health check database ping failed: %v", err)
+		databaseStatus = "error"
+		status = "degraded"
+	}
+
+	cioCtx, cancelCIO := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancelCIO()
+	if customerIO == nil {
+		customerIOStatus = "not initialized"
+		status = "degraded"
+	} else if err := checkCustomerIOHealth(cioCtx); err != nil {
+		log.Printf("ERROR: Health check Customer.io ping failed: %v", err)
+		customerIOStatus = "error"
+		if errors.Is(err, errCredentialsRejected) {
+			customerIOStatus = "credentials rejected"
+		}
+		status = "degraded"
+	}
+
+	// Report the queue size when the database can be read; -1 means it couldn't be counted
+	pendingActions := -1
+	if databaseStatus == "ok" {
+		count, err := countPendingActions()
+		if err != nil {
+			log.Printf("ERROR: Health check failed to count pending actions: %v", err)
+		} else {
+			pendingActions = count
+		}
+	}
+
+	// A database disabled by DB_OPTIONAL degrades the instance, but it can still serve customer actions
+	httpStatus := 200
+	if status != "ok" && !(databaseStatus == "disabled" && customerIOStatus == "ok") {
+		httpStatus = 503
+	}
+
+	return c.Status(httpStatus).JSON(fiber.Map{
+		"status":          status,
+		"database":        databaseStatus,
+		"customerio":      customerIOStatus,
+		"pending_actions": pendingActions,
+		"uptime_seconds":  int64(time.Since(startTime).Seconds()),
+		"version":         version,
+	})
+}
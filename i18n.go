@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultLocale is the language messages are written in and the fallback for anything untranslated
+const defaultLocale = "en"
+
+// supportedLocales lists the customer-facing languages, the default first so it wins Accept-Language ties
+var supportedLocales = []string{defaultLocale, "fr", "de", "es"}
+
+// messageCatalog translates customer-facing messages, keyed by locale and then by the English format
+// string used in the code (gettext style), so a missing entry simply shows the English text.
+// Translations keep the English placeholders in the same order.
+var messageCatalog = map[string]map[string]string{
+	"fr": {
+		// Link action outcomes
+		"Customer (%s) has been paused.":                        "Le client (%s) a été mis en pause.",
+		"Customer (%s) moved to Australian/International list.": "Le client (%s) a été transféré vers la liste Australie/International.",
+		"Customer (%s) has been unsubscribed.":                  "Le client (%s) a été désabonné.",
+		"Customer (%s) has been resubscribed.":                  "Le client (%s) a été réabonné.",
+		"Customer (%s) has been unpaused.":                      "Le client (%s) n'est plus en pause.",
+		"Customer (%s) moved from %s to %s.":                    "Le client (%s) a été transféré de %s vers %s.",
+		"Customer (%s) will be unsubscribed in %d minutes.":     "Le client (%s) sera désabonné dans %d minutes.",
+		"Your request has been processed.":                      "Votre demande a été traitée.",
+		"We couldn't reach our email service just now. Your request has been saved and will be applied automatically.": "Notre service d'e-mail est momentanément injoignable. Votre demande a été enregistrée et sera appliquée automatiquement.",
+		"Unknown action requested.":                                                           "Action demandée inconnue.",
+		"Invalid region move requested.":                                                      "Le changement de région demandé n'est pas valide.",
+		"Error processing pause request. Check logs.":                                         "Erreur lors du traitement de la demande de mise en pause. Consultez les journaux.",
+		"Error processing international request. Check logs.":                                 "Erreur lors du traitement de la demande de transfert international. Consultez les journaux.",
+		"Error processing region request. Check logs.":                                        "Erreur lors du traitement de la demande de changement de région. Consultez les journaux.",
+		"Error processing unsubscribe request. Check logs.":                                   "Erreur lors du traitement de la demande de désabonnement. Consultez les journaux.",
+		"Error processing resubscribe request. Check logs.":                                   "Erreur lors du traitement de la demande de réabonnement. Consultez les journaux.",
+		"Error processing unpause request. Check logs.":                                       "Erreur lors du traitement de la demande de reprise des e-mails. Consultez les journaux.",
+		"This email address is not linked to an identified customer profile yet.":             "Cette adresse e-mail n'est pas encore associée à un profil client identifié.",
+		"We couldn't find a customer with this email address. Please check it and try again.": "Aucun client ne correspond à cette adresse e-mail. Vérifiez-la et réessayez.",
+		"We can't process requests right now. Please try again later.":                        "Nous ne pouvons pas traiter les demandes pour le moment. Veuillez réessayer plus tard.",
+		"We're handling a lot of requests right now. Please try again in a moment.":           "Nous traitons de nombreuses demandes en ce moment. Veuillez réessayer dans un instant.",
+		"We couldn't process this email address. Please check it and try again.":              "Nous n'avons pas pu traiter cette adresse e-mail. Vérifiez-la et réessayez.",
+
+		// Confirmation prompts
+		"Pause emails for %s?":                          "Mettre en pause les e-mails pour %s ?",
+		"Move %s to the Australian/International list?": "Transférer %s vers la liste Australie/International ?",
+		"Are you sure you want to unsubscribe %s?":      "Voulez-vous vraiment désabonner %s ?",
+		"Resubscribe %s to emails?":                     "Réabonner %s aux e-mails ?",
+		"Move %s from %s to %s?":                        "Transférer %s de %s vers %s ?",
+		"Resume emails for %s?":                         "Reprendre les e-mails pour %s ?",
+		"customer ID %s":                                "l'identifiant client %s",
+
+		// Link and confirmation errors
+		"Please provide a valid email address.":                                                        "Veuillez fournir une adresse e-mail valide.",
+		"Please provide a valid customer ID.":                                                          "Veuillez fournir un identifiant client valide.",
+		"This link has expired. Please request a new one or use the link from your most recent email.": "Ce lien a expiré. Demandez-en un nouveau ou utilisez le lien de votre e-mail le plus récent.",
+		"This link is invalid. Please use the link from your most recent email.":                       "Ce lien n'est pas valide. Veuillez utiliser le lien de votre e-mail le plus récent.",
+		"This link is no longer supported. Please use the link from your most recent email.":           "Ce lien n'est plus pris en charge. Veuillez utiliser le lien de votre e-mail le plus récent.",
+		"Missing customer or action.":                                                                  "Client ou action manquant.",
+		"This confirmation has expired. Please open the link from your email again.":                   "Cette confirmation a expiré. Veuillez rouvrir le lien de votre e-mail.",
+
+		// Unsubscribe cancellation
+		"Missing cancel token.":                                   "Jeton d'annulation manquant.",
+		"Error cancelling unsubscribe request. Please try again.": "Erreur lors de l'annulation de la demande de désabonnement. Veuillez réessayer.",
+		"This unsubscribe request can no longer be cancelled.":    "Cette demande de désabonnement ne peut plus être annulée.",
+		"Your unsubscribe request for %s has been cancelled.":     "Votre demande de désabonnement pour %s a été annulée.",
+
+		// Page text
+		"Barney - Email Preferences":         "Barney - Préférences e-mail",
+		"Barney - Confirm Email Preferences": "Barney - Confirmer les préférences e-mail",
+		"Confirm":                            "Confirmer",
+		"Undo":                               "Annuler",
+		"Changed your mind? Undo this unsubscribe.": "Vous avez changé d'avis ? Annulez ce désabonnement.",
+		"No action was requested.":                  "Aucune action n'a été demandée.",
+	},
+	"de": {
+		// Link action outcomes
+		"Customer (%s) has been paused.":                        "Kunde (%s) wurde pausiert.",
+		"Customer (%s) moved to Australian/International list.": "Kunde (%s) wurde auf die Liste Australien/International verschoben.",
+		"Customer (%s) has been unsubscribed.":                  "Kunde (%s) wurde abgemeldet.",
+		"Customer (%s) has been resubscribed.":                  "Kunde (%s) wurde wieder angemeldet.",
+		"Customer (%s) has been unpaused.":                      "Die Pause für Kunde (%s) wurde aufgehoben.",
+		"Customer (%s) moved from %s to %s.":                    "Kunde (%s) wurde von %s nach %s verschoben.",
+		"Customer (%s) will be unsubscribed in %d minutes.":     "Kunde (%s) wird in %d Minuten abgemeldet.",
+		"Your request has been processed.":                      "Ihre Anfrage wurde bearbeitet.",
+		"We couldn't reach our email service just now. Your request has been saved and will be applied automatically.": "Unser E-Mail-Dienst ist gerade nicht erreichbar. Ihre Anfrage wurde gespeichert und wird automatisch ausgeführt.",
+		"Unknown action requested.":                                                           "Unbekannte Aktion angefordert.",
+		"Invalid region move requested.":                                                      "Ungültiger Regionswechsel angefordert.",
+		"Error processing pause request. Check logs.":                                         "Fehler bei der Bearbeitung der Pausierungsanfrage. Bitte Logs prüfen.",
+		"Error processing international request. Check logs.":                                 "Fehler bei der Bearbeitung der Anfrage zum internationalen Wechsel. Bitte Logs prüfen.",
+		"Error processing region request. Check logs.":                                        "Fehler bei der Bearbeitung der Anfrage zum Regionswechsel. Bitte Logs prüfen.",
+		"Error processing unsubscribe request. Check logs.":                                   "Fehler bei der Bearbeitung der Abmeldeanfrage. Bitte Logs prüfen.",
+		"Error processing resubscribe request. Check logs.":                                   "Fehler bei der Bearbeitung der Anfrage zur erneuten Anmeldung. Bitte Logs prüfen.",
+		"Error processing unpause request. Check logs.":                                       "Fehler bei der Bearbeitung der Anfrage zum Aufheben der Pause. Bitte Logs prüfen.",
+		"This email address is not linked to an identified customer profile yet.":             "Diese E-Mail-Adresse ist noch keinem identifizierten Kundenprofil zugeordnet.",
+		"We couldn't find a customer with this email address. Please check it and try again.": "Wir konnten keinen Kunden mit dieser E-Mail-Adresse finden. Bitte überprüfen Sie sie und versuchen Sie es erneut.",
+		"We can't process requests right now. Please try again later.":                        "Wir können Anfragen derzeit nicht bearbeiten. Bitte versuchen Sie es später erneut.",
+		"We're handling a lot of requests right now. Please try again in a moment.":           "Wir bearbeiten gerade sehr viele Anfragen. Bitte versuchen Sie es gleich noch einmal.",
+		"We couldn't process this email address. Please check it and try again.":              "Diese E-Mail-Adresse konnte nicht verarbeitet werden. Bitte überprüfen Sie sie und versuchen Sie es erneut.",
+
+		// Confirmation prompts
+		"Pause emails for %s?":                          "E-Mails für %s pausieren?",
+		"Move %s to the Australian/International list?": "%s auf die Liste Australien/International verschieben?",
+		"Are you sure you want to unsubscribe %s?":      "Möchten Sie %s wirklich abmelden?",
+		"Resubscribe %s to emails?":                     "%s wieder für E-Mails anmelden?",
+		"Move %s from %s to %s?":                        "%s von %s nach %s verschieben?",
+		"Resume emails for %s?":                         "E-Mails für %s fortsetzen?",
+		"customer ID %s":                                "Kunden-ID %s",
+
+		// Link and confirmation errors
+		"Please provide a valid email address.":                                                        "Bitte geben Sie eine gültige E-Mail-Adresse an.",
+		"Please provide a valid customer ID.":                                                          "Bitte geben Sie eine gültige Kunden-ID an.",
+		"This link has expired. Please request a new one or use the link from your most recent email.": "Dieser Link ist abgelaufen. Bitte fordern Sie einen neuen an oder verwenden Sie den Link aus Ihrer neuesten E-Mail.",
+		"This link is invalid. Please use the link from your most recent email.":                       "Dieser Link ist ungültig. Bitte verwenden Sie den Link aus Ihrer neuesten E-Mail.",
+		"This link is no longer supported. Please use the link from your most recent email.":           "Dieser Link wird nicht mehr unterstützt. Bitte verwenden Sie den Link aus Ihrer neuesten E-Mail.",
+		"Missing customer or action.":                                                                  "Kunde oder Aktion fehlt.",
+		"This confirmation has expired. Please open the link from your email again.":                   "Diese Bestätigung ist abgelaufen. Bitte öffnen Sie den Link aus Ihrer E-Mail erneut.",
+
+		// Unsubscribe cancellation
+		"Missing cancel token.":                                   "Widerrufs-Token fehlt.",
+		"Error cancelling unsubscribe request. Please try again.": "Fehler beim Widerrufen der Abmeldung. Bitte versuchen Sie es erneut.",
+		"This unsubscribe request can no longer be cancelled.":    "Diese Abmeldung kann nicht mehr widerrufen werden.",
+		"Your unsubscribe request for %s has been cancelled.":     "Ihre Abmeldung für %s wurde widerrufen.",
+
+		// Page text
+		"Barney - Email Preferences":         "Barney - E-Mail-Einstellungen",
+		"Barney - Confirm Email Preferences": "Barney - E-Mail-Einstellungen bestätigen",
+		"Confirm":                            "Bestätigen",
+		"Undo":                               "Rückgängig",
+		"Changed your mind? Undo this unsubscribe.": "Meinung geändert? Abmeldung rückgängig machen.",
+		"No action was requested.":                  "Es wurde keine Aktion angefordert.",
+	},
+	"es": {
+		// Link action outcomes
+		"Customer (%s) has been paused.":                        "El cliente (%s) ha sido pausado.",
+		"Customer (%s) moved to Australian/International list.": "El cliente (%s) se ha trasladado a la lista de Australia/Internacional.",
+		"Customer (%s) has been unsubscribed.":                  "El cliente (%s) ha sido dado de baja.",
+		"Customer (%s) has been resubscribed.":                  "El cliente (%s) se ha vuelto a suscribir.",
+		"Customer (%s) has been unpaused.":                      "El cliente (%s) ya no está en pausa.",
+		"Customer (%s) moved from %s to %s.":                    "El cliente (%s) se ha trasladado de %s a %s.",
+		"Customer (%s) will be unsubscribed in %d minutes.":     "El cliente (%s) será dado de baja en %d minutos.",
+		"Your request has been processed.":                      "Su solicitud ha sido procesada.",
+		"We couldn't reach our email service just now. Your request has been saved and will be applied automatically.": "No hemos podido contactar con nuestro servicio de correo en este momento. Su solicitud se ha guardado y se aplicará automáticamente.",
+		"Unknown action requested.":                                                           "Se ha solicitado una acción desconocida.",
+		"Invalid region move requested.":                                                      "El cambio de región solicitado no es válido.",
+		"Error processing pause request. Check logs.":                                         "Error al procesar la solicitud de pausa. Revise los registros.",
+		"Error processing international request. Check logs.":                                 "Error al procesar la solicitud de traslado internacional. Revise los registros.",
+		"Error processing region request. Check logs.":                                        "Error al procesar la solicitud de cambio de región. Revise los registros.",
+		"Error processing unsubscribe request. Check logs.":                                   "Error al procesar la solicitud de baja. Revise los registros.",
+		"Error processing resubscribe request. Check logs.":                                   "Error al procesar la solicitud de nueva suscripción. Revise los registros.",
+		"Error processing unpause request. Check logs.":                                       "Error al procesar la solicitud de reanudación. Revise los registros.",
+		"This email address is not linked to an identified customer profile yet.":             "Esta dirección de correo electrónico aún no está vinculada a un perfil de cliente identificado.",
+		"We couldn't find a customer with this email address. Please check it and try again.": "No encontramos ningún cliente con esta dirección de correo electrónico. Compruébela e inténtelo de nuevo.",
+		"We can't process requests right now. Please try again later.":                        "No podemos procesar solicitudes en este momento. Inténtelo de nuevo más tarde.",
+		"We're handling a lot of requests right now. Please try again in a moment.":           "Estamos atendiendo muchas solicitudes en este momento. Inténtelo de nuevo en unos instantes.",
+		"We couldn't process this email address. Please check it and try again.":              "No pudimos procesar esta dirección de correo electrónico. Compruébela e inténtelo de nuevo.",
+
+		// Confirmation prompts
+		"Pause emails for %s?":                          "¿Pausar los correos para %s?",
+		"Move %s to the Australian/International list?": "¿Trasladar %s a la lista de Australia/Internacional?",
+		"Are you sure you want to unsubscribe %s?":      "¿Seguro que desea dar de baja a %s?",
+		"Resubscribe %s to emails?":                     "¿Volver a suscribir a %s a los correos?",
+		"Move %s from %s to %s?":                        "¿Trasladar %s de %s a %s?",
+		"Resume emails for %s?":                         "¿Reanudar los correos para %s?",
+		"customer ID %s":                                "el ID de cliente %s",
+
+		// Link and confirmation errors
+		"Please provide a valid email address.":                                                        "Indique una dirección de correo electrónico válida.",
+		"Please provide a valid customer ID.":                                                          "Indique un ID de cliente válido.",
+		"This link has expired. Please request a new one or use the link from your most recent email.": "Este enlace ha caducado. Solicite uno nuevo o utilice el enlace de su correo más reciente.",
+		"This link is invalid. Please use the link from your most recent email.":                       "Este enlace no es válido. Utilice el enlace de su correo más reciente.",
+		"This link is no longer supported. Please use the link from your most recent email.":           "Este enlace ya no es compatible. Utilice el enlace de su correo más reciente.",
+		"Missing customer or action.":                                                                  "Falta el cliente o la acción.",
+		"This confirmation has expired. Please open the link from your email again.":                   "Esta confirmación ha caducado. Vuelva a abrir el enlace de su correo.",
+
+		// Unsubscribe cancellation
+		"Missing cancel token.":                                   "Falta el token de cancelación.",
+		"Error cancelling unsubscribe request. Please try again.": "Error al cancelar la solicitud de baja. Inténtelo de nuevo.",
+		"This unsubscribe request can no longer be cancelled.":    "Esta solicitud de baja ya no se puede cancelar.",
+		"Your unsubscribe request for %s has been cancelled.":     "Se ha cancelado su solicitud de baja para %s.",
+
+		// Page text
+		"Barney - Email Preferences":         "Barney - Preferencias de correo",
+		"Barney - Confirm Email Preferences": "Barney - Confirmar preferencias de correo",
+		"Confirm":                            "Confirmar",
+		"Undo":                               "Deshacer",
+		"Changed your mind? Undo this unsubscribe.": "¿Ha cambiado de opinión? Deshaga esta baja.",
+		"No action was requested.":                  "No se ha solicitado ninguna acción.",
+	},
+}
+
+// translate formats a customer-facing message in locale, falling back to the English format when
+// the locale or the message has no translation. It is also the "t" template function.
+func translate(locale, format string, args ...interface{}) string {
+	if translated, ok := messageCatalog[locale][format]; ok {
+		format = translated
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// requestLocale picks the customer's language: a supported lang parameter (query, or the confirmation
+// form), then the best supported Accept-Language match, then English
+func requestLocale(c *fiber.Ctx) string {
+	for _, lang := range []string{c.Query("lang"), c.FormValue("lang")} {
+		if locale := supportedLocale(lang); locale != "" {
+			return locale
+		}
+	}
+	if locale := c.AcceptsLanguages(supportedLocales...); locale != "" {
+		return locale
+	}
+	return defaultLocale
+}
+
+// supportedLocale returns the supported locale for a language tag such as "fr" or "fr-CA", or ""
+func supportedLocale(lang string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(lang)), "-")
+	primary, _, _ = strings.Cut(primary, "_")
+	for _, locale := range supportedLocales {
+		if locale == primary {
+			return locale
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	idempotencyKeyHeader        = "Idempotency-Key"
+	idempotencyReplayedHeader   = "Idempotent-Replayed" // Set to "true" on responses replayed from the cache
+	maxIdempotencyKeyLength     = 255
+	defaultIdempotencyKeyTTLHrs = 24               // How long a processed key is remembered (IDEMPOTENCY_KEY_TTL_HOURS)
+	idempotencyInFlightWait     = 30 * time.Second // How long a duplicate waits for the original request to finish
+)
+
+// idempotencyInFlight holds a channel per key currently being processed, closed when its response is stored
+var idempotencyInFlight sync.Map
+
+// newIdempotencyMiddleware returns a handler that makes POST requests carrying an Idempotency-Key header safe
+// to repeat: the first response for a key is stored for IDEMPOTENCY_KEY_TTL_HOURS and replayed, with the same
+// status and body, for any later request with the key instead of calling the handler again. A duplicate that
+// arrives while the original is still running waits for it. Requests without the header are unaffected.
+func newIdempotencyMiddleware() fiber.Handler {
+	ttl := time.Duration(positiveIntFromEnv("IDEMPOTENCY_KEY_TTL_HOURS", defaultIdempotencyKeyTTLHrs)) * time.Hour
+	slog.Info("Idempotency keys accepted on the subscription endpoints", "ttl", ttl.String())
+
+	return func(c *fiber.Ctx) error {
+		key := c.Get(idempotencyKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return fiber.NewError(fiber.StatusBadRequest, "Idempotency-Key is too long")
+		}
+
+		// Keys are chosen by clients, so scope them to the route
+		scopedKey := c.Path() + " " + key
+		requestHash := sha256.Sum256(c.Body())
+		hash := hex.EncodeToString(requestHash[:])
+
+		done := make(chan struct{})
+		if running, loaded := idempotencyInFlight.LoadOrStore(scopedKey, done); loaded {
+			select {
+			case <-running.(chan struct{}):
+			case <-time.After(idempotencyInFlightWait):
+				return fiber.NewError(fiber.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			}
+			return replayIdempotentResponse(c, scopedKey, hash, ttl)
+		}
+		defer func() {
+			idempotencyInFlight.Delete(scopedKey)
+			close(done)
+		}()
+
+		// A key seen before (possibly by an earlier process) replays its stored response
+		stored, err := getIdempotentResponse(scopedKey, time.Now().Add(-ttl))
+		if err != nil {
+			slog.Warn("Failed to look up idempotency key, processing request", "path", c.Path(), "error", err)
+		} else if stored != nil {
+			return sendIdempotentResponse(c, stored, hash)
+		}
+
+		if err := c.Next(); err != nil {
+			// Errors are rendered by the ErrorHandler afterwards and are not cached, so the client may retry
+			return err
+		}
+
+		// Only requests that took effect are cached; rejected ones (expired CSRF token, Customer.io
+		// failures) may be retried with the same key once the cause is fixed
+		status := c.Response().StatusCode()
+		if status < 200 || status >= 300 {
+			return nil
+		}
+		response := IdempotentResponse{
+			RequestHash: hash,
+			StatusCode:  status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        append([]byte(nil), c.Response().Body()...),
+		}
+		if err := saveIdempotentResponse(scopedKey, response, time.Now().Add(-ttl)); err != nil {
+			slog.Warn("Failed to store idempotency key", "path", c.Path(), "error", err)
+		}
+		return nil
+	}
+}
+
+// replayIdempotentResponse answers a duplicate that waited for the original request with the original's
+// stored response. If none was stored (the original failed), it is rejected with 409 so the client can retry.
+func replayIdempotentResponse(c *fiber.Ctx, scopedKey, hash string, ttl time.Duration) error {
+	stored, err := getIdempotentResponse(scopedKey, time.Now().Add(-ttl))
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return fiber.NewError(fiber.StatusConflict, "The original request with this Idempotency-Key did not complete; please retry")
+	}
+	return sendIdempotentResponse(c, stored, hash)
+}
+
+// sendIdempotentResponse replays a stored response, refusing a key reused for a different request body
+func sendIdempotentResponse(c *fiber.Ctx, stored *IdempotentResponse, hash string) error {
+	if stored.RequestHash != hash {
+		slog.Warn("Rejected Idempotency-Key reused with a different request body", "path", c.Path(), "ip", clientIP(c))
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+	}
+
+	slog.Info("Replayed response for repeated Idempotency-Key", "path", c.Path(), "status_code", stored.StatusCode)
+	c.Set(idempotencyReplayedHeader, "true")
+	if stored.ContentType != "" {
+		c.Set(fiber.HeaderContentType, stored.ContentType)
+	}
+	return c.Status(stored.StatusCode).Send(stored.Body)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Development log file defaults (see LOG_FILE, LOG_MAX_SIZE_MB and LOG_MAX_BACKUPS)
+const (
+	defaultLogFile       = "app.log"
+	defaultLogMaxSizeMB  = 50 // Size at which the log file is rotated
+	defaultLogMaxBackups = 3  // Rotated files kept before the oldest is deleted
+)
+
+// logLevel is the minimum level emitted by the structured logger (see LOG_LEVEL)
+var logLevel = new(slog.LevelVar)
+
+// newRotatingLogFile opens the development log file (LOG_FILE, default app.log), which is rotated once it
+// reaches LOG_MAX_SIZE_MB, keeping LOG_MAX_BACKUPS old files. The file is opened up front so an unwritable
+// path is reported at startup rather than silently dropping logs.
+func newRotatingLogFile() (*lumberjack.Logger, error) {
+	path := os.Getenv("LOG_FILE")
+	if path == "" {
+		path = defaultLogFile
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    positiveIntFromEnv("LOG_MAX_SIZE_MB", defaultLogMaxSizeMB),
+		MaxBackups: positiveIntFromEnv("LOG_MAX_BACKUPS", defaultLogMaxBackups),
+	}, nil
+}
+
+// configureStructuredLogging installs the default slog logger writing to w.
+// Production emits JSON for fly.io log aggregation; development emits human-readable text.
+// Plain log.Printf calls are routed through the same handler at INFO level.
+func configureStructuredLogging(w io.Writer) {
+	// Verbose by default locally, quieter in production
+	level := slog.LevelDebug
+	if isProduction() {
+		level = slog.LevelInfo
+	}
+
+	if levelStr := os.Getenv("LOG_LEVEL"); levelStr != "" {
+		if err := level.UnmarshalText([]byte(strings.ToUpper(levelStr))); err != nil {
+			log.Printf("WARNING: Invalid LOG_LEVEL '%s', using %s", levelStr, level)
+		}
+	}
+	logLevel.Set(level)
+
+	opts := &slog.HandlerOptions{
+		Level:       logLevel,
+		AddSource:   true,
+		ReplaceAttr: shortenSourceAttr,
+	}
+
+	var handler slog.Handler
+	if isProduction() {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// shortenSourceAttr reduces the source attribute to file:line, matching the old log.Lshortfile output
+func shortenSourceAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.SourceKey {
+		return a
+	}
+	if source, ok := a.Value.Any().(*slog.Source); ok {
+		return slog.String(slog.SourceKey, filepath.Base(source.File)+":"+strconv.Itoa(source.Line))
+	}
+	return a
+}
@@ -1,29 +1,65 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/template/html/v2"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	customerIOSiteID string // Customer.io Site ID for Track API
-	customerIOAPIKey string // Customer.io API Key for Track API
-	adminUsername    string // Admin username for /results authentication
-	adminPassword    string // Admin password for /results authentication
+	adminUsername     string // Admin username for /results authentication
+	adminPassword     string // Admin password for /results authentication
+	adminPasswordHash []byte // Optional bcrypt hash of the admin password (takes precedence over adminPassword)
+	logEmailMode      string // How emails appear in logs: full, masked, hashed or none
+	debugPayloads     bool   // Log full Track API request/response bodies (DEBUG_PAYLOADS)
+	identifyAnonymous bool   // Identify anonymous Customer.io profiles before retrying updates
+
+	requireExistingCustomer bool // Look the customer up before mutating so typos don't create profiles (REQUIRE_EXISTING_CUSTOMER)
+
+	preferencesUpdatedEvent = defaultPreferencesUpdatedEvent // Event sent after a preference update; empty disables it (PREFERENCES_UPDATED_EVENT)
+
+	adminUsers map[string][]byte // Per-person admin accounts: username -> bcrypt hash (ADMIN_USERS)
+
+	maskedEmailAdmins map[string]bool // Admin usernames that only see masked customer emails (ADMIN_MASKED_USERS)
+
+	regionObjectIDs  map[string]bool // Object IDs that action=region may move customers between (REGION_OBJECT_IDS)
+	subscriptionKeys []string        // Brand subscription attribute keys (SUBSCRIPTION_KEYS)
+
+	actionIdempotencyWindow = 10 * time.Minute // Repeats of a successful link action within this window skip Customer.io (0 disables)
+)
+
+const (
+	// Region lists allowed for action=region unless REGION_OBJECT_IDS is set
+	defaultRegionObjectIDs = "BBUS,BBAU,BBUK,BBNZ"
+	// Brand subscription attributes unless SUBSCRIPTION_KEYS is set
+	defaultSubscriptionKeys = "sub_bbau,sub_bbus,sub_csau,sub_csus,sub_ffau,sub_ffus,sub_sbau,sub_ppau"
+	// Customer.io event sent after a preference update unless PREFERENCES_UPDATED_EVENT is set
+	defaultPreferencesUpdatedEvent = "preferences_updated"
 )
 
 // isProduction checks if the application is running in production environment
@@ -43,8 +79,8 @@ func setupLogging() error {
 
 	if isProduction() {
 		// In production, log to stdout for fly.io log aggregation
-		log.SetOutput(os.Stdout)
-		log.Println("Production environment detected - logging to stdout")
+		configureStructuredLogging(os.Stdout)
+		slog.Info("Production environment detected - logging to stdout", "level", logLevel.Level().String())
 		return nil
 	}
 
@@ -52,24 +88,45 @@ func setupLogging() error {
 	logToFile := os.Getenv("LOG_TO_FILE")
 	if logToFile == "false" {
 		// Log to stdout in development if explicitly disabled
-		log.SetOutput(os.Stdout)
-		log.Println("Development environment - logging to stdout (LOG_TO_FILE=false)")
+		configureStructuredLogging(os.Stdout)
+		slog.Info("Development environment - logging to stdout (LOG_TO_FILE=false)", "level", logLevel.Level().String())
 		return nil
 	}
 
-	// Default development behavior - log to file
-	logFile, err := os.OpenFile("app.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	// Default development behavior - log to a size-rotated file
+	logFile, err := newRotatingLogFile()
 	if err != nil {
-		log.Printf("ERROR: Failed to open log file, falling back to stdout: %v", err)
-		log.SetOutput(os.Stdout)
+		configureStructuredLogging(os.Stdout)
+		slog.Error("Failed to open log file, falling back to stdout", "error", err)
 		return err
 	}
 
-	log.SetOutput(logFile)
-	log.Println("Development environment - logging to app.log file")
+	configureStructuredLogging(logFile)
+	slog.Info("Development environment - logging to file", "file", logFile.Filename, "max_size_mb", logFile.MaxSize,
+		"max_backups", logFile.MaxBackups, "level", logLevel.Level().String())
 	return nil
 }
 
+// logEmail formats an email address for logging according to LOG_EMAIL_MODE
+func logEmail(email string) string {
+	switch logEmailMode {
+	case "full":
+		return email
+	case "hashed":
+		sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+		return "sha256:" + hex.EncodeToString(sum[:])[:12]
+	case "none":
+		return "[REDACTED]"
+	default:
+		// masked - keep the first character of the local part and the domain
+		at := strings.LastIndex(email, "@")
+		if at <= 0 {
+			return "***"
+		}
+		return email[:1] + "***" + email[at:]
+	}
+}
+
 // killProcessOnPort kills any existing process on the specified port (development only)
 func killProcessOnPort(port string) {
 	if isProduction() {
@@ -112,6 +169,7 @@ func main() {
 	if err := setupLogging(); err != nil {
 		log.Printf("WARNING: Logging setup encountered an error: %v", err)
 	}
+	log.Printf("Build: version %s, commit %s, built %s", version, commit, buildTime)
 
 	// Load .env file (only in development)
 	if isDevelopment() {
@@ -125,40 +183,254 @@ func main() {
 		log.Println("Production environment - skipping .env file loading")
 	}
 
+	// Fill in anything the environment doesn't set from the optional JSON config file
+	if err := loadConfigFile(); err != nil {
+		log.Fatalf("CRITICAL: %v", err)
+	}
+	if missing := missingRequiredSettings(); len(missing) > 0 {
+		log.Fatalf("CRITICAL: Missing required settings: %s. Set them as environment variables or in CONFIG_FILE.", strings.Join(missing, ", "))
+	}
+
+	// Payload logging exposes customer PII, so it is strictly opt-in
+	debugPayloads = os.Getenv("DEBUG_PAYLOADS") == "true"
+	if debugPayloads {
+		log.Println("WARNING: DEBUG_PAYLOADS enabled - Track API payloads will be logged.")
+	}
+
+	// Configure how customer emails appear in logs
+	logEmailMode = strings.ToLower(os.Getenv("LOG_EMAIL_MODE"))
+	switch logEmailMode {
+	case "full", "masked", "hashed", "none":
+	case "":
+		// Emails are only logged in full alongside debug payloads
+		if debugPayloads {
+			logEmailMode = "full"
+		} else {
+			logEmailMode = "masked"
+		}
+	default:
+		log.Printf("WARNING: Invalid LOG_EMAIL_MODE '%s', defaulting to masked", logEmailMode)
+		logEmailMode = "masked"
+	}
+	log.Printf("Email log mode: %s", logEmailMode)
+
 	// Load Customer.io Track API credentials
-	customerIOSiteID = os.Getenv("CUSTOMERIO_SITE_ID")
-	customerIOAPIKey = os.Getenv("CUSTOMERIO_API_KEY")
-	if customerIOSiteID == "" {
-		log.Fatalln("CRITICAL: CUSTOMERIO_SITE_ID not set in environment variables.")
+	customerIOSiteID := os.Getenv("CUSTOMERIO_SITE_ID")
+	customerIOAPIKey := os.Getenv("CUSTOMERIO_API_KEY")
+	// Track API host - override for the EU region (https://track-eu.customer.io) or a mock server
+	customerIOTrackURL := strings.TrimRight(os.Getenv("CUSTOMERIO_TRACK_URL"), "/")
+	if customerIOTrackURL == "" {
+		customerIOTrackURL = defaultCustomerIOTrackURL
+	}
+	log.Printf("Customer.io Track API host: %s", customerIOTrackURL)
+
+	// Upper bound on each Track API request so slow upstream calls cannot hang handlers
+	customerIOTimeout := defaultCustomerIOTimeout
+	if timeoutStr := os.Getenv("CUSTOMERIO_TIMEOUT_SECONDS"); timeoutStr != "" {
+		timeoutSeconds, err := strconv.Atoi(timeoutStr)
+		if err != nil || timeoutSeconds <= 0 {
+			log.Printf("WARNING: Invalid CUSTOMERIO_TIMEOUT_SECONDS '%s', using default %s", timeoutStr, defaultCustomerIOTimeout)
+		} else {
+			customerIOTimeout = time.Duration(timeoutSeconds) * time.Second
+		}
+	}
+	log.Printf("Customer.io Track API timeout: %s", customerIOTimeout)
+
+	configureTransport()
+	customerIO = NewCustomerIOClient(customerIOSiteID, customerIOAPIKey, customerIOTrackURL, customerIOTimeout)
+	if userAgent := os.Getenv("CUSTOMERIO_USER_AGENT"); userAgent != "" {
+		customerIO.UserAgent = userAgent
+	}
+	log.Printf("Customer.io User-Agent: %s", customerIO.UserAgent)
+
+	// Object type used for brand relationships (workspaces differ)
+	if objectTypeID := os.Getenv("CUSTOMERIO_OBJECT_TYPE_ID"); objectTypeID != "" {
+		customerIO.ObjectTypeID = objectTypeID
+	}
+	log.Printf("Customer.io relationship object type ID: %s", customerIO.ObjectTypeID)
+
+	// Dry-run mode logs every Track API mutation instead of sending it
+	customerIO.DryRun = os.Getenv("DRY_RUN") == "true"
+	if customerIO.DryRun {
+		log.Println("WARNING: DRY_RUN enabled - Customer.io mutations will be logged but not sent.")
+	}
+
+	// Region lists that action=region may move customers between
+	regionList := os.Getenv("REGION_OBJECT_IDS")
+	if regionList == "" {
+		regionList = defaultRegionObjectIDs
 	}
-	if customerIOAPIKey == "" {
-		log.Fatalln("CRITICAL: CUSTOMERIO_API_KEY not set in environment variables.")
+	regionObjectIDs = make(map[string]bool)
+	for _, region := range strings.Split(regionList, ",") {
+		if region = strings.ToUpper(strings.TrimSpace(region)); region != "" {
+			regionObjectIDs[region] = true
+		}
 	}
+	log.Printf("Region moves allowed between: %s", regionList)
+	configureSubscriptionKeys()
 	log.Println("Customer.io Track API credentials loaded.")
+	configureRetries()
+	configureCustomerIOConcurrency()
+	configureBulk()
+	configureWebhook()
 
-	// Load admin credentials
+	identifyAnonymous = os.Getenv("CUSTOMERIO_IDENTIFY_ANONYMOUS") == "true"
+	if identifyAnonymous {
+		log.Println("Anonymous Customer.io profiles will be identified before retrying updates.")
+	}
+
+	// Profile lookups go through the App API, which has its own key and regional host
+	customerIO.AppAPIKey = os.Getenv("CUSTOMERIO_APP_API_KEY")
+	if appURL := strings.TrimRight(os.Getenv("CUSTOMERIO_APP_URL"), "/"); appURL != "" {
+		customerIO.AppBaseURL = appURL
+	}
+	requireExistingCustomer = os.Getenv("REQUIRE_EXISTING_CUSTOMER") == "true"
+	if requireExistingCustomer {
+		log.Printf("Customers must already exist in Customer.io before actions apply (lookups via %s).", customerIO.AppBaseURL)
+	}
+	runStartupHealthcheck()
+
+	// Event that lets a Customer.io campaign confirm preference changes; set it empty to stop sending
+	if eventName, ok := os.LookupEnv("PREFERENCES_UPDATED_EVENT"); ok {
+		preferencesUpdatedEvent = strings.TrimSpace(eventName)
+	}
+	if preferencesUpdatedEvent == "" {
+		log.Println("Preference update events disabled (PREFERENCES_UPDATED_EVENT is empty).")
+	} else {
+		log.Printf("Preference updates will send the Customer.io event %q.", preferencesUpdatedEvent)
+	}
+
+	// Load per-person admin accounts, so one person can be revoked without rotating everyone's password
+	users, err := parseAdminUsers(os.Getenv("ADMIN_USERS"))
+	if err != nil {
+		log.Fatalf("CRITICAL: Invalid ADMIN_USERS: %v", err)
+	}
+	adminUsers = users
+	if len(adminUsers) > 0 {
+		log.Printf("Loaded %d admin users from ADMIN_USERS.", len(adminUsers))
+	}
+
+	// Accounts such as support staff that may browse the results without seeing full email addresses
+	maskedEmailAdmins = make(map[string]bool)
+	for _, user := range strings.Split(os.Getenv("ADMIN_MASKED_USERS"), ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			maskedEmailAdmins[user] = true
+		}
+	}
+	if len(maskedEmailAdmins) > 0 {
+		log.Printf("%d admin users will only see masked emails (ADMIN_MASKED_USERS).", len(maskedEmailAdmins))
+	}
+
+	// Load the shared admin credentials (optional when ADMIN_USERS is set)
 	adminUsername = os.Getenv("ADMIN_USERNAME")
 	adminPassword = os.Getenv("ADMIN_PASSWORD")
-	if adminUsername == "" {
-		log.Fatalln("CRITICAL: ADMIN_USERNAME not set in environment variables.")
+	if adminUsername == "" && len(adminUsers) == 0 {
+		log.Fatalln("CRITICAL: Neither ADMIN_USERNAME nor ADMIN_USERS set in environment variables.")
 	}
-	if adminPassword == "" {
-		log.Fatalln("CRITICAL: ADMIN_PASSWORD not set in environment variables.")
+
+	// A bcrypt hash takes precedence over the plaintext password when both are set
+	if adminUsername == "" {
+		log.Println("Shared admin account disabled (ADMIN_USERNAME not set).")
+	} else if passwordHash := os.Getenv("ADMIN_PASSWORD_BCRYPT"); passwordHash != "" {
+		if _, err := bcrypt.Cost([]byte(passwordHash)); err != nil {
+			log.Fatalf("CRITICAL: ADMIN_PASSWORD_BCRYPT is not a valid bcrypt hash: %v", err)
+		}
+		adminPasswordHash = []byte(passwordHash)
+		log.Println("Admin password will be verified against ADMIN_PASSWORD_BCRYPT.")
 	}
 	log.Println("Admin credentials loaded.")
 
-	// Initialize database
+	// Configure optional signing of customer links
+	configureLinkSigning()
+	configureCSRF()
+
+	// Optional redirect to a branded page after link actions
+	configureActionRedirect()
+
+	// Records are stored and shown in this timezone
+	configureDisplayTimezone()
+
+	// Initialize database. With DB_OPTIONAL=true a failure (e.g. the volume isn't mounted yet) only disables
+	// recording, so unsubscribes keep reaching Customer.io during a storage incident.
 	if err := initDatabase(); err != nil {
-		log.Fatalf("CRITICAL: Failed to initialize database: %v", err)
+		if os.Getenv("DB_OPTIONAL") != "true" {
+			log.Fatalf("CRITICAL: Failed to initialize database: %v", err)
+		}
+		databaseDisabled = true
+		log.Printf("WARNING: Failed to initialize database, continuing without it because DB_OPTIONAL is set - actions will not be recorded and grace-period unsubscribes apply immediately: %v", err)
+	} else {
+		log.Println("Database initialization completed.")
+	}
+
+	// Optional grace period before unsubscribes are committed to Customer.io
+	if graceStr := os.Getenv("UNSUBSCRIBE_GRACE_MINUTES"); graceStr != "" {
+		graceMinutes, err := strconv.Atoi(graceStr)
+		if err != nil || graceMinutes < 0 {
+			log.Printf("WARNING: Invalid UNSUBSCRIBE_GRACE_MINUTES '%s', grace period disabled", graceStr)
+		} else {
+			unsubscribeGraceMinutes = graceMinutes
+		}
+	}
+	if unsubscribeGraceMinutes > 0 {
+		log.Printf("Unsubscribe grace period enabled: %d minutes", unsubscribeGraceMinutes)
+	}
+	// Window in which a repeated link action (mail scanner prefetch, double-click) is treated as already done
+	if windowStr := os.Getenv("ACTION_IDEMPOTENCY_WINDOW_MINUTES"); windowStr != "" {
+		windowMinutes, err := strconv.Atoi(windowStr)
+		if err != nil || windowMinutes < 0 {
+			log.Printf("WARNING: Invalid ACTION_IDEMPOTENCY_WINDOW_MINUTES '%s', using default %s", windowStr, actionIdempotencyWindow)
+		} else {
+			actionIdempotencyWindow = time.Duration(windowMinutes) * time.Minute
+		}
+	}
+	log.Printf("Action idempotency window: %s", actionIdempotencyWindow)
+
+	// Always run the scheduler so actions queued before a restart are still committed; without a
+	// database there is no queue to poll
+	stopPendingActionScheduler := func() {}
+	if !databaseDisabled {
+		stopPendingActionScheduler = startPendingActionScheduler()
 	}
-	log.Println("Database initialization completed.")
 
-	engine := html.New("./views", ".html")
+	engine, err := loadViews()
+	if err != nil {
+		log.Fatalf("CRITICAL: %v", err)
+	}
 	app := fiber.New(fiber.Config{
-		Views: engine,
+		Views:        engine,
+		ErrorHandler: handleError,
+		BodyLimit:    maxRequestBodyBytes(),
 	})
 	log.Println("Fiber app instance created with HTML template engine.")
 
+	// Tag each request with an ID for the access log, error pages and support reports
+	app.Use(requestIDMiddleware())
+
+	// One access log line per request, registered first so it times the whole handler chain
+	app.Use(accessLogMiddleware())
+
+	// A panicking handler becomes a logged 500 instead of taking the server down
+	app.Use(recoverMiddleware())
+
+	// Serve embedded static assets and choose between external and embedded UI assets
+	configureAssets(app)
+
+	// Per-IP rate limits; /ping is exempt so liveness probes never get throttled, and /health has its own
+	// limit well above any probe interval
+	publicRateLimit := newRateLimiter("public", rateLimitFromEnv("RATE_LIMIT_PER_MINUTE", defaultRateLimitPerMinute))
+	adminRateLimit := newRateLimiter("admin", rateLimitFromEnv("ADMIN_RATE_LIMIT_PER_MINUTE", defaultAdminRateLimitPerMinute))
+	healthRateLimit := newRateLimiter("health", rateLimitFromEnv("HEALTH_RATE_LIMIT_PER_MINUTE", defaultHealthRateLimit))
+
+	// Compression for the large admin pages and exports (not the live stream)
+	compressResponse := newCompressionMiddleware()
+
+	// Tighter body limits than the app-wide BodyLimit for the public POST routes and bulk batches
+	publicBodyLimit := limitBody(publicMaxBodyBytes)
+	bulkBodyLimit := limitBody(bulkMaxBodyBytes())
+
+	// Idempotency-Key support so a double-submitted preference change reaches Customer.io once
+	subscriptionIdempotency := newIdempotencyMiddleware()
+
 	// Test route
 	app.Get("/ping", func(c *fiber.Ctx) error {
 		log.Println("GET /ping request received.")
@@ -166,132 +438,246 @@ func main() {
 	})
 	log.Println("GET /ping route registered.")
 
-	app.Get("/", func(c *fiber.Ctx) error {
-		log.Printf("GET / request received. Path: %s, Query: %s", c.Path(), c.Request().URI().QueryString())
+	// Readiness probe checking database and Customer.io connectivity
+	app.Get("/health", healthRateLimit, handleHealth)
+	log.Println("GET /health route registered.")
+
+	// Build information of the running binary
+	app.Get("/version", handleVersion)
+	log.Println("GET /version route registered.")
+
+	// Link QA: reports what a customer link points to without performing the action
+	app.Get("/verify", publicRateLimit, handleVerifyLink)
+	log.Println("GET /verify route registered.")
+
+	app.Get("/", publicRateLimit, func(c *fiber.Ctx) error {
+		slog.Debug("GET / request received", "path", c.Path())
 		email := c.Query("email")
 		cioID := c.Query("cio")
 		action := c.Query("action")
-		message := ""
-		success := false
 
-		log.Printf("Extracted parameters - Email: '%s', CIO_ID: '%s', Action: '%s'", email, cioID, action)
+		// A signed action token carries the email and action so neither can be edited in the URL
+		tokenVerified := false
+		if token := c.Query("token"); token != "" {
+			tokenEmail, tokenAction, err := verifyActionToken(token)
+			if errors.Is(err, errActionTokenExpired) {
+				slog.Info("Rejected expired action token", "ip", c.IP(), "error", err)
+				return respondLinkError(c, 410, action, "This link has expired. Please request a new one or use the link from your most recent email.")
+			}
+			if err != nil {
+				slog.Warn("Rejected invalid action token", "ip", c.IP(), "error", err)
+				return respondLinkError(c, 403, action, "This link is invalid. Please use the link from your most recent email.")
+			}
+			email, cioID, action = tokenEmail, "", tokenAction
+			if action == actionTokenPreferences {
+				action = ""
+			}
+			tokenVerified = true
+		} else if (email != "" || cioID != "") && !allowLegacyEmailLinks {
+			slog.Warn("Rejected legacy link without action token", "ip", c.IP())
+			return respondLinkError(c, 403, action, "This link is no longer supported. Please use the link from your most recent email.")
+		}
+
+		slog.Debug("Extracted request parameters", "email", logEmail(email), "cio_id", cioID, "action", action, "token", tokenVerified)
+
+		// Validate and normalize the email before it reaches Customer.io
+		if email != "" {
+			normalizedEmail, err := validateEmail(email)
+			if err != nil {
+				slog.Warn("Rejected invalid email parameter", "ip", c.IP(), "error", err)
+				return respondLinkError(c, 400, action, "Please provide a valid email address.")
+			}
+			email = normalizedEmail
+		} else if cioID != "" {
+			normalizedID, err := validateCustomerID(cioID)
+			if err != nil {
+				slog.Warn("Rejected invalid customer ID parameter", "ip", c.IP(), "error", err)
+				return respondLinkError(c, 400, action, "Please provide a valid customer ID.")
+			}
+			cioID = normalizedID
+		}
+
+		// Reject customer links that were not signed by us (see LINK_SIGNING_SECRET)
+		identifier := email
+		if identifier == "" {
+			identifier = cioID
+		}
+		if identifier != "" && !tokenVerified && !checkLinkSignature(identifier, c.Query("sig"), c.IP()) {
+			return respondLinkError(c, 403, action, "This link is invalid. Please use the link from your most recent email.")
+		}
+
+		// Link actions change the customer's profile, so the GET only asks for confirmation and the
+		// change happens on POST /confirm (link prefetchers never submit it). immediate=true keeps
+		// the old act-on-GET behavior for automation.
+		if ((email != "" && action != "") || (email == "" && cioID != "")) && c.Query("immediate") != "true" {
+			if wantsJSON(c) {
+				return respondLinkError(c, 400, action, "Confirmation required: add immediate=true to apply the action directly.")
+			}
+			return renderActionConfirmation(c, email, cioID, action)
+		}
 
 		// Handle different actions when email is provided
+		var outcome linkActionOutcome
 		if email != "" {
 			if action != "" {
-				log.Printf("Processing action '%s' for email: %s", action, email)
-
-				switch action {
-				case "pause":
-					err := updateCustomerPausedAttributeByEmail(email)
-					if err != nil {
-						log.Printf("Error updating 'paused' attribute for email %s: %v", email, err)
-						message = "Error processing pause request. Check logs."
-					} else {
-						message = fmt.Sprintf("Customer (%s) has been paused.", email)
-						success = true
-						log.Printf("Successfully updated 'paused' attribute for email %s", email)
-
-						// Log to database
-						if dbErr := insertEmailProcessingRecord(email, "pause"); dbErr != nil {
-							log.Printf("WARNING: Failed to log pause action to database for email %s: %v", email, dbErr)
-						}
-					}
-				case "international":
-					err := updateCustomerRelationshipByEmail(email, "BBAU")
-					if err != nil {
-						log.Printf("Error updating relationship to BBAU for email %s: %v", email, err)
-						message = "Error processing international request. Check logs."
-					} else {
-						message = fmt.Sprintf("Customer (%s) moved to Australian/International list.", email)
-						success = true
-						log.Printf("Successfully updated relationship to BBAU for email %s", email)
-
-						// Log to database
-						if dbErr := insertEmailProcessingRecord(email, "international"); dbErr != nil {
-							log.Printf("WARNING: Failed to log international action to database for email %s: %v", email, dbErr)
-						}
-					}
-				case "unsubscribe":
-					err := unsubscribeCustomerByEmail(email)
-					if err != nil {
-						log.Printf("Error unsubscribing email %s: %v", email, err)
-						message = "Error processing unsubscribe request. Check logs."
-					} else {
-						message = fmt.Sprintf("Customer (%s) has been unsubscribed.", email)
-						success = true
-						log.Printf("Successfully unsubscribed email %s", email)
-
-						// Log to database
-						if dbErr := insertEmailProcessingRecord(email, "unsubscribe"); dbErr != nil {
-							log.Printf("WARNING: Failed to log unsubscribe action to database for email %s: %v", email, dbErr)
-						}
-					}
-				case "unpause":
-					err := updateCustomerUnpausedAttributeByEmail(email)
-					if err != nil {
-						log.Printf("Error updating 'paused' attribute to false for email %s: %v", email, err)
-						message = "Error processing unpause request. Check logs."
-					} else {
-						message = fmt.Sprintf("Customer (%s) has been unpaused.", email)
-						success = true
-						log.Printf("Successfully updated 'paused' attribute to false for email %s", email)
-					}
-				default:
-					log.Printf("Unknown action '%s' for email %s", action, email)
-					message = "Unknown action requested."
-				}
+				outcome = performLinkAction(c.Context(), email, action, c.Query("from"), c.Query("to"))
 			} else {
 				// No action specified, just show the interface
-				log.Printf("Email provided (%s) but no action specified. Showing interface.", email)
+				slog.Debug("Email provided but no action specified, showing interface", "email", logEmail(email))
 			}
 		} else if cioID != "" {
-			// Backward compatibility for customer ID-based requests
-			log.Printf("CIO_ID extracted: %s. Using customer ID as identifier.", cioID)
+			// Customer IDs go through the same action helpers as emails; bare cio= links predate
+			// the action parameter and always pause
+			if action == "" {
+				action = "pause"
+			}
+			outcome = performLinkAction(c.Context(), cioID, action, c.Query("from"), c.Query("to"))
+		}
 
-			err := updateCustomerPausedAttribute(cioID)
-			if err != nil {
-				log.Printf("Error updating 'paused' attribute for cio_id %s: %v", cioID, err)
-				message = "Error processing request. Check logs."
-			} else {
-				message = fmt.Sprintf("Customer (ID: %s) has been paused.", cioID)
-				success = true
-				log.Printf("Successfully updated 'paused' attribute for cio_id %s. Message: %s", cioID, message)
+		if outcome.Message != "" {
+			slog.Debug("Message displayed", "email", logEmail(email), "action", action, "success", outcome.Success)
+		}
+
+		// API-style callers get the outcome as JSON, with a 4xx/5xx status on failure
+		if wantsJSON(c) && outcome.Status != 0 {
+			if !outcome.Success {
+				return respondLinkError(c, outcome.Status, action, actionErrorSummary(outcome.Err))
 			}
+			return c.Status(outcome.Status).JSON(fiber.Map{
+				"success": true,
+				"action":  action,
+				"message": outcome.Message,
+			})
+		}
+
+		// Campaigns can send the customer on to a branded page instead of the inline confirmation
+		locale := requestLocale(c)
+		if outcome.Status != 0 {
+			if target := actionRedirectTarget(c.Query("redirect")); target != nil {
+				return redirectWithOutcome(c, target, locale, action, outcome)
+			}
+		}
+
+		// Minimal mode renders a stripped-down confirmation for constrained webviews
+		template := "index"
+		if c.Query("minimal") == "true" {
+			template = "minimal"
 		}
 
-		if message != "" {
-			log.Printf("Message to be displayed: %s. Success: %t", message, success)
+		// The preference page POSTs back to us, so hand it a CSRF token for this email
+		csrfToken := ""
+		if email != "" && template == "index" {
+			token, err := issueCSRFToken(c, email)
+			if err != nil {
+				slog.Error("Failed to issue CSRF token", "email", logEmail(email), "error", err)
+				return fiber.NewError(fiber.StatusInternalServerError, "We couldn't load your preferences. Please try again.")
+			}
+			csrfToken = token
 		}
 
-		return c.Render("index", fiber.Map{
-			"Message": message,
-			"Success": success,
-			"CioID":   cioID,
-			"Action":  action,
+		return c.Render(template, fiber.Map{
+			"Message":   outcome.localizedMessage(locale),
+			"Success":   outcome.Success,
+			"CioID":     cioID,
+			"Action":    action,
+			"CancelURL": outcome.CancelURL,
+			"CSRFToken": csrfToken,
+			"Email":     email,
+			"Locale":    locale,
 		})
 	})
 	log.Println("GET / route registered.")
 
+	// Applies a link action after the customer confirms it (GET / only renders the confirmation)
+	app.Post("/confirm", publicRateLimit, publicBodyLimit, handleConfirmAction)
+	log.Println("POST /confirm route registered.")
+
 	// New subscription management endpoints
-	app.Post("/update-subscriptions", handleUpdateSubscriptions)
+	app.Post("/update-subscriptions", publicRateLimit, publicBodyLimit, subscriptionIdempotency, handleUpdateSubscriptions)
 	log.Println("POST /update-subscriptions route registered.")
-	
-	app.Post("/unsubscribe-all", handleUnsubscribeAll)
+
+	// Current subscription states, so the preference page starts from what Customer.io holds
+	app.Get("/preferences", publicRateLimit, handleGetPreferences)
+	log.Println("GET /preferences route registered.")
+
+	app.Post("/unsubscribe-all", publicRateLimit, publicBodyLimit, subscriptionIdempotency, handleUnsubscribeAll)
 	log.Println("POST /unsubscribe-all route registered.")
 
+	// JSON pause/unpause for app clients; the GET links stay for emails
+	app.Post("/pause", publicRateLimit, publicBodyLimit, handlePauseAction("pause"))
+	log.Println("POST /pause route registered.")
+
+	app.Post("/unpause", publicRateLimit, publicBodyLimit, handlePauseAction("unpause"))
+	log.Println("POST /unpause route registered.")
+
+	// JSON region move with an optional preview of the relationship changes, for the region-change UI
+	app.Post("/international", publicRateLimit, publicBodyLimit, handleInternationalAction)
+	log.Println("POST /international route registered.")
+
+	// RFC 8058 one-click unsubscribe (List-Unsubscribe-Post) from mail clients
+	app.Post("/unsubscribe", publicRateLimit, publicBodyLimit, handleOneClickUnsubscribe)
+	log.Println("POST /unsubscribe route registered.")
+
+	app.Get("/cancel-unsubscribe", publicRateLimit, handleCancelUnsubscribe)
+	log.Println("GET /cancel-unsubscribe route registered.")
+
 	// Protected /results route with authentication
-	app.Get("/results", basicAuthMiddleware(adminUsername, adminPassword), handleResults)
+	app.Get("/results", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), compressResponse, handleResults)
 	log.Println("GET /results route registered with authentication.")
 
+	// Protected live stream of newly recorded actions
+	app.Get("/results/stream", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), handleResultsStream)
+	log.Println("GET /results/stream route registered with authentication.")
+
 	// Protected CSV download routes
-	app.Get("/results/csv/:action", basicAuthMiddleware(adminUsername, adminPassword), handleCSVDownload)
+	app.Get("/results/csv/:action", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), compressResponse, handleCSVDownload)
 	log.Println("GET /results/csv/:action route registered with authentication.")
 
+	// Protected stats route
+	app.Get("/results/stats", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), handleStats)
+	log.Println("GET /results/stats route registered with authentication.")
+
+	// Protected full JSON export route
+	app.Get("/results/export.json", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), compressResponse, handleJSONExport)
+	log.Println("GET /results/export.json route registered with authentication.")
+
+	// Protected JSON summary route for dashboards
+	app.Get("/results.json", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), handleResultsJSON)
+	log.Println("GET /results.json route registered with authentication.")
+
+	// Protected per-interval action counts for trend charts
+	app.Get("/results/timeseries", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), handleResultsTimeSeries)
+	log.Println("GET /results/timeseries route registered with authentication.")
+
+	// Protected per-customer action timeline
+	app.Get("/results/customer/:email", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), compressResponse, handleCustomerHistory)
+	log.Println("GET /results/customer/:email route registered with authentication.")
+
+	// Protected admin audit log
+	app.Get("/results/audit", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), compressResponse, handleAdminAudit)
+	log.Println("GET /results/audit route registered with authentication.")
+
 	// Protected clear records route
-	app.Post("/results/clear", basicAuthMiddleware(adminUsername, adminPassword), handleClearRecords)
+	app.Post("/results/clear", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), requireUnmaskedAdmin, handleClearRecords)
 	log.Println("POST /results/clear route registered with authentication.")
 
+	// Protected scoped delete route (by action and/or cutoff date)
+	app.Post("/results/delete", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), requireUnmaskedAdmin, handleDeleteRecords)
+	log.Println("POST /results/delete route registered with authentication.")
+
+	// Bulk actions for support staff (requires authentication)
+	app.Post("/bulk", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), requireUnmaskedAdmin, bulkBodyLimit, handleBulkAction)
+	log.Println("POST /bulk route registered with authentication.")
+
+	// Customer.io reporting webhooks, authenticated by their HMAC signature rather than basic auth.
+	// No rate limit: Customer.io delivers from a small set of IPs and bursts during large sends.
+	app.Post("/webhooks/customerio", handleCustomerIOWebhook)
+	log.Println("POST /webhooks/customerio route registered.")
+
+	// Protected Prometheus metrics route
+	app.Get("/metrics", adminRateLimit, basicAuthMiddleware(adminUsername, adminPassword), metricsHandler())
+	log.Println("GET /metrics route registered with authentication.")
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "3000" // Default port if not specified
@@ -303,6 +689,32 @@ func main() {
 	// Kill any existing process on the port before starting (development only)
 	killProcessOnPort(port)
 
+	// How long in-flight requests get to finish after SIGINT/SIGTERM
+	shutdownTimeout := 10 * time.Second
+	if timeoutStr := os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"); timeoutStr != "" {
+		if seconds, err := strconv.Atoi(timeoutStr); err != nil || seconds <= 0 {
+			log.Printf("WARNING: Invalid SHUTDOWN_TIMEOUT_SECONDS '%s', using default %s", timeoutStr, shutdownTimeout)
+		} else {
+			shutdownTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Drain connections on SIGINT/SIGTERM (fly.io sends SIGTERM on redeploy) so the database closes cleanly
+	shutdownComplete := make(chan struct{})
+	shutdownSignals := make(chan os.Signal, 1)
+	signal.Notify(shutdownSignals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-shutdownSignals
+		log.Printf("Received %s, shutting down server (timeout %s)...", sig, shutdownTimeout)
+		broadcaster.closeAll()
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			log.Printf("WARNING: Server shutdown did not complete cleanly: %v", err)
+		} else {
+			log.Println("In-flight requests drained.")
+		}
+		close(shutdownComplete)
+	}()
+
 	log.Printf("Attempting to start server on port %s...", port)
 
 	// Log startup information based on environment
@@ -329,9 +741,13 @@ func main() {
 		}
 	}
 
-	// This line would only be reached if Listen() exits gracefully
+	// Listen returns as soon as the listener closes, so wait for in-flight requests to drain
+	<-shutdownComplete
 	log.Println("Server has shut down gracefully.")
 
+	// Stop background work before the database goes away
+	stopPendingActionScheduler()
+
 	// Close database connection on graceful shutdown
 	if closeErr := closeDatabase(); closeErr != nil {
 		log.Printf("WARNING: Failed to close database connection: %v", closeErr)
@@ -341,365 +757,105 @@ func main() {
 }
 
 // updateCustomerPausedAttributeByEmail updates the 'paused' attribute to true using email as identifier via Customer.io Track API.
-func updateCustomerPausedAttributeByEmail(email string) error {
-	return updateCustomerPausedAttributeFlexible(email, true)
+func updateCustomerPausedAttributeByEmail(ctx context.Context, email string) (TrackResult, error) {
+	return updateCustomerPausedAttributeFlexible(ctx, email, true)
 }
 
 // updateCustomerUnpausedAttributeByEmail updates the 'paused' attribute to false using email as identifier via Customer.io Track API.
-func updateCustomerUnpausedAttributeByEmail(email string) error {
-	return updateCustomerPausedAttributeFlexible(email, false)
+func updateCustomerUnpausedAttributeByEmail(ctx context.Context, email string) (TrackResult, error) {
+	return updateCustomerPausedAttributeFlexible(ctx, email, false)
 }
 
 // updateCustomerPausedAttributeFlexible updates the 'paused' attribute using email as identifier via Customer.io Track API.
-func updateCustomerPausedAttributeFlexible(email string, paused bool) error {
-	endpointURL := fmt.Sprintf("https://track.customer.io/api/v1/customers/%s", email)
-
-	// Track API uses a simple JSON payload with attributes
-	payload := map[string]interface{}{
+func updateCustomerPausedAttributeFlexible(ctx context.Context, email string, paused bool) (TrackResult, error) {
+	result, err := customerIO.UpdateAttributes(ctx, email, map[string]interface{}{
 		"paused": paused,
-	}
-
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("ERROR: Failed to marshal Track API payload for email %s: %v", email, err)
-		return fmt.Errorf("error marshalling Track API payload: %w", err)
-	}
-
-	log.Printf("DEBUG: Attempting to update customer %s via PUT to %s", email, endpointURL)
-	log.Printf("DEBUG: Request payload: %s", string(payloadBytes))
-	log.Printf("DEBUG: Using Site ID: %s, API Key: %s... (first 10 chars)", customerIOSiteID, customerIOAPIKey[:10])
-
-	req, err := http.NewRequest(http.MethodPut, endpointURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		log.Printf("ERROR: Failed to create Track API request for email %s: %v", email, err)
-		return fmt.Errorf("error creating Track API request: %w", err)
-	}
-
-	// Track API uses Basic Auth: Site ID as username, API Key as password
-	req.SetBasicAuth(customerIOSiteID, customerIOAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "CustomerIO-Pauser/1.0")
-
-	log.Printf("DEBUG: Request headers set - Content-Type: application/json, Authorization: Basic [REDACTED]")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	})
 	if err != nil {
-		log.Printf("ERROR: Failed to send Track API request for email %s: %v", email, err)
-		return fmt.Errorf("error sending Track API request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBodyBytes, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("ERROR: Failed to read Track API response body for email %s: %v", email, readErr)
-		// Continue, but log this error.
-	}
-
-	log.Printf("DEBUG: Customer.io Track API response for email %s", email)
-	log.Printf("DEBUG: Response Status: %s (%d)", resp.Status, resp.StatusCode)
-	log.Printf("DEBUG: Response Headers: %v", resp.Header)
-	log.Printf("DEBUG: Response Body: %s", string(respBodyBytes))
-
-	// Check if response indicates success
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		errMsg := fmt.Sprintf("Customer.io Track API returned non-success status for email %s: %s. Body: %s", email, resp.Status, string(respBodyBytes))
-		log.Printf("ERROR: %s", errMsg)
-		return fmt.Errorf(errMsg)
+		return result, err
 	}
 
-	log.Printf("SUCCESS: Track API request completed for email %s (status %s)", email, resp.Status)
-	log.Printf("IMPORTANT: Customer attribute 'paused' should now be visible in Customer.io dashboard")
-	log.Printf("  - Using Track API endpoint: %s", endpointURL)
-	log.Printf("  - This API directly updates customer profiles in your Customer.io workspace")
-	log.Printf("  - If attribute is still not visible, check Customer.io dashboard after 1-2 minutes")
+	slog.Info("Track API paused attribute updated", "email", logEmail(email), "paused", paused)
+	return result, nil
+}
 
-	return nil
+// moveCustomerRelationship moves a customer from one region list to another (e.g. BBUS to BBUK)
+// using the configured object type.
+func moveCustomerRelationship(ctx context.Context, email, fromObjectID, toObjectID string) (TrackResult, error) {
+	return updateCustomerRelationshipByEmail(ctx, email, "", fromObjectID, toObjectID)
 }
 
 // updateCustomerRelationshipByEmail manages customer relationships using Customer.io Track API.
-// This removes the BBUS relationship and adds the BBAU relationship for international customers.
-func updateCustomerRelationshipByEmail(email string, newObjectID string) error {
-	log.Printf("DEBUG: Starting relationship update for email %s - removing BBUS and adding %s", email, newObjectID)
+// This removes the fromObjectID relationship and adds the toObjectID relationship.
+// An empty objectTypeID uses CUSTOMERIO_OBJECT_TYPE_ID.
+func updateCustomerRelationshipByEmail(ctx context.Context, email, objectTypeID, fromObjectID, toObjectID string) (TrackResult, error) {
+	slog.Debug("Starting relationship update", "email", logEmail(email), "remove", fromObjectID, "add", toObjectID)
 
-	// First, remove the BBUS relationship
-	err := removeCustomerRelationship(email, "BBUS")
+	// First, remove the old relationship
+	result, err := removeCustomerRelationship(ctx, email, objectTypeID, fromObjectID)
 	if err != nil {
-		log.Printf("ERROR: Failed to remove BBUS relationship for email %s: %v", email, err)
-		return fmt.Errorf("error removing BBUS relationship: %w", err)
+		slog.Error("Failed to remove relationship", "email", logEmail(email), "object_id", fromObjectID, "error", err)
+		return result, fmt.Errorf("error removing %s relationship: %w", fromObjectID, err)
 	}
 
-	// Then, add the new relationship (BBAU)
-	err = createCustomerRelationship(email, newObjectID)
+	// Then, add the new relationship
+	createResult, err := createCustomerRelationship(ctx, email, objectTypeID, toObjectID)
+	result = result.then(createResult)
 	if err != nil {
-		log.Printf("ERROR: Failed to create %s relationship for email %s: %v", newObjectID, email, err)
-		return fmt.Errorf("error creating %s relationship: %w", newObjectID, err)
+		slog.Error("Failed to create relationship", "email", logEmail(email), "object_id", toObjectID, "error", err)
+		return result, fmt.Errorf("error creating %s relationship: %w", toObjectID, err)
 	}
 
-	log.Printf("SUCCESS: Relationship update completed for email %s - removed BBUS, added %s", email, newObjectID)
-	return nil
+	slog.Info("Relationship update completed", "email", logEmail(email), "removed", fromObjectID, "added", toObjectID)
+	return result, nil
 }
 
 // removeCustomerRelationship removes a relationship between customer and object using Track API
-func removeCustomerRelationship(email string, objectID string) error {
-	endpointURL := fmt.Sprintf("https://track.customer.io/api/v1/customers/%s", email)
-
-	// Use the delete_relationships action in the customer identification payload
-	payload := map[string]interface{}{
-		"cio_relationships": map[string]interface{}{
-			"action": "delete_relationships",
-			"relationships": []map[string]interface{}{
-				{
-					"identifiers": map[string]interface{}{
-						"object_type_id": "1", // Default object type ID
-						"object_id":      objectID,
-					},
-				},
-			},
-		},
-	}
-
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("ERROR: Failed to marshal relationship removal payload for email %s: %v", email, err)
-		return fmt.Errorf("error marshalling relationship removal payload: %w", err)
-	}
-
-	log.Printf("DEBUG: Attempting to remove relationship %s for customer %s via PUT to %s", objectID, email, endpointURL)
-	log.Printf("DEBUG: Request payload: %s", string(payloadBytes))
-
-	req, err := http.NewRequest(http.MethodPut, endpointURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		log.Printf("ERROR: Failed to create relationship removal request for email %s: %v", email, err)
-		return fmt.Errorf("error creating relationship removal request: %w", err)
-	}
-
-	// Track API uses Basic Auth: Site ID as username, API Key as password
-	req.SetBasicAuth(customerIOSiteID, customerIOAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "CustomerIO-Pauser/1.0")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func removeCustomerRelationship(ctx context.Context, email, objectTypeID, objectID string) (TrackResult, error) {
+	result, err := customerIO.RemoveRelationship(ctx, email, objectTypeID, objectID)
 	if err != nil {
-		log.Printf("ERROR: Failed to send relationship removal request for email %s: %v", email, err)
-		return fmt.Errorf("error sending relationship removal request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBodyBytes, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("ERROR: Failed to read relationship removal response body for email %s: %v", email, readErr)
-	}
-
-	log.Printf("DEBUG: Relationship removal response for email %s - Status: %s (%d), Body: %s", email, resp.Status, resp.StatusCode, string(respBodyBytes))
-
-	// Check if response indicates success
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		errMsg := fmt.Sprintf("Customer.io relationship removal returned non-success status for email %s: %s. Body: %s", email, resp.Status, string(respBodyBytes))
-		log.Printf("ERROR: %s", errMsg)
-		return fmt.Errorf(errMsg)
+		return result, err
 	}
 
-	log.Printf("SUCCESS: Relationship removal completed for email %s and object %s (status %s)", email, objectID, resp.Status)
-	return nil
+	slog.Debug("Relationship removed", "email", logEmail(email), "object_id", objectID)
+	return result, nil
 }
 
 // createCustomerRelationship creates a relationship between customer and object using Track API
-func createCustomerRelationship(email string, objectID string) error {
-	endpointURL := fmt.Sprintf("https://track.customer.io/api/v1/customers/%s", email)
-
-	// Use the add_relationships action in the customer identification payload
-	payload := map[string]interface{}{
-		"cio_relationships": map[string]interface{}{
-			"action": "add_relationships",
-			"relationships": []map[string]interface{}{
-				{
-					"identifiers": map[string]interface{}{
-						"object_type_id": "1", // Default object type ID
-						"object_id":      objectID,
-					},
-				},
-			},
-		},
-	}
-
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("ERROR: Failed to marshal relationship creation payload for email %s: %v", email, err)
-		return fmt.Errorf("error marshalling relationship creation payload: %w", err)
-	}
-
-	log.Printf("DEBUG: Attempting to create relationship %s for customer %s via PUT to %s", objectID, email, endpointURL)
-	log.Printf("DEBUG: Request payload: %s", string(payloadBytes))
-	log.Printf("DEBUG: Using correct Track API format with cio_relationships and add_relationships action")
-
-	req, err := http.NewRequest(http.MethodPut, endpointURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		log.Printf("ERROR: Failed to create relationship creation request for email %s: %v", email, err)
-		return fmt.Errorf("error creating relationship creation request: %w", err)
-	}
-
-	// Track API uses Basic Auth: Site ID as username, API Key as password
-	req.SetBasicAuth(customerIOSiteID, customerIOAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "CustomerIO-Pauser/1.0")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func createCustomerRelationship(ctx context.Context, email, objectTypeID, objectID string) (TrackResult, error) {
+	result, err := customerIO.AddRelationship(ctx, email, objectTypeID, objectID)
 	if err != nil {
-		log.Printf("ERROR: Failed to send relationship creation request for email %s: %v", email, err)
-		return fmt.Errorf("error sending relationship creation request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBodyBytes, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("ERROR: Failed to read relationship creation response body for email %s: %v", email, readErr)
-	}
-
-	log.Printf("DEBUG: Relationship creation response for email %s - Status: %s (%d), Body: %s", email, resp.Status, resp.StatusCode, string(respBodyBytes))
-
-	// Check if response indicates success
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		errMsg := fmt.Sprintf("Customer.io relationship creation returned non-success status for email %s: %s. Body: %s", email, resp.Status, string(respBodyBytes))
-		log.Printf("ERROR: %s", errMsg)
-		return fmt.Errorf(errMsg)
+		return result, err
 	}
 
-	log.Printf("SUCCESS: Relationship creation completed for email %s and object %s (status %s)", email, objectID, resp.Status)
-	return nil
+	slog.Debug("Relationship created", "email", logEmail(email), "object_id", objectID)
+	return result, nil
 }
 
 // unsubscribeCustomerByEmail unsubscribes a customer using email as identifier via Customer.io Track API.
-func unsubscribeCustomerByEmail(email string) error {
-	endpointURL := fmt.Sprintf("https://track.customer.io/api/v1/customers/%s", email)
-
-	// Track API uses a simple JSON payload with attributes
-	payload := map[string]interface{}{
+func unsubscribeCustomerByEmail(ctx context.Context, email string) (TrackResult, error) {
+	result, err := customerIO.UpdateAttributes(ctx, email, map[string]interface{}{
 		"unsubscribed": true,
-	}
-
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("ERROR: Failed to marshal Track API payload for email %s: %v", email, err)
-		return fmt.Errorf("error marshalling Track API payload: %w", err)
-	}
-
-	log.Printf("DEBUG: Attempting to unsubscribe customer %s via PUT to %s", email, endpointURL)
-	log.Printf("DEBUG: Request payload: %s", string(payloadBytes))
-	log.Printf("DEBUG: Using Site ID: %s, API Key: %s... (first 10 chars)", customerIOSiteID, customerIOAPIKey[:10])
-
-	req, err := http.NewRequest(http.MethodPut, endpointURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		log.Printf("ERROR: Failed to create Track API request for email %s: %v", email, err)
-		return fmt.Errorf("error creating Track API request: %w", err)
-	}
-
-	// Track API uses Basic Auth: Site ID as username, API Key as password
-	req.SetBasicAuth(customerIOSiteID, customerIOAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "CustomerIO-Pauser/1.0")
-
-	log.Printf("DEBUG: Request headers set - Content-Type: application/json, Authorization: Basic [REDACTED]")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	})
 	if err != nil {
-		log.Printf("ERROR: Failed to send Track API request for email %s: %v", email, err)
-		return fmt.Errorf("error sending Track API request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBodyBytes, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("ERROR: Failed to read Track API response body for email %s: %v", email, readErr)
-		// Continue, but log this error.
-	}
-
-	log.Printf("DEBUG: Customer.io Track API response for email %s", email)
-	log.Printf("DEBUG: Response Status: %s (%d)", resp.Status, resp.StatusCode)
-	log.Printf("DEBUG: Response Headers: %v", resp.Header)
-	log.Printf("DEBUG: Response Body: %s", string(respBodyBytes))
-
-	// Check if response indicates success
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		errMsg := fmt.Sprintf("Customer.io Track API returned non-success status for email %s: %s. Body: %s", email, resp.Status, string(respBodyBytes))
-		log.Printf("ERROR: %s", errMsg)
-		return fmt.Errorf(errMsg)
+		return result, err
 	}
 
-	log.Printf("SUCCESS: Track API unsubscribe completed for email %s (status %s)", email, resp.Status)
-	log.Printf("IMPORTANT: Customer should now be unsubscribed in Customer.io dashboard")
-
-	return nil
+	slog.Info("Track API unsubscribe completed", "email", logEmail(email))
+	return result, nil
 }
 
-// updateCustomerPausedAttribute updates the 'paused' attribute via Customer.io Track API.
-func updateCustomerPausedAttribute(userID string) error {
-	endpointURL := fmt.Sprintf("https://track.customer.io/api/v1/customers/%s", userID)
-
-	// Track API uses a simple JSON payload with attributes
-	payload := map[string]interface{}{
-		"paused": true,
-	}
-
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("ERROR: Failed to marshal Track API payload for UserID %s: %v", userID, err)
-		return fmt.Errorf("error marshalling Track API payload: %w", err)
-	}
-
-	log.Printf("DEBUG: Attempting to update customer %s via PUT to %s", userID, endpointURL)
-	log.Printf("DEBUG: Request payload: %s", string(payloadBytes))
-	log.Printf("DEBUG: Using Site ID: %s, API Key: %s... (first 10 chars)", customerIOSiteID, customerIOAPIKey[:10])
-
-	req, err := http.NewRequest(http.MethodPut, endpointURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		log.Printf("ERROR: Failed to create Track API request for UserID %s: %v", userID, err)
-		return fmt.Errorf("error creating Track API request: %w", err)
-	}
-
-	// Track API uses Basic Auth: Site ID as username, API Key as password
-	req.SetBasicAuth(customerIOSiteID, customerIOAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "CustomerIO-Pauser/1.0")
-
-	log.Printf("DEBUG: Request headers set - Content-Type: application/json, Authorization: Basic [REDACTED]")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// resubscribeCustomerByEmail reverses an unsubscribe by clearing the 'unsubscribed' attribute via Customer.io Track API.
+func resubscribeCustomerByEmail(ctx context.Context, email string) (TrackResult, error) {
+	result, err := customerIO.UpdateAttributes(ctx, email, map[string]interface{}{
+		"unsubscribed": false,
+	})
 	if err != nil {
-		log.Printf("ERROR: Failed to send Track API request for UserID %s: %v", userID, err)
-		return fmt.Errorf("error sending Track API request: %w", err)
+		return result, err
 	}
-	defer resp.Body.Close()
 
-	respBodyBytes, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("ERROR: Failed to read Track API response body for UserID %s: %v", userID, readErr)
-		// Continue, but log this error.
-	}
-
-	log.Printf("DEBUG: Customer.io Track API response for UserID %s", userID)
-	log.Printf("DEBUG: Response Status: %s (%d)", resp.Status, resp.StatusCode)
-	log.Printf("DEBUG: Response Headers: %v", resp.Header)
-	log.Printf("DEBUG: Response Body: %s", string(respBodyBytes))
-
-	// Check if response indicates success
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		errMsg := fmt.Sprintf("Customer.io Track API returned non-success status for UserID %s: %s. Body: %s", userID, resp.Status, string(respBodyBytes))
-		log.Printf("ERROR: %s", errMsg)
-		return fmt.Errorf(errMsg)
-	}
-
-	log.Printf("SUCCESS: Track API request completed for UserID %s (status %s)", userID, resp.Status)
-	log.Printf("IMPORTANT: Customer attribute 'paused' should now be visible in Customer.io dashboard")
-	log.Printf("  - Using Track API endpoint: %s", endpointURL)
-	log.Printf("  - This API directly updates customer profiles in your Customer.io workspace")
-	log.Printf("  - If attribute is still not visible, check Customer.io dashboard after 1-2 minutes")
-
-	return nil
+	slog.Info("Track API resubscribe completed", "email", logEmail(email))
+	return result, nil
 }
 
 // basicAuthMiddleware provides HTTP Basic Authentication for protected routes
@@ -735,124 +891,688 @@ func basicAuthMiddleware(username, password string) fiber.Handler {
 			return c.Status(401).SendString("Unauthorized")
 		}
 
-		// Check credentials
-		if parts[0] != username || parts[1] != password {
+		// Check credentials against the per-person accounts first, then the shared account
+		if !adminCredentialsValid(parts[0], parts[1], username, password) {
+			log.Printf("REJECTED: Admin login failed for user %q from IP %s", parts[0], c.IP())
 			c.Set("WWW-Authenticate", `Basic realm="Admin Area"`)
 			return c.Status(401).SendString("Unauthorized")
 		}
 
-		// Authentication successful, continue to next handler
+		// Authentication successful, remember who it was for audit logs
+		c.Locals(adminUserLocalsKey, parts[0])
 		return c.Next()
 	}
 }
 
-// handleResults handles the /results route with authentication and data visualization
-func handleResults(c *fiber.Ctx) error {
-	log.Printf("GET /results request received from IP: %s", c.IP())
+// parseDateRange reads the optional from/to query params (YYYY-MM-DD, display timezone)
+func parseDateRange(c *fiber.Ctx) (DateRange, error) {
+	dateRange := DateRange{From: c.Query("from"), To: c.Query("to")}
 
-	// Get summary data
-	summary, err := getActionSummary()
-	if err != nil {
-		log.Printf("ERROR: Failed to get action summary: %v", err)
-		return c.Status(500).SendString("Internal Server Error: Failed to retrieve summary data")
+	for name, value := range map[string]string{"from": dateRange.From, "to": dateRange.To} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return DateRange{}, fmt.Errorf("invalid %s date %q, expected YYYY-MM-DD", name, value)
+		}
 	}
 
-	// Ensure all action types are present in summary (default to 0 if not found)
-	if summary == nil {
-		summary = make(map[string]int)
-	}
-	if _, exists := summary["PAUSE"]; !exists {
-		summary["PAUSE"] = 0
-	}
-	if _, exists := summary["BBAU"]; !exists {
-		summary["BBAU"] = 0
-	}
-	if _, exists := summary["UNSUBSCRIBE"]; !exists {
-		summary["UNSUBSCRIBE"] = 0
+	if dateRange.From != "" && dateRange.To != "" && dateRange.From > dateRange.To {
+		return DateRange{}, fmt.Errorf("from date %s is after to date %s", dateRange.From, dateRange.To)
 	}
 
-	// Get all records for display
-	records, err := getAllRecordsForDisplay()
-	if err != nil {
-		log.Printf("ERROR: Failed to get records for display: %v", err)
-		return c.Status(500).SendString("Internal Server Error: Failed to retrieve records")
-	}
+	return dateRange, nil
+}
 
-	log.Printf("Successfully retrieved %d records and summary data for /results", len(records))
+// adminUserLocalsKey stores the authenticated admin username in the request locals
+const adminUserLocalsKey = "admin_user"
 
-	// Render the results template
-	return c.Render("results", fiber.Map{
-		"Summary": summary,
-		"Records": records,
-	})
+// adminUser returns the username that authenticated the current admin request
+func adminUser(c *fiber.Ctx) string {
+	user, _ := c.Locals(adminUserLocalsKey).(string)
+	return user
 }
 
-// handleCSVDownload handles CSV download for specific action types
-func handleCSVDownload(c *fiber.Ctx) error {
-	action := c.Params("action")
-	log.Printf("CSV download request for action: %s from IP: %s", action, c.IP())
+// adminSeesMaskedEmails reports whether the current admin is listed in ADMIN_MASKED_USERS
+func adminSeesMaskedEmails(c *fiber.Ctx) bool {
+	return maskedEmailAdmins[adminUser(c)]
+}
 
-	// Validate action type
-	validActions := map[string]bool{
-		"PAUSE":       true,
-		"BBAU":        true,
-		"UNSUBSCRIBE": true,
+// requireUnmaskedAdmin rejects admins listed in ADMIN_MASKED_USERS from routes that change records or
+// send to customers, since those act on full email addresses the admin isn't allowed to see
+func requireUnmaskedAdmin(c *fiber.Ctx) error {
+	if adminSeesMaskedEmails(c) {
+		log.Printf("REJECTED: %s %s from masked admin %q", c.Method(), c.Path(), adminUser(c))
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"message": "Not available to admins shown masked emails",
+		})
 	}
+	return c.Next()
+}
 
-	if !validActions[action] {
+// maskEmail hides most of an email address for display, keeping the first character of the local
+// part and of the domain plus the top-level domain (jane@example.com -> j***@e***.com)
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "***"
+	}
+	domain := email[at+1:]
+	masked := email[:1] + "***@" + domain[:1] + "***"
+	if dot := strings.LastIndex(domain, "."); dot > 0 {
+		masked += domain[dot:]
+	}
+	return masked
+}
+
+// maskDisplayRecords replaces the email of every record with its masked form
+func maskDisplayRecords(records []DisplayRecord) {
+	for i := range records {
+		records[i].Email = maskEmail(records[i].Email)
+	}
+}
+
+// parseAdminUsers parses ADMIN_USERS, a comma-separated list of username:bcrypt-hash pairs
+func parseAdminUsers(value string) (map[string][]byte, error) {
+	users := make(map[string][]byte)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		username, hash, found := strings.Cut(entry, ":")
+		if !found || username == "" {
+			return nil, fmt.Errorf("entry %q is not username:bcrypt-hash", entry)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("user %q does not have a valid bcrypt hash: %w", username, err)
+		}
+		if _, exists := users[username]; exists {
+			return nil, fmt.Errorf("user %q is listed more than once", username)
+		}
+		users[username] = []byte(hash)
+	}
+	return users, nil
+}
+
+// adminCredentialsValid checks a login against ADMIN_USERS, falling back to the shared
+// ADMIN_USERNAME account when the username is not one of the per-person accounts
+func adminCredentialsValid(suppliedUser, suppliedPassword, username, password string) bool {
+	if hash, ok := adminUsers[suppliedUser]; ok {
+		return bcrypt.CompareHashAndPassword(hash, []byte(suppliedPassword)) == nil
+	}
+	return username != "" && suppliedUser == username && adminPasswordMatches(suppliedPassword, password)
+}
+
+// adminPasswordMatches verifies a supplied admin password against the bcrypt hash when configured,
+// otherwise against the plaintext password
+func adminPasswordMatches(supplied, password string) bool {
+	if len(adminPasswordHash) > 0 {
+		return bcrypt.CompareHashAndPassword(adminPasswordHash, []byte(supplied)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(supplied), []byte(password)) == 1
+}
+
+const (
+	defaultResultsPageSize = 50  // Records per page on /results when pageSize is not given
+	maxResultsPageSize     = 500 // Largest pageSize accepted on /results
+)
+
+// summarizeActions returns the outcome counts of every summary action within a date range (zero for
+// actions without records) along with the totals across all actions
+func summarizeActions(dateRange DateRange) (map[string]ActionCounts, ActionCounts, error) {
+	summary, err := getActionSummary(dateRange)
+	if err != nil {
+		return nil, ActionCounts{}, err
+	}
+
+	for _, action := range recordedActions() {
+		if _, exists := summary[action]; !exists {
+			summary[action] = ActionCounts{}
+		}
+	}
+
+	var totals ActionCounts
+	for _, counts := range summary {
+		totals.Success += counts.Success
+		totals.Failed += counts.Failed
+	}
+	return summary, totals, nil
+}
+
+// handleResults handles the /results route with authentication and data visualization
+func handleResults(c *fiber.Ctx) error {
+	log.Printf("GET /results request received from IP: %s", c.IP())
+
+	// Optional date range filter
+	dateRange, err := parseDateRange(c)
+	if err != nil {
+		log.Printf("ERROR: Invalid date range for /results: %v", err)
+		return c.Status(400).SendString(fmt.Sprintf("Bad Request: %v", err))
+	}
+
+	// Read pagination parameters, falling back to sensible defaults
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	pageSize := c.QueryInt("pageSize", defaultResultsPageSize)
+	if pageSize < 1 || pageSize > maxResultsPageSize {
+		pageSize = defaultResultsPageSize
+	}
+
+	// Optional partial email match for looking up one customer's records. Masked admins must give a
+	// full address, so partial searches can't reveal the addresses hidden from them.
+	emailSearch := strings.TrimSpace(c.Query("email"))
+	maskEmails := adminSeesMaskedEmails(c)
+	if maskEmails && emailSearch != "" {
+		normalized, err := validateEmail(emailSearch)
+		if err != nil {
+			return c.Status(400).SendString("Bad Request: search by a full email address")
+		}
+		emailSearch = normalized
+	}
+
+	// Skip the full-table queries and rendering when nothing has changed since the client's copy
+	fingerprint, err := getRecordsFingerprint()
+	if err != nil {
+		log.Printf("ERROR: Failed to fingerprint records for /results: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to retrieve records")
+	}
+	etag := resultsETag(fingerprint, dateRange, page, pageSize, emailSearch, maskEmails)
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		log.Printf("/results unchanged since the client's copy, returning 304")
+		recordAdminAction(c, "view", c.OriginalURL())
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	// Get summary data
+	summary, totals, err := summarizeActions(dateRange)
+	if err != nil {
+		log.Printf("ERROR: Failed to get action summary: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to retrieve summary data")
+	}
+
+	// Get the requested page of records for display
+	records, totalRecords, err := getRecordsPaginated(pageSize, (page-1)*pageSize, dateRange, emailSearch, maskEmails)
+	if err != nil {
+		log.Printf("ERROR: Failed to get records for display: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to retrieve records")
+	}
+
+	totalPages := (totalRecords + pageSize - 1) / pageSize
+	if totalPages < 1 {
+		totalPages = 1
+	}
+
+	log.Printf("Successfully retrieved %d of %d records (page %d of %d) and summary data for /results", len(records), totalRecords, page, totalPages)
+	recordAdminAction(c, "view", c.OriginalURL())
+
+	if maskEmails {
+		maskDisplayRecords(records)
+	}
+
+	// Render the results template
+	return c.Render("results", fiber.Map{
+		"Summary":        summary,
+		"Totals":         totals,
+		"Records":        records,
+		"ExternalAssets": externalAssets,
+		"TotalRecords":   totalRecords,
+		"Page":           page,
+		"PageSize":       pageSize,
+		"TotalPages":     totalPages,
+		"HasPrev":        page > 1,
+		"HasNext":        page < totalPages,
+		"PrevPage":       page - 1,
+		"NextPage":       page + 1,
+		"From":           dateRange.From,
+		"To":             dateRange.To,
+		"EmailSearch":    emailSearch,
+		"MaskEmails":     maskEmails,
+	})
+}
+
+// handleResultsTimeSeries returns successful records per day, week or month as [{date, count}] for
+// charting trends, for one action (?action=UNSUBSCRIBE or unsubscribe) or all, honoring the from/to date range
+func handleResultsTimeSeries(c *fiber.Ctx) error {
+	log.Printf("GET /results/timeseries request received from IP: %s", c.IP())
+
+	dateRange, err := parseDateRange(c)
+	if err != nil {
+		log.Printf("ERROR: Invalid date range for /results/timeseries: %v", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	interval := strings.ToLower(c.Query("interval", "day"))
+	if _, ok := timeSeriesBuckets[interval]; !ok {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": fmt.Sprintf("invalid interval %q, expected day, week or month", interval),
+		})
+	}
+
+	// Accept the recorded action name (UNSUBSCRIBE) as well as the request action (unsubscribe)
+	action := c.Query("action")
+	if action != "" && !isRecordedAction(strings.ToUpper(action)) {
+		dbAction, err := dbActionName(strings.ToLower(action))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"message": err.Error(),
+			})
+		}
+		action = dbAction
+	}
+	action = strings.ToUpper(action)
+
+	points, err := getActionTimeSeries(action, interval, dateRange)
+	if err != nil {
+		log.Printf("ERROR: Failed to get time series: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to retrieve time series",
+		})
+	}
+	return c.JSON(points)
+}
+
+// resultsETag identifies one rendering of /results: the records fingerprint plus everything else the page
+// depends on. The process start time covers settings and templates that only change on restart.
+func resultsETag(fingerprint string, dateRange DateRange, page, pageSize int, emailSearch string, maskEmails bool) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s|%d|%s|%s|%d|%d|%s|%t|%t", fingerprint, startTime.UnixNano(), dateRange.From, dateRange.To,
+		page, pageSize, emailSearch, maskEmails, externalAssets)
+	// Weak, since compressResponse may encode the same page differently
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists the ETag, using weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCSVDownload handles CSV download for specific action types
+func handleCSVDownload(c *fiber.Ctx) error {
+	action := c.Params("action")
+	log.Printf("CSV download request for action: %s from admin %q (IP: %s)", action, adminUser(c), c.IP())
+
+	// "all" exports every record regardless of action
+	exportAll := strings.EqualFold(action, "all")
+	if !exportAll && !isRecordedAction(action) {
 		log.Printf("ERROR: Invalid action type for CSV download: %s", action)
 		return c.Status(400).SendString("Invalid action type")
 	}
 
-	// Get records for the specific action
-	records, err := getRecordsByAction(action)
+	// Optional date range filter
+	dateRange, err := parseDateRange(c)
 	if err != nil {
-		log.Printf("ERROR: Failed to get records for action %s: %v", action, err)
-		return c.Status(500).SendString("Internal Server Error: Failed to retrieve records")
+		log.Printf("ERROR: Invalid date range for CSV download: %v", err)
+		return c.Status(400).SendString(fmt.Sprintf("Bad Request: %v", err))
 	}
 
-	// Create CSV content
-	var csvBuffer bytes.Buffer
-	writer := csv.NewWriter(&csvBuffer)
+	// Optional delimiter for spreadsheet locales that expect semicolons
+	delimiter, ok := csvDelimiters[c.Query("delimiter", ",")]
+	if !ok {
+		log.Printf("ERROR: Invalid delimiter for CSV download: %q", c.Query("delimiter"))
+		return c.Status(400).SendString("Bad Request: delimiter must be one of \",\", \";\" or \"tab\"")
+	}
 
-	// Write CSV header
-	header := []string{"Date", "Email", "Action"}
-	if err := writer.Write(header); err != nil {
-		log.Printf("ERROR: Failed to write CSV header: %v", err)
-		return c.Status(500).SendString("Internal Server Error: Failed to generate CSV")
+	if _, err := database(); err != nil {
+		log.Printf("ERROR: CSV download requested before database initialization")
+		return err
 	}
 
-	// Write CSV rows
-	for _, record := range records {
-		row := []string{record.FormattedDate, record.Email, record.Action}
-		if err := writer.Write(row); err != nil {
-			log.Printf("ERROR: Failed to write CSV row: %v", err)
-			return c.Status(500).SendString("Internal Server Error: Failed to generate CSV")
-		}
+	// An empty action filter matches every record
+	actionFilter := action
+	if exportAll {
+		actionFilter = ""
 	}
+	maskEmails := adminSeesMaskedEmails(c)
+	writeBOM := c.QueryBool("bom")
 
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		log.Printf("ERROR: CSV writer error: %v", err)
-		return c.Status(500).SendString("Internal Server Error: Failed to generate CSV")
+	// The row count isn't known until the stream ends, so the audit records the request itself
+	auditDetails := action
+	if dateRange.From != "" || dateRange.To != "" {
+		auditDetails += fmt.Sprintf(" from %q to %q", dateRange.From, dateRange.To)
 	}
+	recordAdminAction(c, "csv_download", auditDetails)
 
-	// Set response headers for file download
-	filename := fmt.Sprintf("%s_records_%s.csv", strings.ToLower(action), time.Now().Format("2006-01-02"))
+	filename := csvFilename(action, dateRange)
 	c.Set("Content-Type", "text/csv")
 	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 
-	log.Printf("Successfully generated CSV for action %s with %d records", action, len(records))
-	return c.Send(csvBuffer.Bytes())
+	// Rows are written straight from the database cursor so large exports never sit in memory
+	streamID := requestID(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer recoverStreamPanic("csv_download", streamID)
+
+		if writeBOM {
+			// Excel only detects UTF-8 when the file starts with a byte order mark
+			w.WriteString(utf8BOM)
+		}
+		writer := csv.NewWriter(w)
+		writer.Comma = delimiter
+
+		header := []string{"Date", "Email", "Action", "Status", "Status Code", "Details"}
+		if err := writer.Write(header); err != nil {
+			log.Printf("ERROR: Failed to write CSV header: %v", err)
+			return
+		}
+
+		count := 0
+		err := forEachRecordByAction(actionFilter, dateRange, func(record DisplayRecord) error {
+			if maskEmails {
+				record.Email = maskEmail(record.Email)
+			}
+			row := []string{record.FormattedDate, record.Email, record.Action, record.Status, strconv.Itoa(record.StatusCode), record.Details}
+			if err := writer.Write(row); err != nil {
+				return fmt.Errorf("failed to write CSV row: %w", err)
+			}
+			count++
+
+			// Flush periodically so large exports are sent incrementally
+			if count%500 == 0 {
+				writer.Flush()
+				if err := writer.Error(); err != nil {
+					return err
+				}
+				return w.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			// Headers are already sent, so the truncated file is the only signal to the client
+			log.Printf("ERROR: CSV download for action %s failed after %d records: %v", action, count, err)
+			writer.Flush()
+			w.Flush()
+			return
+		}
+
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			log.Printf("ERROR: CSV writer error: %v", err)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("ERROR: Failed to flush CSV download: %v", err)
+			return
+		}
+		log.Printf("Successfully generated CSV for action %s with %d records", action, count)
+	})
+
+	return nil
+}
+
+// csvDelimiters are the field separators accepted by the CSV download's delimiter parameter
+var csvDelimiters = map[string]rune{
+	",":   ',',
+	";":   ';',
+	"tab": '\t',
+}
+
+// utf8BOM is prepended to CSV downloads with bom=true
+const utf8BOM = "\xEF\xBB\xBF"
+
+// csvFilename builds a download filename reflecting the selected action and date range
+func csvFilename(action string, dateRange DateRange) string {
+	name := strings.ToLower(action) + "_records"
+	if dateRange.From == "" && dateRange.To == "" {
+		return fmt.Sprintf("%s_%s.csv", name, time.Now().Format("2006-01-02"))
+	}
+	if dateRange.From != "" {
+		name += "_from_" + dateRange.From
+	}
+	if dateRange.To != "" {
+		name += "_to_" + dateRange.To
+	}
+	return name + ".csv"
+}
+
+// handleJSONExport streams the entire records table as a single JSON document
+func handleJSONExport(c *fiber.Ctx) error {
+	log.Printf("JSON export request received from admin %q (IP: %s)", adminUser(c), c.IP())
+
+	if _, err := database(); err != nil {
+		log.Printf("ERROR: JSON export requested before database initialization")
+		return err
+	}
+
+	recordAdminAction(c, "json_export", "")
+	maskEmails := adminSeesMaskedEmails(c)
+
+	filename := fmt.Sprintf("email_processing_records_%s.json", time.Now().Format("2006-01-02"))
+	c.Set("Content-Type", "application/json")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	streamID := requestID(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer recoverStreamPanic("json_export", streamID)
+
+		fmt.Fprintf(w, `{"schema_version":%d,"table":"email_processing_records","exported_at":%q,"records":[`,
+			databaseSchemaVersion, time.Now().UTC().Format(time.RFC3339))
+
+		count := 0
+		err := forEachEmailProcessingRecord(func(record EmailProcessingRecord) error {
+			if maskEmails {
+				record.Email = maskEmail(record.Email)
+			}
+			recordJSON, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("failed to marshal record %d: %w", record.ID, err)
+			}
+			if count > 0 {
+				w.WriteString(",")
+			}
+			w.Write(recordJSON)
+			count++
+
+			// Flush periodically so large tables are sent incrementally
+			if count%500 == 0 {
+				return w.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			// Headers are already sent, so the truncated document is the only signal to the client
+			log.Printf("ERROR: JSON export failed after %d records: %v", count, err)
+			w.Flush()
+			return
+		}
+
+		w.WriteString("]}")
+		if err := w.Flush(); err != nil {
+			log.Printf("ERROR: Failed to flush JSON export: %v", err)
+			return
+		}
+		log.Printf("Successfully exported %d records as JSON", count)
+	})
+
+	return nil
+}
+
+// handleStats returns aggregate retry statistics for recorded actions
+func handleStats(c *fiber.Ctx) error {
+	log.Printf("GET /results/stats request received from IP: %s", c.IP())
+
+	stats, err := getRetryStats()
+	if err != nil {
+		log.Printf("ERROR: Failed to get retry stats: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to retrieve stats",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"retries": stats,
+	})
+}
+
+// handleResultsJSON returns the action summary as JSON for dashboards, honoring the from/to date range
+func handleResultsJSON(c *fiber.Ctx) error {
+	log.Printf("GET /results.json request received from IP: %s", c.IP())
+
+	dateRange, err := parseDateRange(c)
+	if err != nil {
+		log.Printf("ERROR: Invalid date range for /results.json: %v", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+
+	actions, totals, err := summarizeActions(dateRange)
+	if err != nil {
+		log.Printf("ERROR: Failed to get action summary: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to retrieve summary data",
+		})
+	}
+
+	// summary and failures predate the per-action breakdown and are kept for existing dashboards
+	summary := make(map[string]int, len(actions))
+	failures := make(map[string]int, len(actions))
+	for action, counts := range actions {
+		summary[action] = counts.Success
+		failures[action] = counts.Failed
+	}
+
+	return c.JSON(fiber.Map{
+		"success":      true,
+		"actions":      actions,
+		"summary":      summary,
+		"failures":     failures,
+		"total":        totals.Total(),
+		"failed_total": totals.Failed,
+		"error_rate":   totals.ErrorRate(),
+		"from":         dateRange.From,
+		"to":           dateRange.To,
+	})
 }
 
-// handleClearRecords handles clearing all records from the database
+// handleCustomerHistory shows one customer's action timeline, oldest first, as HTML or as JSON
+// for callers sending Accept: application/json
+func handleCustomerHistory(c *fiber.Ctx) error {
+	rawEmail, err := url.PathUnescape(c.Params("email"))
+	if err != nil {
+		rawEmail = c.Params("email")
+	}
+	log.Printf("GET /results/customer request received for %s from IP: %s", logEmail(rawEmail), c.IP())
+
+	email, err := validateEmail(rawEmail)
+	if err != nil {
+		if wantsJSON(c) {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"message": "Invalid email address",
+			})
+		}
+		return c.Status(400).SendString("Bad Request: invalid email address")
+	}
+
+	records, err := getRecordsByEmail(email)
+	if err != nil {
+		log.Printf("ERROR: Failed to get records for %s: %v", logEmail(email), err)
+		if wantsJSON(c) {
+			return c.Status(500).JSON(fiber.Map{
+				"success": false,
+				"message": "Failed to retrieve records",
+			})
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to retrieve records")
+	}
+
+	recordAdminAction(c, "view", "customer history for "+logEmail(email))
+
+	displayEmail := email
+	if adminSeesMaskedEmails(c) {
+		displayEmail = maskEmail(email)
+		maskDisplayRecords(records)
+	}
+
+	if wantsJSON(c) {
+		if records == nil {
+			records = []DisplayRecord{}
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"email":   displayEmail,
+			"total":   len(records),
+			"records": records,
+		})
+	}
+
+	return c.Render("customer", fiber.Map{
+		"Email":          displayEmail,
+		"Records":        records,
+		"Timezone":       displayLocation.String(),
+		"ExternalAssets": externalAssets,
+	})
+}
+
+// clearRecordsConfirmation must be sent as confirm with POST /results/clear, so a stray click can't wipe the table
+const clearRecordsConfirmation = "DELETE"
+
+// handleClearRecords handles clearing records from the database: all of them, or only those from
+// before the optional before date (YYYY-MM-DD, display timezone)
 func handleClearRecords(c *fiber.Ctx) error {
-	log.Printf("Clear records request received from IP: %s", c.IP())
+	log.Printf("Clear records request received from admin %q (IP: %s)", adminUser(c), c.IP())
+
+	var req struct {
+		Confirm string `json:"confirm" form:"confirm"`
+		Before  string `json:"before" form:"before"`
+	}
+	if err := c.BodyParser(&req); err != nil && len(c.Body()) > 0 {
+		log.Printf("ERROR: Failed to parse clear records request: %v", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request format",
+		})
+	}
+
+	if req.Confirm != clearRecordsConfirmation {
+		log.Printf("REJECTED: Clear records request from admin %q without confirm=%s", adminUser(c), clearRecordsConfirmation)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": fmt.Sprintf("Confirmation required: send confirm=%s to clear records", clearRecordsConfirmation),
+		})
+	}
+
+	req.Before = strings.TrimSpace(req.Before)
+	if req.Before != "" {
+		if _, err := time.Parse("2006-01-02", req.Before); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"message": fmt.Sprintf("Invalid before date %q, expected YYYY-MM-DD", req.Before),
+			})
+		}
+	}
+
+	scope := "all records"
+	if req.Before != "" {
+		scope = "records before " + req.Before
+	}
 
-	// Clear all records
-	err := clearAllRecords()
+	// Clear the records, auditing the attempt whether or not it succeeds
+	cleared, err := clearRecordsBefore(req.Before)
 	if err != nil {
+		recordAdminAction(c, "clear", scope+": failed")
 		log.Printf("ERROR: Failed to clear records: %v", err)
 		return c.Status(500).JSON(fiber.Map{
 			"success": false,
@@ -860,49 +1580,193 @@ func handleClearRecords(c *fiber.Ctx) error {
 		})
 	}
 
-	log.Printf("Successfully cleared all records from database")
+	recordAdminAction(c, "clear", fmt.Sprintf("%s (%d records)", scope, cleared))
+	log.Printf("Successfully cleared %s (%d records)", scope, cleared)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"cleared": cleared,
+		"message": fmt.Sprintf("Cleared %d records", cleared),
+	})
+}
+
+// handleDeleteRecords handles scoped record deletion, the preferred alternative to /results/clear for
+// retention purges: only records matching the action and/or before filters are deleted, and at least
+// one filter is required
+func handleDeleteRecords(c *fiber.Ctx) error {
+	log.Printf("Delete records request received from admin %q (IP: %s)", adminUser(c), c.IP())
+
+	var req struct {
+		Action string `json:"action" form:"action"`
+		Before string `json:"before" form:"before"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		log.Printf("ERROR: Failed to parse delete records request: %v", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request format",
+		})
+	}
+
+	req.Action = strings.ToUpper(strings.TrimSpace(req.Action))
+	req.Before = strings.TrimSpace(req.Before)
+	if req.Action == "" && req.Before == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Specify an action and/or before date; use /results/clear to delete every record",
+		})
+	}
+	if req.Action != "" && !isRecordedAction(req.Action) {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": fmt.Sprintf("Invalid action %q", req.Action),
+		})
+	}
+	if req.Before != "" {
+		if _, err := time.Parse("2006-01-02", req.Before); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"message": fmt.Sprintf("Invalid before date %q, expected YYYY-MM-DD", req.Before),
+			})
+		}
+	}
+
+	var filters []string
+	if req.Action != "" {
+		filters = append(filters, "action "+req.Action)
+	}
+	if req.Before != "" {
+		filters = append(filters, "before "+req.Before)
+	}
+	scope := "records with " + strings.Join(filters, ", ")
+
+	// Delete the records, auditing the attempt whether or not it succeeds
+	deleted, err := deleteRecords(req.Action, req.Before)
+	if err != nil {
+		recordAdminAction(c, "delete", scope+": failed")
+		log.Printf("ERROR: Failed to delete records: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to delete records",
+		})
+	}
+
+	recordAdminAction(c, "delete", fmt.Sprintf("%s (%d records)", scope, deleted))
+	log.Printf("Successfully deleted %s (%d records)", scope, deleted)
 	return c.JSON(fiber.Map{
 		"success": true,
-		"message": "All records cleared successfully",
+		"deleted": deleted,
+		"message": fmt.Sprintf("Deleted %d records", deleted),
 	})
 }
 
-// SubscriptionUpdate represents the subscription update request
+// SubscriptionUpdate represents the subscription update request.
+// Each Subscriptions value must be "true" (subscribed), "false" (unsubscribed) or "none" (no preference).
 type SubscriptionUpdate struct {
 	Email         string            `json:"email"`
+	ID            string            `json:"id"` // Customer.io customer ID, used when email is empty
 	Action        string            `json:"action"`
 	Subscriptions map[string]string `json:"subscriptions"`
+	Signature     string            `json:"sig"`
+	CSRFToken     string            `json:"csrf_token"`
 }
 
 // handleUpdateSubscriptions handles updating individual brand subscriptions
 func handleUpdateSubscriptions(c *fiber.Ctx) error {
 	var req SubscriptionUpdate
 	if err := c.BodyParser(&req); err != nil {
-		log.Printf("ERROR: Failed to parse request body: %v", err)
+		slog.Warn("Failed to parse request body", "ip", c.IP(), "error", err)
 		return c.Status(400).JSON(fiber.Map{
 			"success": false,
 			"message": "Invalid request format",
 		})
 	}
 
-	log.Printf("Updating subscriptions for email: %s", req.Email)
+	identifier, err := validateCustomerIdentifier(req.Email, req.ID)
+	if err != nil {
+		slog.Warn("Rejected invalid customer identifier in request body", "ip", c.IP(), "error", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Please provide a valid email address or customer ID",
+		})
+	}
+
+	if err := verifyCSRFToken(c, identifier, requestCSRFToken(c, req.CSRFToken)); err != nil {
+		slog.Warn("Rejected request with invalid CSRF token", "email", logEmail(identifier), "ip", c.IP(), "error", err)
+		return c.Status(403).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid or expired form token, please reload the page",
+		})
+	}
+
+	if !checkLinkSignature(identifier, req.Signature, c.IP()) {
+		return c.Status(403).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid link signature",
+		})
+	}
+
+	// Only known brand subscription attributes may be written, and only with a valid state
+	var unknownKeys, invalidValues []string
+	for key, value := range req.Subscriptions {
+		if !isSubscriptionKey(key) {
+			unknownKeys = append(unknownKeys, key)
+		} else if !isSubscriptionValue(value) {
+			invalidValues = append(invalidValues, fmt.Sprintf("%s=%q", key, value))
+		}
+	}
+	if len(unknownKeys) > 0 {
+		slices.Sort(unknownKeys)
+		slog.Warn("Rejected unknown subscription keys", "email", logEmail(identifier), "keys", unknownKeys, "ip", c.IP())
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Unknown subscription keys: " + strings.Join(unknownKeys, ", "),
+		})
+	}
+	if len(invalidValues) > 0 {
+		slices.Sort(invalidValues)
+		slog.Warn("Rejected invalid subscription values", "email", logEmail(identifier), "values", invalidValues, "ip", c.IP())
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid subscription values (expected true, false or none): " + strings.Join(invalidValues, ", "),
+		})
+	}
+
+	slog.Info("Updating subscriptions", "email", logEmail(identifier), "action", "subscription_update")
+
+	// Update Customer.io attributes for each subscription, noting the prior states first
+	ctx := c.Context()
+	details := subscriptionChangeDetails(ctx, identifier, req.Subscriptions)
+	result, err := withExistingCustomer(ctx, identifier, func() (TrackResult, error) {
+		return updateCustomerSubscriptionAttributes(ctx, identifier, req.Subscriptions)
+	})
+
+	// Log to database, including failures, with what the customer changed
+	recordActionResultWithDetails(identifier, "subscription_update", recordDetails(details), result, err)
 
-	// Update Customer.io attributes for each subscription
-	err := updateCustomerSubscriptionAttributes(req.Email, req.Subscriptions)
+	if errors.Is(err, errCustomerNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"message": "Customer not found",
+		})
+	}
 	if err != nil {
-		log.Printf("ERROR: Failed to update subscriptions for %s: %v", req.Email, err)
-		return c.Status(500).JSON(fiber.Map{
+		slog.Error("Failed to update subscriptions", "email", logEmail(identifier), "action", "subscription_update", "error", err)
+		return c.Status(customerIOFailureStatus(err)).JSON(fiber.Map{
 			"success": false,
 			"message": "Failed to update subscriptions",
 		})
 	}
 
-	// Log to database
-	if dbErr := insertEmailProcessingRecord(req.Email, "subscription_update"); dbErr != nil {
-		log.Printf("WARNING: Failed to log subscription update to database for email %s: %v", req.Email, dbErr)
+	slog.Info("Updated subscriptions", "email", logEmail(identifier), "action", "subscription_update")
+
+	// The preferences are saved, so a failed confirmation event is logged rather than reported
+	if preferencesUpdatedEvent != "" {
+		data := map[string]interface{}{"subscriptions": req.Subscriptions}
+		if err := trackEvent(ctx, identifier, preferencesUpdatedEvent, data); err != nil {
+			slog.Warn("Failed to send preference update event", "email", logEmail(identifier), "event", preferencesUpdatedEvent, "error", err)
+		}
 	}
 
-	log.Printf("Successfully updated subscriptions for %s", req.Email)
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "Subscriptions updated successfully",
@@ -912,50 +1776,330 @@ func handleUpdateSubscriptions(c *fiber.Ctx) error {
 // handleUnsubscribeAll handles unsubscribing from all brands
 func handleUnsubscribeAll(c *fiber.Ctx) error {
 	var req struct {
-		Email  string `json:"email"`
-		Action string `json:"action"`
+		Email     string `json:"email"`
+		ID        string `json:"id"` // Customer.io customer ID, used when email is empty
+		Action    string `json:"action"`
+		Signature string `json:"sig"`
+		CSRFToken string `json:"csrf_token"`
 	}
 	if err := c.BodyParser(&req); err != nil {
-		log.Printf("ERROR: Failed to parse request body: %v", err)
+		slog.Warn("Failed to parse request body", "ip", c.IP(), "error", err)
 		return c.Status(400).JSON(fiber.Map{
 			"success": false,
 			"message": "Invalid request format",
 		})
 	}
 
-	log.Printf("Unsubscribing all for email: %s", req.Email)
-
-	// Remove all subscription attributes and set unsubscribed to true
-	err := unsubscribeAllBrands(req.Email)
+	identifier, err := validateCustomerIdentifier(req.Email, req.ID)
 	if err != nil {
-		log.Printf("ERROR: Failed to unsubscribe all for %s: %v", req.Email, err)
-		return c.Status(500).JSON(fiber.Map{
+		slog.Warn("Rejected invalid customer identifier in request body", "ip", c.IP(), "error", err)
+		return c.Status(400).JSON(fiber.Map{
 			"success": false,
-			"message": "Failed to unsubscribe",
+			"message": "Please provide a valid email address or customer ID",
+		})
+	}
+
+	if err := verifyCSRFToken(c, identifier, requestCSRFToken(c, req.CSRFToken)); err != nil {
+		slog.Warn("Rejected request with invalid CSRF token", "email", logEmail(identifier), "ip", c.IP(), "error", err)
+		return c.Status(403).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid or expired form token, please reload the page",
+		})
+	}
+
+	if !checkLinkSignature(identifier, req.Signature, c.IP()) {
+		return c.Status(403).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid link signature",
 		})
 	}
 
-	// Log to database
-	if dbErr := insertEmailProcessingRecord(req.Email, "unsubscribe_all"); dbErr != nil {
-		log.Printf("WARNING: Failed to log unsubscribe all to database for email %s: %v", req.Email, dbErr)
+	slog.Info("Unsubscribing all brands", "email", logEmail(identifier), "action", "unsubscribe_all")
+
+	// Remove all subscription attributes and set unsubscribed to true, logging the result (including failures)
+	err = applyUnsubscribeAll(c.Context(), identifier, "")
+	if isTransientActionError(err) && !databaseDisabled {
+		// Customer.io is unavailable; the unsubscribe must still land, so queue it for retry
+		queueErr := queueActionRetry(identifier, "unsubscribe_all", "", err)
+		if queueErr == nil {
+			slog.Warn("Queued unsubscribe all for retry", "email", logEmail(identifier), "action", "unsubscribe_all", "error", err)
+			return c.Status(202).JSON(fiber.Map{
+				"success": true,
+				"queued":  true,
+				"message": "Your unsubscribe has been saved and will be applied shortly",
+			})
+		}
+		slog.Error("Failed to queue unsubscribe all for retry", "email", logEmail(identifier), "error", queueErr)
+	}
+
+	if errors.Is(err, errCustomerNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"message": "Customer not found",
+		})
+	}
+	if err != nil {
+		slog.Error("Failed to unsubscribe all brands", "email", logEmail(identifier), "action", "unsubscribe_all", "error", err)
+		return c.Status(customerIOFailureStatus(err)).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to unsubscribe",
+		})
 	}
 
-	log.Printf("Successfully unsubscribed all for %s", req.Email)
+	slog.Info("Unsubscribed all brands", "email", logEmail(identifier), "action", "unsubscribe_all")
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "Unsubscribed from all brands successfully",
 	})
 }
 
-// updateCustomerSubscriptionAttributes updates the subscription attributes for a customer
-func updateCustomerSubscriptionAttributes(email string, subscriptions map[string]string) error {
-	log.Printf("Updating subscription attributes for email: %s", email)
+// handlePauseAction returns the handler for POST /pause and POST /unpause, the JSON counterparts of the
+// action=pause and action=unpause links for app clients. It takes {"email":..} or {"id":..} and responds
+// with JSON. Browsers with a CSRF session send a CSRF token and optional link signature like the
+// subscription endpoints; app clients send the link's signature or its action token instead.
+func handlePauseAction(action string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Email     string `json:"email"`
+			ID        string `json:"id"` // Customer.io customer ID, used when email is empty
+			Signature string `json:"sig"`
+			Token     string `json:"token"` // Action token from the customer's link, for app clients
+			CSRFToken string `json:"csrf_token"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			slog.Warn("Failed to parse request body", "ip", c.IP(), "action", action, "error", err)
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"message": "Invalid request format",
+			})
+		}
+
+		identifier, err := validateCustomerIdentifier(req.Email, req.ID)
+		if err != nil {
+			slog.Warn("Rejected invalid customer identifier in request body", "ip", c.IP(), "action", action, "error", err)
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"message": "Please provide a valid email address or customer ID",
+			})
+		}
+
+		if !hasCSRFSession(c) {
+			if err := verifyLinkCredential(identifier, req.Signature, req.Token, action); err != nil {
+				slog.Warn("Rejected app request without a valid link credential", "email", logEmail(identifier), "ip", c.IP(), "action", action, "error", err)
+				return c.Status(403).JSON(fiber.Map{
+					"success": false,
+					"message": "A valid link signature (sig) or action token (token) is required",
+				})
+			}
+		} else if err := verifyCSRFToken(c, identifier, requestCSRFToken(c, req.CSRFToken)); err != nil {
+			slog.Warn("Rejected request with invalid CSRF token", "email", logEmail(identifier), "ip", c.IP(), "action", action, "error", err)
+			return c.Status(403).JSON(fiber.Map{
+				"success": false,
+				"message": "Invalid or expired form token, please reload the page",
+			})
+		} else if !checkLinkSignature(identifier, req.Signature, c.IP()) {
+			return c.Status(403).JSON(fiber.Map{
+				"success": false,
+				"message": "Invalid link signature",
+			})
+		}
+
+		// Same path as the email links: idempotency window, Customer.io call, database record and retry queue
+		outcome := performLinkAction(c.Context(), identifier, action, "", "")
+		if !outcome.Success {
+			return c.Status(outcome.Status).JSON(fiber.Map{
+				"success": false,
+				"action":  action,
+				"message": actionErrorSummary(outcome.Err),
+			})
+		}
+		return c.Status(outcome.Status).JSON(fiber.Map{
+			"success": true,
+			"action":  action,
+			"message": outcome.Message,
+		})
+	}
+}
+
+// handleInternationalAction handles POST /international, the JSON counterpart of the action=international
+// and action=region links. It takes {"email":..} or {"id":..} with "from" and "to" regions (default BBUS to
+// BBAU), authenticated like POST /pause. With "preview":true it reports the relationships the move would
+// remove and add without calling Customer.io or recording anything.
+func handleInternationalAction(c *fiber.Ctx) error {
+	var req struct {
+		Email     string `json:"email"`
+		ID        string `json:"id"` // Customer.io customer ID, used when email is empty
+		From      string `json:"from"`
+		To        string `json:"to"`
+		Preview   bool   `json:"preview"`
+		Signature string `json:"sig"`
+		Token     string `json:"token"` // Action token from the customer's link, for app clients
+		CSRFToken string `json:"csrf_token"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		slog.Warn("Failed to parse request body", "ip", c.IP(), "action", "international", "error", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid request format",
+		})
+	}
+
+	identifier, err := validateCustomerIdentifier(req.Email, req.ID)
+	if err != nil {
+		slog.Warn("Rejected invalid customer identifier in request body", "ip", c.IP(), "action", "international", "error", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Please provide a valid email address or customer ID",
+		})
+	}
+
+	// Without from/to this is the international link's move; otherwise both must be configured regions
+	from, to := strings.ToUpper(strings.TrimSpace(req.From)), strings.ToUpper(strings.TrimSpace(req.To))
+	if from == "" && to == "" {
+		from, to = "BBUS", "BBAU"
+	}
+	if !regionObjectIDs[from] || !regionObjectIDs[to] || from == to {
+		slog.Warn("Rejected region move", "email", logEmail(identifier), "action", "international", "from", from, "to", to)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "from and to must be two different regions from REGION_OBJECT_IDS",
+			"regions": slices.Sorted(maps.Keys(regionObjectIDs)),
+		})
+	}
+
+	// The US to AU move is recorded as the international link records it, so /results counts it the same way.
+	// A region link's token covers any move; an international link's token only covers its own.
+	action := "region"
+	tokenActions := []string{"region"}
+	if from == "BBUS" && to == "BBAU" {
+		action = "international"
+		tokenActions = append(tokenActions, "international")
+	}
+
+	if !hasCSRFSession(c) {
+		if err := verifyLinkCredential(identifier, req.Signature, req.Token, tokenActions...); err != nil {
+			slog.Warn("Rejected app request without a valid link credential", "email", logEmail(identifier), "ip", c.IP(), "action", action, "error", err)
+			return c.Status(403).JSON(fiber.Map{
+				"success": false,
+				"message": "A valid link signature (sig) or action token (token) is required",
+			})
+		}
+	} else if err := verifyCSRFToken(c, identifier, requestCSRFToken(c, req.CSRFToken)); err != nil {
+		slog.Warn("Rejected request with invalid CSRF token", "email", logEmail(identifier), "ip", c.IP(), "action", "international", "error", err)
+		return c.Status(403).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid or expired form token, please reload the page",
+		})
+	} else if !checkLinkSignature(identifier, req.Signature, c.IP()) {
+		return c.Status(403).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid link signature",
+		})
+	}
+
+	if req.Preview {
+		remove, add := regionMovePreview(from, to)
+		slog.Info("Previewed region move", "email", logEmail(identifier), "action", "international", "from", from, "to", to)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"preview": true,
+			"from":    from,
+			"to":      to,
+			"remove":  remove,
+			"add":     add,
+		})
+	}
+
+	outcome := performLinkAction(c.Context(), identifier, action, from, to)
+	if !outcome.Success {
+		return c.Status(outcome.Status).JSON(fiber.Map{
+			"success": false,
+			"action":  action,
+			"from":    from,
+			"to":      to,
+			"message": actionErrorSummary(outcome.Err),
+		})
+	}
+	return c.Status(outcome.Status).JSON(fiber.Map{
+		"success": true,
+		"action":  action,
+		"from":    from,
+		"to":      to,
+		"message": outcome.Message,
+	})
+}
+
+// handleOneClickUnsubscribe handles RFC 8058 one-click unsubscribes. Mail clients POST
+// "List-Unsubscribe=One-Click" to the List-Unsubscribe URL, which carries a signed action token.
+func handleOneClickUnsubscribe(c *fiber.Ctx) error {
+	if c.FormValue("List-Unsubscribe") != "One-Click" {
+		slog.Warn("Rejected one-click unsubscribe without List-Unsubscribe=One-Click body", "ip", c.IP())
+		return c.Status(400).SendString("Bad Request: expected List-Unsubscribe=One-Click")
+	}
+
+	tokenEmail, tokenAction, err := verifyActionToken(c.Query("token"))
+	if errors.Is(err, errActionTokenExpired) {
+		slog.Info("Rejected one-click unsubscribe with expired token", "ip", c.IP(), "error", err)
+		return c.Status(410).SendString("Gone: unsubscribe link expired")
+	}
+	if err != nil || tokenAction != "unsubscribe" {
+		slog.Warn("Rejected one-click unsubscribe with invalid token", "ip", c.IP(), "error", err, "token_action", tokenAction)
+		return c.Status(403).SendString("Forbidden: invalid unsubscribe token")
+	}
+
+	email, err := validateEmail(tokenEmail)
+	if err != nil {
+		slog.Warn("Rejected one-click unsubscribe with invalid email in token", "ip", c.IP(), "error", err)
+		return c.Status(400).SendString("Bad Request: invalid email")
+	}
+
+	// Mail providers may retry the POST; a repeat of a just-completed unsubscribe is a no-op
+	if actionIdempotencyWindow > 0 && !databaseDisabled {
+		recent, err := recentlyProcessed(email, "unsubscribe", actionIdempotencyWindow)
+		if err != nil {
+			slog.Warn("Failed to check for recently processed action", "email", logEmail(email), "action", "unsubscribe", "error", err)
+		}
+		if recent {
+			slog.Info("One-click unsubscribe already processed recently, skipping Customer.io call", "email", logEmail(email))
+			return c.SendString("Unsubscribed")
+		}
+	}
+
+	err = applyUnsubscribe(c.Context(), email, "")
+	if isTransientActionError(err) && !databaseDisabled {
+		// Mail providers don't resend one-click unsubscribes reliably, so queue it for retry
+		queueErr := queueActionRetry(email, "unsubscribe", "", err)
+		if queueErr == nil {
+			slog.Warn("Queued one-click unsubscribe for retry", "email", logEmail(email), "action", "unsubscribe", "error", err)
+			return c.Status(202).SendString("Unsubscribe accepted")
+		}
+		slog.Error("Failed to queue one-click unsubscribe for retry", "email", logEmail(email), "error", queueErr)
+	}
+	if errors.Is(err, errCustomerNotFound) {
+		return c.Status(404).SendString("Customer not found")
+	}
+	if err != nil {
+		slog.Error("Failed to process one-click unsubscribe", "email", logEmail(email), "action", "unsubscribe", "error", err)
+		return fiber.NewError(customerIOFailureStatus(err), "Unsubscribe failed")
+	}
+
+	slog.Info("Processed one-click unsubscribe", "email", logEmail(email), "action", "unsubscribe")
+	return c.SendString("Unsubscribed")
+}
+
+// updateCustomerSubscriptionAttributes updates the subscription attributes for a customer identified by email or customer ID
+func updateCustomerSubscriptionAttributes(ctx context.Context, identifier string, subscriptions map[string]string) (TrackResult, error) {
+	slog.Debug("Updating subscription attributes", "email", logEmail(identifier))
 
 	// Build attributes map
 	attributes := make(map[string]interface{})
-	
+
 	// Set each subscription attribute based on the three-state system
 	for key, value := range subscriptions {
+		if !isSubscriptionKey(key) {
+			slog.Warn("Ignoring unknown subscription key", "email", logEmail(identifier), "key", key)
+			continue
+		}
 		if value == "true" {
 			attributes[key] = true
 		} else if value == "false" {
@@ -974,7 +2118,7 @@ func updateCustomerSubscriptionAttributes(email string, subscriptions map[string
 			break
 		}
 	}
-	
+
 	// Set unsubscribed attribute based on subscription states
 	if allFalse {
 		// If all are false, set unsubscribed to true
@@ -986,107 +2130,366 @@ func updateCustomerSubscriptionAttributes(email string, subscriptions map[string
 
 	// Prepare the request payload
 	requestBody := map[string]interface{}{
-		"email":      email,
 		"attributes": attributes,
 	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		log.Printf("ERROR: Failed to marshal request body: %v", err)
-		return fmt.Errorf("failed to marshal request body: %w", err)
+	if isEmailIdentifier(identifier) {
+		requestBody["email"] = identifier
 	}
 
-	// Create HTTP request
-	url := fmt.Sprintf("https://track.customer.io/api/v1/customers/%s", email)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
+	result, err := customerIO.UpdateAttributes(ctx, identifier, requestBody)
 	if err != nil {
-		log.Printf("ERROR: Failed to create HTTP request: %v", err)
-		return fmt.Errorf("failed to create request: %w", err)
+		return result, err
 	}
 
-	// Set headers
-	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", customerIOSiteID, customerIOAPIKey)))
-	req.Header.Set("Authorization", "Basic "+auth)
-	req.Header.Set("Content-Type", "application/json")
+	slog.Debug("Updated subscription attributes", "email", logEmail(identifier))
+	return result, nil
+}
 
-	// Send request
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("ERROR: HTTP request failed: %v", err)
-		return fmt.Errorf("request failed: %w", err)
+// configureSubscriptionKeys loads the brand subscription attribute keys from SUBSCRIPTION_KEYS
+func configureSubscriptionKeys() {
+	keyList := os.Getenv("SUBSCRIPTION_KEYS")
+	if keyList == "" {
+		keyList = defaultSubscriptionKeys
 	}
-	defer resp.Body.Close()
 
-	// Check response
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("ERROR: Customer.io API returned status %d: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	subscriptionKeys = nil
+	for _, key := range strings.Split(keyList, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			subscriptionKeys = append(subscriptionKeys, key)
+		}
 	}
+	log.Printf("Subscription attribute keys: %s", strings.Join(subscriptionKeys, ", "))
+}
 
-	log.Printf("Successfully updated subscription attributes for %s", email)
-	return nil
+// isSubscriptionKey reports whether key is one of the configured subscription attribute keys
+func isSubscriptionKey(key string) bool {
+	return slices.Contains(subscriptionKeys, key)
+}
+
+// isSubscriptionValue reports whether value is one of the three subscription states: true, false or none
+func isSubscriptionValue(value string) bool {
+	return value == "true" || value == "false" || value == "none"
+}
+
+// trackEvent sends a named event with data for the customer to the Customer.io Track API
+func trackEvent(ctx context.Context, email, eventName string, data map[string]interface{}) error {
+	slog.Debug("Sending Customer.io event", "email", logEmail(email), "event", eventName)
+	_, err := customerIO.TrackEvent(ctx, email, eventName, data)
+	return err
 }
 
-// unsubscribeAllBrands sets all subscription attributes to false and sets unsubscribed to true
-func unsubscribeAllBrands(email string) error {
-	log.Printf("Unsubscribing all brands for email: %s", email)
+// unsubscribeAllBrands sets all subscription attributes to false and sets unsubscribed to true for a customer
+// identified by email or customer ID
+func unsubscribeAllBrands(ctx context.Context, identifier string) (TrackResult, error) {
+	slog.Debug("Unsubscribing all brands", "email", logEmail(identifier))
 
 	// Build attributes map - set all subscriptions to false and unsubscribed to true
 	attributes := map[string]interface{}{
 		"unsubscribed": true,
-		"sub_bbau":     false,
-		"sub_bbus":     false,
-		"sub_csau":     false,
-		"sub_csus":     false,
-		"sub_ffau":     false,
-		"sub_ffus":     false,
-		"sub_sbau":     false,
-		"sub_ppau":     false,
+	}
+	for _, key := range subscriptionKeys {
+		attributes[key] = false
 	}
 
 	// Prepare the request payload
 	requestBody := map[string]interface{}{
-		"email":      email,
 		"attributes": attributes,
 	}
+	if isEmailIdentifier(identifier) {
+		requestBody["email"] = identifier
+	}
 
-	jsonData, err := json.Marshal(requestBody)
+	result, err := customerIO.UpdateAttributes(ctx, identifier, requestBody)
 	if err != nil {
-		log.Printf("ERROR: Failed to marshal request body: %v", err)
-		return fmt.Errorf("failed to marshal request body: %w", err)
+		return result, err
+	}
+
+	slog.Debug("Unsubscribed all brands", "email", logEmail(identifier))
+	return result, nil
+}
+
+// errAnonymousProfile is returned when Customer.io reports that an email maps to an anonymous profile
+var errAnonymousProfile = errors.New("customer.io profile is anonymous")
+
+// isAnonymousProfileResponse reports whether a Track API response indicates an anonymous/unidentified profile
+func isAnonymousProfileResponse(statusCode int, body []byte) bool {
+	if len(body) == 0 {
+		return false
 	}
+	lowerBody := strings.ToLower(string(body))
+	return strings.Contains(lowerBody, "anonymous") ||
+		(statusCode == http.StatusBadRequest && strings.Contains(lowerBody, "not identified"))
+}
 
-	// Create HTTP request
-	url := fmt.Sprintf("https://track.customer.io/api/v1/customers/%s", email)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
+// withAnonymousProfileHandling runs a Track API mutation and, when the profile is anonymous and
+// CUSTOMERIO_IDENTIFY_ANONYMOUS is enabled, identifies the customer by email and retries once.
+func withAnonymousProfileHandling(ctx context.Context, email string, mutate func() (TrackResult, error)) (TrackResult, error) {
+	result, err := mutate()
+	if !errors.Is(err, errAnonymousProfile) || !isEmailIdentifier(email) {
+		// Only an email can identify an anonymous profile
+		return result, err
+	}
+
+	if !identifyAnonymous {
+		slog.Warn("Anonymous profile, identification disabled (CUSTOMERIO_IDENTIFY_ANONYMOUS=false)", "email", logEmail(email))
+		return result, err
+	}
+
+	slog.Info("Anonymous profile, identifying customer before retrying", "email", logEmail(email))
+	identifyResult, identifyErr := identifyCustomerByEmail(ctx, email)
+	result = result.then(identifyResult)
+	if identifyErr != nil {
+		return result, fmt.Errorf("error identifying anonymous profile: %w", identifyErr)
+	}
+
+	retryResult, err := mutate()
+	return result.then(retryResult), err
+}
+
+// errCustomerNotFound is returned when REQUIRE_EXISTING_CUSTOMER is set and Customer.io has no profile for the email
+var errCustomerNotFound = errors.New("customer not found in Customer.io")
+
+// withExistingCustomer runs mutate only once the customer is known to exist, because every Track API
+// call upserts and a mistyped email would otherwise create a new profile. The lookup only happens with
+// REQUIRE_EXISTING_CUSTOMER; relationship moves skip this wrapper since upserting there is intended.
+func withExistingCustomer(ctx context.Context, email string, mutate func() (TrackResult, error)) (TrackResult, error) {
+	if err := ensureCustomerExists(ctx, email); err != nil {
+		return TrackResult{}, err
+	}
+	return withAnonymousProfileHandling(ctx, email, mutate)
+}
+
+// ensureCustomerExists returns errCustomerNotFound when REQUIRE_EXISTING_CUSTOMER is set and Customer.io
+// has no profile for the email. Without REQUIRE_EXISTING_CUSTOMER it does nothing.
+func ensureCustomerExists(ctx context.Context, email string) error {
+	if !requireExistingCustomer {
+		return nil
+	}
+	exists, err := customerIO.CustomerExists(ctx, email)
 	if err != nil {
-		log.Printf("ERROR: Failed to create HTTP request: %v", err)
-		return fmt.Errorf("failed to create request: %w", err)
+		slog.Error("Failed to look up customer", "email", logEmail(email), "error", err)
+		return fmt.Errorf("error looking up customer: %w", err)
+	}
+	if !exists {
+		slog.Warn("Customer not found, skipping Customer.io update", "email", logEmail(email))
+		return fmt.Errorf("%w: %s", errCustomerNotFound, logEmail(email))
+	}
+	return nil
+}
+
+// linkActionOutcome is the result of a customer link action, for both HTML and JSON responses
+type linkActionOutcome struct {
+	Message   string // Shown to the customer, in English; HTML pages use localizedMessage
+	Success   bool
+	CancelURL string // Undo link for a deferred unsubscribe
+	Status    int    // HTTP status for JSON callers
+	Err       error  // Why the action failed, if it did
+
+	messageFormat string        // English format of Message, the messageCatalog key
+	messageArgs   []interface{} // Arguments for messageFormat
+}
+
+// setMessage sets the customer-facing message from an English format string, keeping the format and
+// arguments so the pages can show it in the customer's language
+func (out *linkActionOutcome) setMessage(format string, args ...interface{}) {
+	out.messageFormat, out.messageArgs = format, args
+	out.Message = translate(defaultLocale, format, args...)
+}
+
+// setSuccessMessage sets the confirmation shown after a link action completes
+func (out *linkActionOutcome) setSuccessMessage(action, email string) {
+	if definition, ok := findAction(action); ok && definition.successMessage != "" {
+		out.setMessage(definition.successMessage, email)
+		return
+	}
+	out.setMessage("Your request has been processed.")
+}
+
+// localizedMessage returns the message in locale
+func (out linkActionOutcome) localizedMessage(locale string) string {
+	if out.messageFormat == "" {
+		return out.Message
 	}
+	return translate(locale, out.messageFormat, out.messageArgs...)
+}
+
+var (
+	errUnknownAction     = errors.New("unknown action")
+	errInvalidRegionMove = errors.New("invalid region move")
+)
 
-	// Set headers
-	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", customerIOSiteID, customerIOAPIKey)))
-	req.Header.Set("Authorization", "Basic "+auth)
-	req.Header.Set("Content-Type", "application/json")
+// performLinkAction applies a customer link action, any action in actionDefinitions with a link
+// handler. from and to are only used by region moves.
+func performLinkAction(ctx context.Context, email, action, from, to string) (out linkActionOutcome) {
+	out.Status = http.StatusOK
+
+	slog.Info("Processing action", "email", logEmail(email), "action", action)
+
+	definition, known := findLinkAction(action)
+
+	// Email clients and scanners prefetch links, so a repeat of a just-completed action is a no-op.
+	// Repeatable actions (region moves to different regions) are distinct requests and skip this,
+	// as does everything when DB_OPTIONAL left no database to check or queue retries in.
+	alreadyProcessed := false
+	if actionIdempotencyWindow > 0 && known && !definition.repeatable && !databaseDisabled {
+		recent, err := recentlyProcessed(email, action, actionIdempotencyWindow)
+		if err != nil {
+			slog.Warn("Failed to check for recently processed action", "email", logEmail(email), "action", action, "error", err)
+		}
+		alreadyProcessed = recent
+	}
+
+	switch {
+	case !known:
+		slog.Warn("Unknown action requested", "email", logEmail(email), "action", action)
+		out.setMessage("Unknown action requested.")
+		out.Status, out.Err = http.StatusBadRequest, errUnknownAction
+	case alreadyProcessed:
+		out.setSuccessMessage(action, email)
+		out.Success = true
+		slog.Info("Action already processed recently, skipping Customer.io call", "email", logEmail(email), "action", action, "window", actionIdempotencyWindow.String())
+	default:
+		out = definition.link(ctx, email, from, to)
+		// A Customer.io outage shouldn't lose the request: queue it and let the scheduler retry
+		if !out.Success && definition.apply != nil && isTransientActionError(out.Err) && !databaseDisabled {
+			out = queueFailedLinkAction(email, action, linkActionDetails(action, from, to), out)
+		}
+		if out.Status == 0 {
+			out.Status = http.StatusOK
+		}
+	}
+	return out
+}
+
+// recordActionResult logs a Customer.io action to the database, whether it succeeded or failed
+func recordActionResult(email, action string, result TrackResult, actionErr error) {
+	recordActionResultWithDetails(email, action, "", result, actionErr)
+}
+
+// recordActionResultWithDetails is recordActionResult with action-specific details (e.g. regionMoveDetails)
+func recordActionResultWithDetails(email, action, details string, result TrackResult, actionErr error) {
+	if databaseDisabled {
+		slog.Debug("Database disabled, not logging action", "email", logEmail(email), "action", action)
+		return
+	}
+	if _, dbErr := insertEmailProcessingRecordWithResult(email, action, details, result, actionErr); dbErr != nil {
+		slog.Warn("Failed to log action to database", "email", logEmail(email), "action", action, "error", dbErr)
+	}
+}
 
-	// Send request
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+// identifyCustomerByEmail identifies a customer using email as identifier via Customer.io Track API.
+func identifyCustomerByEmail(ctx context.Context, email string) (TrackResult, error) {
+	result, err := customerIO.Identify(ctx, email)
 	if err != nil {
-		log.Printf("ERROR: HTTP request failed: %v", err)
-		return fmt.Errorf("request failed: %w", err)
+		return result, err
+	}
+
+	slog.Info("Identified customer", "email", logEmail(email))
+	return result, nil
+}
+
+// actionErrorMessage returns the user-facing message for a failed action, in English (see messageCatalog)
+func actionErrorMessage(err error, fallback string) string {
+	if errors.Is(err, errAnonymousProfile) {
+		return "This email address is not linked to an identified customer profile yet."
+	}
+	if errors.Is(err, errCustomerNotFound) {
+		return "We couldn't find a customer with this email address. Please check it and try again."
+	}
+	if errors.Is(err, errCredentialsRejected) {
+		return "We can't process requests right now. Please try again later."
+	}
+	if errors.Is(err, errCustomerIOBusy) {
+		return "We're handling a lot of requests right now. Please try again in a moment."
 	}
-	defer resp.Body.Close()
+	if isTrackAPIBadRequest(err) {
+		return "We couldn't process this email address. Please check it and try again."
+	}
+	return fallback
+}
 
-	// Check response
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("ERROR: Customer.io API returned status %d: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+// actionErrorStatus maps a failed Customer.io action to the HTTP status returned to JSON callers
+func actionErrorStatus(err error) int {
+	if errors.Is(err, errAnonymousProfile) {
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, errCustomerNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, errCredentialsRejected) {
+		return http.StatusServiceUnavailable
 	}
+	if errors.Is(err, errCustomerIOBusy) {
+		return http.StatusTooManyRequests
+	}
+	if isTrackAPIBadRequest(err) {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
 
-	log.Printf("Successfully unsubscribed all brands for %s", email)
-	return nil
+// isTrackAPIBadRequest reports whether Customer.io rejected the request itself with 400, typically because
+// of an email address it won't accept, as opposed to a Customer.io outage (5xx) that may succeed later
+func isTrackAPIBadRequest(err error) bool {
+	var apiErr *TrackAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest
+}
+
+// customerIOFailureStatus is the status for a failed Customer.io call in handlers that otherwise answer 500:
+// 503 when Customer.io rejected our credentials, so a misconfigured key stands out from other failures,
+// and 429 when every Customer.io request slot is taken
+func customerIOFailureStatus(err error) int {
+	if errors.Is(err, errCredentialsRejected) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, errCustomerIOBusy) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// actionErrorSummary describes a failed action for JSON callers without exposing Customer.io response bodies
+func actionErrorSummary(err error) string {
+	var apiErr *TrackAPIError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errUnknownAction), errors.Is(err, errInvalidRegionMove):
+		return err.Error()
+	case errors.Is(err, errAnonymousProfile):
+		return "email address is not linked to an identified Customer.io profile"
+	case errors.Is(err, errCustomerNotFound):
+		return "no Customer.io profile exists for this email address"
+	case errors.Is(err, errCredentialsRejected):
+		return "Customer.io rejected the API credentials"
+	case errors.Is(err, errCustomerIOBusy):
+		return "too many concurrent Customer.io requests, try again shortly"
+	case errors.As(err, &apiErr):
+		return fmt.Sprintf("Customer.io %s failed with HTTP %d", apiErr.Operation, apiErr.StatusCode)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "Customer.io request timed out"
+	default:
+		return "request could not be completed"
+	}
+}
+
+// wantsJSON reports whether the caller prefers a JSON response over the HTML pages (Accept: application/json)
+func wantsJSON(c *fiber.Ctx) bool {
+	return c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON
+}
+
+// respondLinkError rejects a customer link request with a JSON error or the minimal error page
+func respondLinkError(c *fiber.Ctx, status int, action, message string) error {
+	if wantsJSON(c) {
+		return c.Status(status).JSON(fiber.Map{
+			"success": false,
+			"action":  action,
+			"error":   message,
+		})
+	}
+	locale := requestLocale(c)
+	return c.Status(status).Render("minimal", fiber.Map{
+		"Message": translate(locale, message),
+		"Success": false,
+		"Locale":  locale,
+	})
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, registered on the default registry and served by GET /metrics
+var (
+	actionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "unsubscribe_matrix_actions_total",
+		Help: "Recorded customer actions by database action type and outcome.",
+	}, []string{"action", "status"})
+
+	customerIORequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "unsubscribe_matrix_customerio_requests_total",
+		Help: "Customer.io Track API requests (each retry counted) by HTTP status code, or \"error\" for connection failures.",
+	}, []string{"status_code"})
+
+	customerIORequestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "unsubscribe_matrix_customerio_request_duration_seconds",
+		Help:    "Latency of individual Customer.io Track API requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	dbInsertFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "unsubscribe_matrix_db_insert_failures_total",
+		Help: "Failed inserts into email_processing_records.",
+	})
+)
+
+// observeCustomerIORequest records the outcome and latency of a single Track API request
+func observeCustomerIORequest(resp *http.Response, err error, started time.Time) {
+	customerIORequestDuration.Observe(time.Since(started).Seconds())
+	if err != nil {
+		customerIORequestsTotal.WithLabelValues("error").Inc()
+		return
+	}
+	customerIORequestsTotal.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+}
+
+// metricsHandler serves the default Prometheus registry in the text exposition format
+func metricsHandler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.Handler())
+}
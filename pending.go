@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	pendingActionPollInterval = 30 * time.Second // How often the scheduler checks for due pending actions
+	pendingRetryBaseDelay     = time.Minute      // Wait before the first retry of a failed action, doubled on each retry
+	pendingRetryMaxDelay      = time.Hour        // Upper bound on the wait between retries
+)
+
+var unsubscribeGraceMinutes int // Minutes to wait before committing an unsubscribe (0 disables the grace period)
+
+// generatePendingActionToken creates a random token used to cancel a pending action
+func generatePendingActionToken() (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(tokenBytes), nil
+}
+
+// scheduleUnsubscribe records an unsubscribe intent that is committed after the grace period.
+// It returns the token needed to cancel the unsubscribe.
+func scheduleUnsubscribe(email string) (string, error) {
+	token, err := generatePendingActionToken()
+	if err != nil {
+		return "", err
+	}
+
+	executeAt := time.Now().Add(time.Duration(unsubscribeGraceMinutes) * time.Minute)
+	if err := insertPendingAction(PendingAction{Token: token, Email: email, Action: "unsubscribe", ExecuteAt: executeAt}); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// isTransientActionError reports whether a failed action may succeed later: Customer.io was unreachable,
+// timed out or answered 429/5xx. Missing customers and requests Customer.io rejected are final.
+func isTransientActionError(err error) bool {
+	var apiErr *TrackAPIError
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errAnonymousProfile), errors.Is(err, errCustomerNotFound),
+		errors.Is(err, errUnknownAction), errors.Is(err, errInvalidRegionMove):
+		return false
+	case errors.As(err, &apiErr):
+		return isRetryableStatus(apiErr.StatusCode)
+	default:
+		return true
+	}
+}
+
+// pendingRetryDelay returns the wait after the given number of failed attempts (base, 2x base, 4x base, ...)
+func pendingRetryDelay(attempts int) time.Duration {
+	delay := pendingRetryBaseDelay
+	for i := 1; i < attempts && delay < pendingRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, pendingRetryMaxDelay)
+}
+
+// queueActionRetry persists an action that failed on a transient error so the scheduler retries it
+// with backoff until it succeeds. Only a summary of the error is stored, never Customer.io response bodies.
+func queueActionRetry(email, action, details string, cause error) error {
+	token, err := generatePendingActionToken()
+	if err != nil {
+		return err
+	}
+
+	return insertPendingAction(PendingAction{
+		Token:     token,
+		Email:     email,
+		Action:    action,
+		Details:   details,
+		Attempts:  1,
+		LastError: actionErrorSummary(cause),
+		ExecuteAt: time.Now().Add(pendingRetryDelay(1)),
+	})
+}
+
+// queueFailedLinkAction queues a link action that failed on a transient error for retry and reports it to
+// the customer as accepted. If the action can't be queued the original failure is returned.
+func queueFailedLinkAction(email, action, details string, failed linkActionOutcome) linkActionOutcome {
+	if err := queueActionRetry(email, action, details, failed.Err); err != nil {
+		log.Printf("ERROR: Failed to queue %s for email %s for retry: %v", action, logEmail(email), err)
+		return failed
+	}
+
+	log.Printf("Queued failed %s for email %s for retry", action, logEmail(email))
+	queued := linkActionOutcome{Success: true, Status: http.StatusAccepted}
+	queued.setMessage("We couldn't reach our email service just now. Your request has been saved and will be applied automatically.")
+	return queued
+}
+
+// startPendingActionScheduler runs due pending actions in the background.
+// The returned function stops the scheduler and waits for any in-progress run to finish.
+func startPendingActionScheduler() (stop func()) {
+	log.Printf("Pending action scheduler started (polling every %s)", pendingActionPollInterval)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(pendingActionPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				processDuePendingActions()
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+		log.Println("Pending action scheduler stopped.")
+	}
+}
+
+// processDuePendingActions runs every pending action whose grace period or retry delay has expired.
+// Actions failing on a transient error are rescheduled with backoff; other failures are final.
+func processDuePendingActions() {
+	actions, err := getDuePendingActions(time.Now())
+	if err != nil {
+		log.Printf("ERROR: Failed to load due pending actions: %v", err)
+		return
+	}
+
+	// Pending actions run after the request that scheduled them has finished
+	ctx := context.Background()
+	for _, action := range actions {
+		// Claim the action so a concurrent cancel cannot race with execution
+		claimed, err := updatePendingActionStatus(action.ID, "PENDING", "PROCESSING")
+		if err != nil {
+			log.Printf("ERROR: Failed to claim pending action %d: %v", action.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		status := "COMPLETED"
+		definition, ok := findAction(action.Action)
+		if !ok || definition.apply == nil {
+			log.Printf("ERROR: Unknown pending action '%s' for id %d", action.Action, action.ID)
+			status = "FAILED"
+		} else if err := definition.apply(ctx, action.Email, action.Details); err == nil {
+			log.Printf("Committed pending %s for email %s", action.Action, logEmail(action.Email))
+		} else if isTransientActionError(err) {
+			attempts := action.Attempts + 1
+			retryAt := time.Now().Add(pendingRetryDelay(attempts))
+			log.Printf("WARNING: Pending %s for email %s failed (attempt %d), retrying at %s: %v",
+				action.Action, logEmail(action.Email), attempts, retryAt.Format(time.RFC3339), err)
+			if err := reschedulePendingAction(action.ID, retryAt, actionErrorSummary(err)); err != nil {
+				log.Printf("ERROR: Failed to reschedule pending action %d: %v", action.ID, err)
+			}
+			continue
+		} else {
+			log.Printf("ERROR: Failed to commit pending %s for email %s: %v", action.Action, logEmail(action.Email), err)
+			status = "FAILED"
+		}
+
+		if _, err := updatePendingActionStatus(action.ID, "PROCESSING", status); err != nil {
+			log.Printf("ERROR: Failed to mark pending action %d as %s: %v", action.ID, status, err)
+		}
+	}
+}
+
+// handleCancelUnsubscribe cancels a pending unsubscribe before its grace period expires
+func handleCancelUnsubscribe(c *fiber.Ctx) error {
+	locale := requestLocale(c)
+	token := c.Query("token")
+	log.Printf("GET /cancel-unsubscribe request received from IP: %s", c.IP())
+
+	if token == "" {
+		return c.Status(400).Render("minimal", fiber.Map{
+			"Message": translate(locale, "Missing cancel token."),
+			"Success": false,
+			"Locale":  locale,
+		})
+	}
+
+	email, err := cancelPendingAction(token)
+	if err != nil {
+		log.Printf("ERROR: Failed to cancel pending unsubscribe: %v", err)
+		return c.Status(500).Render("minimal", fiber.Map{
+			"Message": translate(locale, "Error cancelling unsubscribe request. Please try again."),
+			"Success": false,
+			"Locale":  locale,
+		})
+	}
+
+	if email == "" {
+		log.Printf("Cancel requested for unknown or already processed token")
+		return c.Status(404).Render("minimal", fiber.Map{
+			"Message": translate(locale, "This unsubscribe request can no longer be cancelled."),
+			"Success": false,
+			"Locale":  locale,
+		})
+	}
+
+	log.Printf("Cancelled pending unsubscribe for email %s", logEmail(email))
+	return c.Render("minimal", fiber.Map{
+		"Message": translate(locale, "Your unsubscribe request for %s has been cancelled.", email),
+		"Success": true,
+		"Locale":  locale,
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// subscriptionState converts a stored subscription attribute to one of the three preference states.
+// The App API returns attribute values as strings, but booleans are accepted too.
+func subscriptionState(value interface{}) string {
+	switch value {
+	case true, "true":
+		return "true"
+	case false, "false":
+		return "false"
+	default:
+		return "none"
+	}
+}
+
+// subscriptionChangeDetails returns the details recorded for a subscription update: each requested brand
+// mapped to {"from": prior state, "to": requested state}, so disputes about preference changes can be
+// answered later. The prior states are read from the App API before the update; without an App API key,
+// or if the lookup fails, only the requested states are recorded.
+func subscriptionChangeDetails(ctx context.Context, identifier string, requested map[string]string) map[string]interface{} {
+	details := make(map[string]interface{}, len(requested))
+	for key, value := range requested {
+		details[key] = value
+	}
+	if customerIO.AppAPIKey == "" {
+		return details
+	}
+
+	attributes, _, err := customerIO.CustomerAttributes(ctx, identifier)
+	if err != nil {
+		slog.Warn("Failed to read prior subscription states, recording requested states only", "email", logEmail(identifier), "error", err)
+		return details
+	}
+	// A customer without a profile had no preference for any brand
+	for key, value := range requested {
+		details[key] = map[string]interface{}{"from": subscriptionState(attributes[key]), "to": value}
+	}
+	return details
+}
+
+// handleGetPreferences returns the customer's current brand subscription states so the preference
+// page can pre-fill its checkboxes. Customers without a profile get "none" for every brand.
+// It is protected like POST /update-subscriptions: a CSRF token issued by GET / and the link signature.
+// Like the POST endpoints it takes an id (Customer.io customer ID) instead of an email.
+func handleGetPreferences(c *fiber.Ctx) error {
+	email, err := validateCustomerIdentifier(c.Query("email"), c.Query("id"))
+	if err != nil {
+		slog.Warn("Rejected invalid customer identifier parameter", "ip", c.IP(), "error", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Please provide a valid email address or customer ID",
+		})
+	}
+
+	if err := verifyCSRFToken(c, email, requestCSRFToken(c, c.Query("csrf_token"))); err != nil {
+		slog.Warn("Rejected request with invalid CSRF token", "email", logEmail(email), "ip", c.IP(), "error", err)
+		return c.Status(403).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid or expired form token, please reload the page",
+		})
+	}
+
+	if !checkLinkSignature(email, c.Query("sig"), c.IP()) {
+		return c.Status(403).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid link signature",
+		})
+	}
+
+	if customerIO.AppAPIKey == "" {
+		return c.Status(503).JSON(fiber.Map{
+			"success": false,
+			"message": "Preference lookup is not configured",
+		})
+	}
+
+	attributes, found, err := customerIO.CustomerAttributes(c.Context(), email)
+	if err != nil {
+		slog.Error("Failed to load subscription preferences", "email", logEmail(email), "error", err)
+		return c.Status(customerIOFailureStatus(err)).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to load preferences",
+		})
+	}
+
+	subscriptions := make(map[string]string, len(subscriptionKeys))
+	for _, key := range subscriptionKeys {
+		subscriptions[key] = subscriptionState(attributes[key])
+	}
+
+	slog.Debug("Loaded subscription preferences", "email", logEmail(email), "found", found)
+	return c.JSON(fiber.Map{
+		"success":       true,
+		"found":         found,
+		"subscriptions": subscriptions,
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+const (
+	defaultRateLimitPerMinute      = 30  // Public action requests per IP per minute
+	defaultAdminRateLimitPerMinute = 300 // Admin dashboard requests per IP per minute
+)
+
+// clientIP returns the originating client IP, using the header set by the fly.io proxy in production
+func clientIP(c *fiber.Ctx) string {
+	if isProduction() {
+		if ip := c.Get("Fly-Client-IP"); ip != "" {
+			return ip
+		}
+	}
+	return c.IP()
+}
+
+// rateLimitFromEnv reads a per-minute limit from the environment; 0 disables the limit
+func rateLimitFromEnv(name string, defaultLimit int) int {
+	limitStr := os.Getenv(name)
+	if limitStr == "" {
+		return defaultLimit
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 0 {
+		log.Printf("WARNING: Invalid %s '%s', using default %d", name, limitStr, defaultLimit)
+		return defaultLimit
+	}
+	return limit
+}
+
+// newRateLimiter returns a per-IP fixed-window limiter allowing perMinute requests per minute.
+// Requests over the limit get 429 with a Retry-After header.
+func newRateLimiter(name string, perMinute int) fiber.Handler {
+	if perMinute == 0 {
+		log.Printf("Rate limiting disabled for %s routes.", name)
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	log.Printf("Rate limiting %s routes to %d requests per minute per IP.", name, perMinute)
+	return limiter.New(limiter.Config{
+		Max:          perMinute,
+		Expiration:   time.Minute,
+		KeyGenerator: clientIP,
+		LimitReached: func(c *fiber.Ctx) error {
+			log.Printf("WARNING: %s rate limit exceeded by IP %s on %s", name, clientIP(c), c.Path())
+			return c.Status(429).SendString("Too Many Requests")
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	actionRedirectURL    string          // REDIRECT_AFTER_ACTION; empty renders the outcome inline
+	redirectAllowedHosts map[string]bool // Hosts a link's redirect parameter may send the customer to
+)
+
+// configureActionRedirect loads REDIRECT_AFTER_ACTION and REDIRECT_ALLOWED_HOSTS. The default
+// redirect's own host is always allowed, so per-campaign pages on the same site need no allowlist.
+func configureActionRedirect() {
+	redirectAllowedHosts = make(map[string]bool)
+	for _, host := range strings.Split(os.Getenv("REDIRECT_ALLOWED_HOSTS"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			redirectAllowedHosts[host] = true
+		}
+	}
+
+	actionRedirectURL = ""
+	if target := strings.TrimSpace(os.Getenv("REDIRECT_AFTER_ACTION")); target != "" {
+		parsed, ok := parseRedirectURL(target)
+		if !ok {
+			log.Printf("WARNING: Invalid REDIRECT_AFTER_ACTION '%s' (need an absolute http or https URL), showing outcomes inline", target)
+		} else {
+			actionRedirectURL = target
+			redirectAllowedHosts[strings.ToLower(parsed.Hostname())] = true
+		}
+	}
+
+	if actionRedirectURL != "" {
+		log.Printf("Customers will be redirected to %s after link actions.", actionRedirectURL)
+	}
+	if len(redirectAllowedHosts) > 0 {
+		log.Printf("Link redirect parameters may point to %d allowed hosts.", len(redirectAllowedHosts))
+	}
+}
+
+// parseRedirectURL parses an absolute http(s) URL
+func parseRedirectURL(target string) (*url.URL, bool) {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return nil, false
+	}
+	return parsed, true
+}
+
+// actionRedirectTarget picks where to send the customer after a link action: the link's redirect
+// parameter when its host is allowed, otherwise REDIRECT_AFTER_ACTION. It returns nil to render inline.
+func actionRedirectTarget(requested string) *url.URL {
+	if requested != "" {
+		parsed, ok := parseRedirectURL(requested)
+		if ok && redirectAllowedHosts[strings.ToLower(parsed.Hostname())] {
+			return parsed
+		}
+		slog.Warn("Ignoring redirect parameter to a host not in REDIRECT_ALLOWED_HOSTS", "redirect", requested)
+	}
+	if actionRedirectURL == "" {
+		return nil
+	}
+	parsed, _ := parseRedirectURL(actionRedirectURL)
+	return parsed
+}
+
+// redirectWithOutcome sends the customer to target with the action's outcome added to its query
+// string: success, action, status (the HTTP status JSON callers would get), lang (the customer's
+// locale) and, for a deferred unsubscribe, cancel_url. Messages name the customer, so they are left
+// out to keep emails out of another site's logs and analytics.
+func redirectWithOutcome(c *fiber.Ctx, target *url.URL, locale, action string, outcome linkActionOutcome) error {
+	query := target.Query()
+	query.Set("success", strconv.FormatBool(outcome.Success))
+	query.Set("action", action)
+	query.Set("status", strconv.Itoa(outcome.Status))
+	query.Set("lang", locale)
+	if outcome.CancelURL != "" {
+		query.Set("cancel_url", c.BaseURL()+outcome.CancelURL)
+	}
+	target.RawQuery = query.Encode()
+
+	slog.Debug("Redirecting after link action", "action", action, "success", outcome.Success, "host", target.Host)
+	return c.Redirect(target.String(), fiber.StatusFound)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const maxRetryDelay = 30 * time.Second // Upper bound on any single backoff or Retry-After wait
+
+var (
+	customerIOMaxRetries     = 3                      // Retries after the first attempt for Track API calls
+	customerIORetryBaseDelay = 200 * time.Millisecond // Backoff before the first retry, doubled on each retry
+)
+
+// configureRetries reads the Track API retry settings from environment variables
+func configureRetries() {
+	if retriesStr := os.Getenv("CUSTOMERIO_MAX_RETRIES"); retriesStr != "" {
+		retries, err := strconv.Atoi(retriesStr)
+		if err != nil || retries < 0 {
+			log.Printf("WARNING: Invalid CUSTOMERIO_MAX_RETRIES '%s', using default %d", retriesStr, customerIOMaxRetries)
+		} else {
+			customerIOMaxRetries = retries
+		}
+	}
+
+	if delayStr := os.Getenv("CUSTOMERIO_RETRY_BASE_DELAY_MS"); delayStr != "" {
+		delayMs, err := strconv.Atoi(delayStr)
+		if err != nil || delayMs <= 0 {
+			log.Printf("WARNING: Invalid CUSTOMERIO_RETRY_BASE_DELAY_MS '%s', using default %s", delayStr, customerIORetryBaseDelay)
+		} else {
+			customerIORetryBaseDelay = time.Duration(delayMs) * time.Millisecond
+		}
+	}
+
+	log.Printf("Customer.io retries configured: max %d retries, base delay %s", customerIOMaxRetries, customerIORetryBaseDelay)
+}
+
+// isRetryableStatus reports whether a Track API status code indicates a transient failure
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryDelay returns the wait before the given retry (1-based), honoring Retry-After when present
+func retryDelay(retry int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+				// Compare in seconds so a huge Retry-After can't overflow the Duration
+				if seconds >= int(maxRetryDelay/time.Second) {
+					return maxRetryDelay
+				}
+				return time.Duration(seconds) * time.Second
+			}
+			if retryAt, err := http.ParseTime(retryAfter); err == nil {
+				return min(max(time.Until(retryAt), 0), maxRetryDelay)
+			}
+		}
+	}
+
+	// Exponential backoff (base, 2x base, 4x base, ...) plus up to 50% jitter. Doubling stops at
+	// maxRetryDelay so a large CUSTOMERIO_MAX_RETRIES can't overflow the delay.
+	delay := customerIORetryBaseDelay
+	for i := 1; i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+	delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return min(delay, maxRetryDelay)
+}
+
+// doTrackRequestWithRetry sends a Track API request, retrying connection errors and 429/5xx
+// responses with exponential backoff. It gives up after maxRetries retries and returns the
+// last response or error, along with the number of retries that were made. Backoff waits
+// end early if the request's context is cancelled. Each attempt first takes a Customer.io
+// concurrency slot, released when the response body is closed.
+func doTrackRequestWithRetry(client *http.Client, req *http.Request, maxRetries int) (*http.Response, int, error) {
+	for attempt := 0; ; attempt++ {
+		// Rewind the body for retries; the first attempt uses the original body
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, attempt, fmt.Errorf("error resetting request body for retry: %w", err)
+			}
+			req.Body = body
+		}
+
+		// Hold a concurrency slot for each attempt, but not across backoff waits
+		release, err := acquireCustomerIOSlot(req.Context())
+		if err != nil {
+			return nil, attempt, err
+		}
+		started := time.Now()
+		resp, err := client.Do(req)
+		observeCustomerIORequest(resp, err, started)
+		if err != nil {
+			release()
+		} else {
+			resp.Body = &slotReleasingBody{ReadCloser: resp.Body, release: release}
+		}
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, attempt, nil
+		}
+
+		if attempt >= maxRetries {
+			if err != nil {
+				return nil, attempt, fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+			}
+			slog.Warn("Track API still failing, giving up", "request_id", req.Header.Get("X-Request-ID"), "status_code", resp.StatusCode, "attempts", attempt+1)
+			return resp, attempt, nil
+		}
+
+		delay := retryDelay(attempt+1, resp)
+		if err != nil {
+			slog.Warn("Track API request failed, retrying", "request_id", req.Header.Get("X-Request-ID"), "attempt", attempt+1, "max_attempts", maxRetries+1, "delay", delay.String(), "error", err)
+		} else {
+			slog.Warn("Track API returned retryable status, retrying", "request_id", req.Header.Get("X-Request-ID"), "status_code", resp.StatusCode, "attempt", attempt+1, "max_attempts", maxRetries+1, "delay", delay.String())
+			// Drain and close so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		// Stop waiting if the caller's context is cancelled (e.g. the server is shutting down)
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, attempt, fmt.Errorf("giving up after %d attempts: %w", attempt+1, req.Context().Err())
+		case <-timer.C:
+		}
+	}
+}
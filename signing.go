@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	linkSigningSecret     string // Secret used to sign customer links (LINK_SIGNING_SECRET)
+	enforceSignedLinks    bool   // Reject unsigned customer requests (ENFORCE_SIGNED_LINKS_PROD in production)
+	actionTokenSecret     string // Secret used to sign action tokens (URL_SIGNING_SECRET, falling back to LINK_SIGNING_SECRET)
+	allowLegacyEmailLinks bool   // Accept plaintext email/cio query links without an action token (ALLOW_LEGACY_EMAIL_LINKS)
+
+	actionTokenTTL           = 90 * 24 * time.Hour // How long action tokens stay valid (TOKEN_TTL, 0 disables expiry)
+	acceptLegacyActionTokens = true                // Accept tokens without an issued-at time (LEGACY_TOKEN_POLICY)
+)
+
+// actionTokenClockSkew tolerates small clock differences between the link generator and this server
+const actionTokenClockSkew = 2 * time.Minute
+
+// actionTokenPreferences is the token action that opens the preference page without changing anything
+const actionTokenPreferences = "preferences"
+
+// configureLinkSigning loads the link signing secret and enforcement setting
+func configureLinkSigning() {
+	linkSigningSecret = os.Getenv("LINK_SIGNING_SECRET")
+	enforceSignedLinks = os.Getenv("ENFORCE_SIGNED_LINKS_PROD") == "true" && isProduction()
+
+	if enforceSignedLinks && linkSigningSecret == "" {
+		log.Fatalln("CRITICAL: ENFORCE_SIGNED_LINKS_PROD is set but LINK_SIGNING_SECRET is not.")
+	}
+
+	actionTokenSecret = os.Getenv("URL_SIGNING_SECRET")
+	if actionTokenSecret == "" {
+		actionTokenSecret = linkSigningSecret
+	}
+	allowLegacyEmailLinks = os.Getenv("ALLOW_LEGACY_EMAIL_LINKS") != "false"
+
+	if ttlStr := os.Getenv("TOKEN_TTL"); ttlStr != "" {
+		ttl, err := parseTokenTTL(ttlStr)
+		if err != nil {
+			log.Printf("WARNING: Invalid TOKEN_TTL: %v, using default %s", err, actionTokenTTL)
+		} else {
+			actionTokenTTL = ttl
+		}
+	}
+	switch policy := os.Getenv("LEGACY_TOKEN_POLICY"); policy {
+	case "", "accept":
+	case "reject":
+		acceptLegacyActionTokens = false
+	default:
+		log.Printf("WARNING: Invalid LEGACY_TOKEN_POLICY '%s', accepting tokens without an issued-at time", policy)
+	}
+	log.Printf("Action tokens expire after %s (0s = never); tokens without issued-at accepted: %t", actionTokenTTL, acceptLegacyActionTokens)
+
+	if !allowLegacyEmailLinks && actionTokenSecret == "" {
+		log.Fatalln("CRITICAL: ALLOW_LEGACY_EMAIL_LINKS is false but neither URL_SIGNING_SECRET nor LINK_SIGNING_SECRET is set.")
+	}
+	if allowLegacyEmailLinks {
+		log.Println("Legacy email/cio query links accepted (set ALLOW_LEGACY_EMAIL_LINKS=false to require action tokens).")
+	} else {
+		log.Println("Legacy email/cio query links rejected - customer links must carry an action token.")
+	}
+
+	switch {
+	case enforceSignedLinks:
+		log.Println("Signed links enforced - unsigned customer requests will be rejected.")
+	case linkSigningSecret != "":
+		log.Println("Link signing enabled - signatures verified when present, unsigned requests still accepted.")
+	default:
+		log.Println("Link signing disabled (LINK_SIGNING_SECRET not set).")
+	}
+}
+
+// signLink returns the hex HMAC-SHA256 signature for a customer identifier (email or customer ID)
+func signLink(identifier string) string {
+	mac := hmac.New(sha256.New, []byte(linkSigningSecret))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(identifier))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkLinkSignature reports whether a customer request may proceed.
+// Invalid signatures are always rejected; missing signatures are rejected only when enforcement is on.
+func checkLinkSignature(identifier, signature, clientIP string) bool {
+	if linkSigningSecret == "" {
+		return true
+	}
+
+	if signature == "" {
+		if enforceSignedLinks {
+			log.Printf("REJECTED: Unsigned request for %s from IP %s (ENFORCE_SIGNED_LINKS_PROD)", logEmail(identifier), clientIP)
+			return false
+		}
+		log.Printf("WARNING: Unsigned request for %s from IP %s accepted (would be rejected with ENFORCE_SIGNED_LINKS_PROD)", logEmail(identifier), clientIP)
+		return true
+	}
+
+	if !hmac.Equal([]byte(signLink(identifier)), []byte(strings.ToLower(signature))) {
+		log.Printf("REJECTED: Invalid link signature for %s from IP %s", logEmail(identifier), clientIP)
+		return false
+	}
+
+	return true
+}
+
+// verifyLinkCredential checks that an app client, which has no CSRF session, holds the customer's link:
+// an action token for identifier and one of actions, or a valid link signature for identifier. Unlike
+// checkLinkSignature it refuses requests carrying neither, even when unsigned links are accepted.
+func verifyLinkCredential(identifier, signature, token string, actions ...string) error {
+	if token != "" {
+		tokenEmail, tokenAction, err := verifyActionToken(token)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(tokenEmail, identifier) || !slices.Contains(actions, tokenAction) {
+			return fmt.Errorf("%w: issued for another customer or action", errInvalidActionToken)
+		}
+		return nil
+	}
+
+	if signature == "" {
+		return errors.New("no link signature or action token")
+	}
+	if linkSigningSecret == "" {
+		return errors.New("link signatures require LINK_SIGNING_SECRET")
+	}
+	if !hmac.Equal([]byte(signLink(identifier)), []byte(strings.ToLower(signature))) {
+		return errors.New("invalid link signature")
+	}
+	return nil
+}
+
+// errInvalidActionToken is returned when an action token is malformed or its signature does not match
+var errInvalidActionToken = errors.New("invalid action token")
+
+// errActionTokenExpired is returned for correctly signed tokens older than TOKEN_TTL
+var errActionTokenExpired = errors.New("action token expired")
+
+// generateActionToken returns a URL-safe token binding an email to an action, signed with actionTokenSecret.
+// The token is "<base64url(action|email|issued-at unix seconds)>.<base64url(hmac)>".
+func generateActionToken(email, action string) string {
+	claims := fmt.Sprintf("%s|%s|%d", action, strings.ToLower(strings.TrimSpace(email)), time.Now().Unix())
+	payload := base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return payload + "." + signActionPayload(payload)
+}
+
+// signActionPayload signs an encoded token payload; the prefix keeps these signatures distinct from link signatures
+func signActionPayload(payload string) string {
+	mac := hmac.New(sha256.New, []byte(actionTokenSecret))
+	mac.Write([]byte("action:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyActionToken checks an action token's signature and age and returns the email and action it carries.
+// Expired tokens return errActionTokenExpired; tokens without an issued-at time follow LEGACY_TOKEN_POLICY.
+func verifyActionToken(token string) (email, action string, err error) {
+	claims, err := parseActionToken(token)
+	if err != nil {
+		return "", "", err
+	}
+	return claims.Email, claims.Action, nil
+}
+
+// actionTokenClaims is what a correctly signed action token carries
+type actionTokenClaims struct {
+	Email    string
+	Action   string
+	IssuedAt time.Time // Zero for tokens issued before expiry support
+}
+
+// parseActionToken checks an action token like verifyActionToken but returns all of its claims. A correctly
+// signed token that has expired returns its claims along with errActionTokenExpired.
+func parseActionToken(token string) (actionTokenClaims, error) {
+	var claims actionTokenClaims
+	if actionTokenSecret == "" {
+		return claims, errors.New("action tokens require URL_SIGNING_SECRET or LINK_SIGNING_SECRET")
+	}
+
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signActionPayload(payload)), []byte(signature)) {
+		return claims, errInvalidActionToken
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, errInvalidActionToken
+	}
+	// The email may itself contain '|', so the action ends at the first separator and the issued-at
+	// time starts after the last one. Email domains can't contain '|', so a final part with an '@'
+	// is still the email.
+	action, email, _ := strings.Cut(string(decoded), "|")
+	issued := ""
+	if sep := strings.LastIndex(email, "|"); sep >= 0 && !strings.Contains(email[sep+1:], "@") {
+		email, issued = email[:sep], email[sep+1:]
+	}
+	if action == "" || email == "" {
+		return claims, errInvalidActionToken
+	}
+
+	// Tokens issued before expiry support carry no timestamp
+	if issued == "" {
+		claims = actionTokenClaims{Email: email, Action: action}
+		if !acceptLegacyActionTokens {
+			return claims, fmt.Errorf("%w: token has no issued-at time (LEGACY_TOKEN_POLICY=reject)", errActionTokenExpired)
+		}
+		return claims, nil
+	}
+
+	issuedUnix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return claims, errInvalidActionToken
+	}
+	issuedAt := time.Unix(issuedUnix, 0)
+	if time.Until(issuedAt) > actionTokenClockSkew {
+		return claims, fmt.Errorf("%w: issued in the future", errInvalidActionToken)
+	}
+	claims = actionTokenClaims{Email: email, Action: action, IssuedAt: issuedAt}
+	if actionTokenTTL > 0 && time.Since(issuedAt) > actionTokenTTL+actionTokenClockSkew {
+		return claims, errActionTokenExpired
+	}
+
+	return claims, nil
+}
+
+// expiresAt returns when the token stops being accepted, or the zero time if it never expires
+func (claims actionTokenClaims) expiresAt() time.Time {
+	if claims.IssuedAt.IsZero() || actionTokenTTL <= 0 {
+		return time.Time{}
+	}
+	return claims.IssuedAt.Add(actionTokenTTL)
+}
+
+// parseTokenTTL parses TOKEN_TTL as a Go duration ("2160h") or a number of days ("90d")
+func parseTokenTTL(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid day count %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return ttl, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// validateEmail trims and lowercases an email address and rejects anything that is not a bare address
+func validateEmail(email string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(email))
+	if normalized == "" {
+		return "", fmt.Errorf("email address is required")
+	}
+
+	addr, err := mail.ParseAddress(normalized)
+	if err != nil {
+		return "", fmt.Errorf("invalid email address: %w", err)
+	}
+
+	// Reject display-name forms like "Name <a@b.com>" and anything ParseAddress rewrote
+	if addr.Address != normalized {
+		return "", fmt.Errorf("invalid email address: must be a bare address")
+	}
+
+	if strings.ContainsAny(normalized, "/\\?#") {
+		return "", fmt.Errorf("invalid email address: contains reserved characters")
+	}
+
+	return normalized, nil
+}
+
+// maxCustomerIDLength is the longest customer ID Customer.io accepts
+const maxCustomerIDLength = 150
+
+// validateCustomerID trims a Customer.io customer ID and rejects anything that can't be used as a Track API
+// path segment. IDs may not contain '@', so an identifier is an email exactly when it has one.
+func validateCustomerID(id string) (string, error) {
+	trimmed := strings.TrimSpace(id)
+	if trimmed == "" {
+		return "", fmt.Errorf("customer id is required")
+	}
+	if len(trimmed) > maxCustomerIDLength {
+		return "", fmt.Errorf("invalid customer id: longer than %d characters", maxCustomerIDLength)
+	}
+	if strings.ContainsAny(trimmed, "@/\\?# \t\r\n") {
+		return "", fmt.Errorf("invalid customer id: contains reserved characters")
+	}
+	return trimmed, nil
+}
+
+// validateCustomerIdentifier validates how a request identifies its customer: by email, or by Customer.io
+// customer ID when no email is given. It returns the identifier to send to Customer.io.
+func validateCustomerIdentifier(email, id string) (string, error) {
+	if strings.TrimSpace(email) != "" {
+		return validateEmail(email)
+	}
+	if strings.TrimSpace(id) != "" {
+		return validateCustomerID(id)
+	}
+	return "", fmt.Errorf("an email address or customer id is required")
+}
+
+// isEmailIdentifier reports whether a validated customer identifier is an email rather than a customer ID
+func isEmailIdentifier(identifier string) bool {
+	return strings.Contains(identifier, "@")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// linkVerification is the GET /verify response describing what a customer link points to
+type linkVerification struct {
+	Valid      bool   `json:"valid"`
+	Message    string `json:"message"`
+	Email      string `json:"email,omitempty"`
+	CustomerID string `json:"customer_id,omitempty"`
+	Action     string `json:"action,omitempty"` // Empty when the link only opens the preference page
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	Token      bool   `json:"token"`                // The link carries a signed action token
+	IssuedAt   string `json:"issued_at,omitempty"`  // RFC 3339; absent for tokens issued without a timestamp
+	ExpiresAt  string `json:"expires_at,omitempty"` // RFC 3339; absent when the token never expires
+}
+
+// handleVerifyLink is a QA aid for links in email templates. It checks a link's token (or legacy
+// email/cio, action and sig parameters) the way GET / does and reports the customer and action it
+// points to as JSON. It never confirms or applies the action, calls Customer.io or writes to the database.
+func handleVerifyLink(c *fiber.Ctx) error {
+	out := linkVerification{Action: c.Query("action"), From: strings.ToUpper(c.Query("from")), To: strings.ToUpper(c.Query("to"))}
+	email, cioID := c.Query("email"), c.Query("cio")
+
+	if token := c.Query("token"); token != "" {
+		out.Token = true
+		claims, err := parseActionToken(token)
+		if !claims.IssuedAt.IsZero() {
+			out.IssuedAt = claims.IssuedAt.UTC().Format(time.RFC3339)
+			if expiresAt := claims.expiresAt(); !expiresAt.IsZero() {
+				out.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+			}
+		}
+		if errors.Is(err, errActionTokenExpired) {
+			out.Email, out.Action = claims.Email, claims.Action
+			return verificationFailed(c, fiber.StatusGone, out, "Token expired: "+err.Error())
+		}
+		if err != nil {
+			return verificationFailed(c, fiber.StatusForbidden, out, "Token rejected: "+err.Error())
+		}
+		email, cioID, out.Action = claims.Email, "", claims.Action
+		if out.Action == actionTokenPreferences {
+			out.Action = ""
+		}
+	} else {
+		if email == "" && cioID == "" {
+			return verificationFailed(c, fiber.StatusBadRequest, out, "Provide a token, or an email or cio parameter")
+		}
+		if !allowLegacyEmailLinks {
+			return verificationFailed(c, fiber.StatusForbidden, out, "Links without an action token are rejected (ALLOW_LEGACY_EMAIL_LINKS=false)")
+		}
+	}
+
+	if email != "" {
+		normalizedEmail, err := validateEmail(email)
+		if err != nil {
+			out.Email = email
+			return verificationFailed(c, fiber.StatusBadRequest, out, "Invalid email: "+err.Error())
+		}
+		out.Email = normalizedEmail
+	} else {
+		normalizedID, err := validateCustomerID(cioID)
+		if err != nil {
+			out.CustomerID = cioID
+			return verificationFailed(c, fiber.StatusBadRequest, out, "Invalid customer ID: "+err.Error())
+		}
+		out.CustomerID = normalizedID
+		// Bare cio= links predate the action parameter and always pause
+		if out.Action == "" {
+			out.Action = "pause"
+		}
+	}
+
+	identifier := out.Email
+	if identifier == "" {
+		identifier = out.CustomerID
+	}
+	if !out.Token && !checkLinkSignature(identifier, c.Query("sig"), c.IP()) {
+		return verificationFailed(c, fiber.StatusForbidden, out, "Link signature (sig) is missing or does not match")
+	}
+
+	if out.Action != "" {
+		if _, known := findLinkAction(out.Action); !known {
+			return verificationFailed(c, fiber.StatusBadRequest, out, "Unknown action "+out.Action)
+		}
+	}
+	if out.Action == "region" && (!regionObjectIDs[out.From] || !regionObjectIDs[out.To] || out.From == out.To) {
+		return verificationFailed(c, fiber.StatusBadRequest, out, "Invalid region move: from and to must be different REGION_OBJECT_IDS")
+	}
+	if out.Action != "region" {
+		out.From, out.To = "", ""
+	}
+
+	out.Valid = true
+	out.Message = "Link is valid. Nothing was changed."
+	slog.Info("Verified link", "email", logEmail(identifier), "action", out.Action, "token", out.Token, "ip", c.IP())
+	return c.JSON(out)
+}
+
+// verificationFailed answers GET /verify for a link that would be rejected, with the reason
+func verificationFailed(c *fiber.Ctx, status int, out linkVerification, message string) error {
+	slog.Info("Link verification failed", "status_code", status, "reason", message, "ip", c.IP())
+	out.Valid, out.Message = false, message
+	return c.Status(status).JSON(out)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"runtime"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Build information, set at build time with
+// -ldflags "-X main.version=<version> -X main.commit=<sha> -X main.buildTime=<RFC 3339 time>"
+var (
+	version   = "dev"     // Release version, also sent in the Customer.io User-Agent
+	commit    = "unknown" // Git commit the binary was built from
+	buildTime = "unknown" // When the binary was built
+)
+
+// handleVersion reports the build information of the running binary
+func handleVersion(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"version":    version,
+		"commit":     commit,
+		"build_time": buildTime,
+		"go_version": runtime.Version(),
+	})
+}
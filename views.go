@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/template/html/v2"
+)
+
+// requiredViews are the templates handlers render; each is <name>.html in the views directory
+var requiredViews = []string{"index", "minimal", "confirm", "error", "results", "customer", "audit"}
+
+// loadViews parses the templates in VIEWS_DIR (default ./views) up front, so a deploy started from
+// the wrong working directory fails at startup naming the path instead of returning 500s on render.
+func loadViews() (*html.Engine, error) {
+	dir := os.Getenv("VIEWS_DIR")
+	if dir == "" {
+		dir = "./views"
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("views directory %s not found (set VIEWS_DIR or start from the directory containing views/)", absDir)
+	}
+	engine := html.New(dir, ".html")
+	// {{t .Locale "English text"}} translates page text; pages rendered without a Locale get English
+	engine.AddFunc("t", func(locale interface{}, format string, args ...interface{}) string {
+		localeName, _ := locale.(string)
+		return translate(localeName, format, args...)
+	})
+	if err := engine.Load(); err != nil {
+		return nil, fmt.Errorf("failed to parse templates in %s: %w", absDir, err)
+	}
+
+	var missing []string
+	for _, name := range requiredViews {
+		if engine.Templates.Lookup(name) == nil {
+			missing = append(missing, name+".html")
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("views directory %s is missing %s", absDir, strings.Join(missing, ", "))
+	}
+	return engine, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var customerIOWebhookSecret string // Signing key for Customer.io reporting webhooks (CUSTOMERIO_WEBHOOK_SECRET)
+
+// webhookTimestampTolerance is how far X-CIO-Timestamp may be from now, so a captured delivery can't be replayed later
+const webhookTimestampTolerance = 5 * time.Minute
+
+// webhookActions maps the Customer.io reporting webhook metrics we record to request actions.
+// Other metrics (sent, opened, clicked, ...) are acknowledged and ignored.
+var webhookActions = map[string]string{
+	"unsubscribed":  "cio_unsubscribed",
+	"spammed":       "cio_spam_reported",
+	"spam_reported": "cio_spam_reported",
+	"bounced":       "cio_bounced",
+}
+
+// CustomerIOWebhookEvent is the subset of a Customer.io reporting webhook payload we use
+type CustomerIOWebhookEvent struct {
+	EventID    string `json:"event_id"`
+	ObjectType string `json:"object_type"`
+	Metric     string `json:"metric"`
+	Timestamp  int64  `json:"timestamp"`
+	Data       struct {
+		CustomerID   string `json:"customer_id"`
+		EmailAddress string `json:"email_address"`
+		Identifiers  struct {
+			Email string `json:"email"`
+		} `json:"identifiers"`
+	} `json:"data"`
+}
+
+// email returns the customer's email address from whichever field the event carries it in
+func (e CustomerIOWebhookEvent) email() string {
+	if e.Data.EmailAddress != "" {
+		return e.Data.EmailAddress
+	}
+	return e.Data.Identifiers.Email
+}
+
+// configureWebhook loads the Customer.io webhook signing key
+func configureWebhook() {
+	customerIOWebhookSecret = os.Getenv("CUSTOMERIO_WEBHOOK_SECRET")
+	if customerIOWebhookSecret == "" {
+		log.Println("WARNING: CUSTOMERIO_WEBHOOK_SECRET not set - POST /webhooks/customerio will reject every request.")
+	}
+}
+
+// verifyWebhookSignature checks X-CIO-Signature, the hex HMAC-SHA256 of "v0:<X-CIO-Timestamp>:<body>"
+func verifyWebhookSignature(timestamp string, body []byte, signature string) bool {
+	if customerIOWebhookSecret == "" || timestamp == "" || signature == "" {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(customerIOWebhookSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(strings.ToLower(signature)))
+}
+
+// webhookTimestampFresh reports whether X-CIO-Timestamp (unix seconds) is within webhookTimestampTolerance of now
+func webhookTimestampFresh(timestamp string, now time.Time) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	return skew <= webhookTimestampTolerance && skew >= -webhookTimestampTolerance
+}
+
+// handleCustomerIOWebhook records suppression events reported by Customer.io (unsubscribes,
+// spam complaints, bounces) so the audit trail covers changes not made through our links
+func handleCustomerIOWebhook(c *fiber.Ctx) error {
+	body := c.Body()
+	if !verifyWebhookSignature(c.Get("X-CIO-Timestamp"), body, c.Get("X-CIO-Signature")) {
+		slog.Warn("Rejected Customer.io webhook with invalid signature", "ip", c.IP())
+		return c.Status(401).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid signature",
+		})
+	}
+
+	if timestamp := c.Get("X-CIO-Timestamp"); !webhookTimestampFresh(timestamp, time.Now()) {
+		slog.Warn("Rejected Customer.io webhook with stale timestamp", "ip", c.IP(), "timestamp", timestamp)
+		return c.Status(401).JSON(fiber.Map{
+			"success": false,
+			"message": "Stale timestamp",
+		})
+	}
+
+	var event CustomerIOWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		slog.Warn("Failed to parse Customer.io webhook body", "ip", c.IP(), "error", err)
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid event format",
+		})
+	}
+
+	action, tracked := webhookActions[event.Metric]
+	if !tracked {
+		slog.Debug("Ignoring Customer.io webhook event", "metric", event.Metric, "event_id", event.EventID)
+		return c.JSON(fiber.Map{"success": true, "recorded": false})
+	}
+
+	email, err := validateEmail(event.email())
+	if err != nil {
+		// Acknowledge so Customer.io does not retry an event we can never record
+		slog.Warn("Customer.io webhook event has no usable email", "metric", event.Metric, "event_id", event.EventID, "customer_id", event.Data.CustomerID)
+		return c.JSON(fiber.Map{"success": true, "recorded": false})
+	}
+
+	details := recordDetails(map[string]interface{}{"metric": event.Metric, "event_id": event.EventID})
+	inserted, err := insertEmailProcessingRecords([]recordInsert{{Email: email, Action: action, Details: details, EventID: event.EventID}})
+	if err != nil {
+		// 500 lets Customer.io retry the delivery later
+		slog.Error("Failed to record Customer.io webhook event", "email", logEmail(email), "action", action, "event_id", event.EventID, "error", err)
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to record event",
+		})
+	}
+
+	if inserted == 0 {
+		// A redelivery of an event already recorded, or DEDUPE_DAILY_ACTIONS already has this action today
+		slog.Info("Customer.io webhook event already recorded", "email", logEmail(email), "action", action, "metric", event.Metric, "event_id", event.EventID)
+		return c.JSON(fiber.Map{"success": true, "recorded": false})
+	}
+	slog.Info("Recorded Customer.io webhook event", "email", logEmail(email), "action", action, "metric", event.Metric, "event_id", event.EventID)
+	return c.JSON(fiber.Map{"success": true, "recorded": true})
+}